@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -12,21 +13,23 @@ import (
 	"mycache/pkg/log"
 	"mycache/proxy"
 
-	// "overlord/pkg/prom"
+	"mycache/pkg/prom"
 
-	// "overlord/proxy/slowlog"
+	"mycache/proxy/slowlog"
 	"mycache/version"
 )
 
 var (
-	check bool   //检查配置文件选项
-	pprof string //pprof程序性能监控
-	// metrics         bool //指标监控开关
+	check           bool   //检查配置文件选项
+	pprof           string //pprof程序性能监控
+	metrics         bool   //指标监控开关
 	confFile        string //主城外部配置文件
 	clusterConfFile string //node外部配置文件
 	reload          bool   //监视集群配置文件目录变动
-	// slowlogFile       string
-	// slowlogSlowerThan int
+	adminAddr       string //-t时打印运行中的proxy reload会做的变更
+
+	slowlogFile       string //慢日志文件，按天滚动
+	slowlogSlowerThan int    //覆盖所有集群的slowlog_slower_than
 )
 
 // type clustersFlag []string
@@ -48,14 +51,15 @@ var usage = func() {
 
 func init() {
 	flag.Usage = usage
-	flag.BoolVar(&check, "t", false, "conf file check")
+	flag.BoolVar(&check, "t", false, "conf file check, or print the changes that reload would apply to the running proxy of -admin.")
 	flag.StringVar(&pprof, "pprof", "", "stat listen addr. high priority than conf.pprof")
-	// flag.BoolVar(&metrics, "metrics", false, "proxy support prometheus metrics and reuse pprof port.")
+	flag.BoolVar(&metrics, "metrics", false, "proxy support prometheus metrics and reuse pprof port.")
 	flag.StringVar(&confFile, "conf", "", "conf file of proxy itself.")
 	flag.StringVar(&clusterConfFile, "cluster", "", "conf file of backend cluster.")
-	flag.BoolVar(&reload, "reload", false, "reloading the servers in cluster config file.")
-	// flag.StringVar(&slowlogFile, "slowlog", "", "slowlog is the file where slowlog output")
-	// flag.IntVar(&slowlogSlowerThan, "slower-than", 0, "slower-than is the microseconds which slowlog must slower than.")
+	flag.BoolVar(&reload, "reload", false, "reloading the cluster config file when it changes, SIGHUP reloads both conf files.")
+	flag.StringVar(&adminAddr, "admin", "", "admin(pprof) addr of the running proxy, used with -t.")
+	flag.StringVar(&slowlogFile, "slowlog", "", "slowlog is the file where slowlog output")
+	flag.IntVar(&slowlogSlowerThan, "slower-than", 0, "slower-than is the microseconds which slowlog must slower than.")
 }
 
 func main() {
@@ -65,6 +69,9 @@ func main() {
 	}
 
 	if check {
+		if adminAddr != "" {
+			os.Exit(reloadDryRun(adminAddr))
+		}
 		//配置信息的验证
 		parseConfig()
 		os.Exit(0)
@@ -74,85 +81,136 @@ func main() {
 		defer log.Close()
 	}
 	// init slowlog if need
-	// err := slowlog.Init(slowlogFile)
-	// if err != nil {
-	// 	log.Errorf("fail to init slowlog due %s", err)
-	// }
+	if err := slowlog.Init(slowlogFile); err != nil {
+		log.Errorf("fail to init slowlog due %s", err)
+	}
+	defer slowlog.Close()
 
+	// pprof
+	if c.Pprof != "" {
+		// NOTE: init metrics before serving, the conns are counted from the first one
+		if c.Proxy.UseMetrics {
+			prom.Init()
+			prom.VersionState(version.Str())
+		}
+		//新协程去监听处理采集请求
+		go http.ListenAndServe(c.Pprof, nil)
+	}
 	// new proxy
 	p, err := proxy.New(c)
 	if err != nil {
 		panic(err)
 	}
 	defer p.Close()
+	p.SetConfLoader(loadConfig)
+	if c.Pprof != "" {
+		p.HandleAdmin(http.DefaultServeMux)
+	}
 	//本线程去监听，新线程去处理proxy请求（且是非阻塞去建立tcp连接）
 	p.Serve(ccs)
 	if reload {
 		//新线程去监视
 		go p.MonitorConfChange(clusterConfFile)
 	}
-	// pprof
-	if c.Pprof != "" {
-		//新协程去监听处理采集请求
-		go http.ListenAndServe(c.Pprof, nil)
-		// if c.Proxy.UseMetrics {
-		// 	prom.Init()
-		// } else {
-		// 	prom.On = false
-		// }
-	}
-	////prom.VersionState(version.Str())
 	// hanlde signal
-	signalHandler()
+	signalHandler(p)
 }
 
 func parseConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig) {
+	c, ccs, err := loadConfig()
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// loadConfig load the conf files and apply the flags, which is also used by reload.
+func loadConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig, err error) {
 	if confFile != "" {
 		c = &proxy.Config{}
-		if err := c.LoadFromFile(confFile); err != nil {
-			panic(err)
+		if err = c.LoadFromFile(confFile); err != nil {
+			return
 		}
 	} else {
 		//外置配置文件没有，就使用内置字面量的值
 		c = proxy.DefaultConfig()
 	}
 	// high priority start
-	// if stat != "" {
-	// 	c.Stat = stat
-	// }
+	if c.Config != nil {
+		log.Flags(c.Config)
+	}
+	if pprof != "" {
+		c.Pprof = pprof
+	}
 
-	// if metrics {
-	// 	c.Proxy.UseMetrics = metrics
-	// }
+	if metrics {
+		c.Proxy.UseMetrics = metrics
+	}
 	// high priority end
-	var tmpCCS, err = proxy.LoadClusterConf(clusterConfFile)
+	tmpCCS, err := proxy.LoadClusterConf(clusterConfFile)
 	if err != nil {
-		panic(err)
+		return
 	}
 
 	// reset slowlogslowerthan
-	// if slowlogSlowerThan > 0 {
-	// 	for _, cc := range tmpCCS {
-	// 		cc.SlowlogSlowerThan = slowlogSlowerThan
-	// 	}
-	// }
+	if slowlogSlowerThan > 0 {
+		for _, cc := range tmpCCS {
+			cc.SlowlogSlowerThan = slowlogSlowerThan
+		}
+	}
 
 	ccs = tmpCCS
 	return
 }
 
-func signalHandler() {
+// reloadDryRun print the changes that reload would apply to the running proxy, returns the exit code.
+func reloadDryRun(addr string) int {
+	resp, err := http.Post("http://"+addr+"/reload?dry_run=1", "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reload dry run error:%v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		fmt.Fprintf(os.Stderr, "reload dry run error:%s %s\n", resp.Status, e.Error)
+		return 1
+	}
+	var reports []*proxy.ReloadReport
+	if err = json.NewDecoder(resp.Body).Decode(&reports); err != nil {
+		fmt.Fprintf(os.Stderr, "reload dry run decode error:%v\n", err)
+		return 1
+	}
+	if len(reports) == 0 {
+		fmt.Println("nothing to reload")
+		return 0
+	}
+	for _, r := range reports {
+		fmt.Println(r)
+	}
+	return 0
+}
+
+func signalHandler(p *proxy.Proxy) {
 	var ch = make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	log.Infof("mycache  proxy version[%s] start serving", version.Str())
 	for {
-		log.Infof("mycache  proxy version[%s] start serving", version.Str())
 		si := <-ch
-		log.Infof("mycache  proxy version[%s] signal(%s) stop the process", version.Str(), si.String())
 		switch si {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+			log.Infof("mycache  proxy version[%s] signal(%s) stop the process", version.Str(), si.String())
 			log.Infof("mycache  proxy version[%s] exited", version.Str())
 			return
 		case syscall.SIGHUP:
+			//重新加载-conf和-cluster配置文件
+			log.Infof("mycache  proxy version[%s] signal(%s) reload the conf files", version.Str(), si.String())
+			if _, err := p.ReloadConf(false); err != nil {
+				log.Errorf("failed to reload conf files and got error:%v", err)
+			}
 		default:
 			return
 		}
@@ -61,14 +61,7 @@ func Init(c *Config) (b bool) {
 	if c == nil {
 		c = &Config{}
 	}
-	if logFile != "" {
-		c.Log = logFile
-	}
-	if logVl != 0 {
-		c.LogVL = logVl
-	}
-	c.Stdout = logStd
-	c.Debug = debug
+	Flags(c)
 	if c.Debug || c.Stdout {
 		hs = append(hs, NewStdHandler())
 	}
@@ -85,6 +78,18 @@ func Init(c *Config) (b bool) {
 	return
 }
 
+// Flags set the config by the command line flags, which are high priority than the config file.
+func Flags(c *Config) {
+	if logFile != "" {
+		c.Log = logFile
+	}
+	if logVl != 0 {
+		c.LogVL = logVl
+	}
+	c.Stdout = logStd
+	c.Debug = debug
+}
+
 // InitHandle with log handle.
 func InitHandle(hs ...Handler) {
 	h = Handlers(hs)
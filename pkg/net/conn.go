@@ -3,6 +3,8 @@ package net
 import (
 	"errors"
 	"net"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -11,12 +13,24 @@ var (
 	ErrConnClosed = errors.New("connection is closed")
 )
 
+// UnixPrefix 后端unix socket地址的前缀，如unix:/var/run/redis.sock
+const UnixPrefix = "unix:"
+
+// SplitNetwork returns the network and the address to dial, addr is like ip:port or unix:/path.
+func SplitNetwork(addr string) (network, address string) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		return "unix", addr[len(UnixPrefix):]
+	}
+	return "tcp", addr
+}
+
 // Conn 系统级net.Conn接口实例的内嵌
 // add:增加一些超时设置
 type Conn struct {
 	addr string
 	net.Conn
 	closed bool
+	tls    *TLS //拨号时的TLS配置，nil不加密
 
 	dialTimeout  time.Duration
 	readTimeout  time.Duration
@@ -25,7 +39,8 @@ type Conn struct {
 
 //DialWithTimeout 新建一个有超时控制的conn
 func DialWithTimeout(addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (c *Conn) {
-	sock, _ := net.DialTimeout("tcp", addr, dialTimeout)
+	network, address := SplitNetwork(addr)
+	sock, _ := net.DialTimeout(network, address, dialTimeout)
 	//tips:
 	//内嵌interface net.Conn字段初始化必需是实现了该net.Conn接口的任意非接口类型
 	//不是struct自己要实现，是他成员对象要实现这个内嵌接口，他成员实现了他自己也就有了（继承）
@@ -39,9 +54,14 @@ func NewConn(sock net.Conn, readTimeout, writeTimeout time.Duration) (c *Conn) {
 	return
 }
 
+// Addr returns the address dialed, it is empty for the conn created by NewConn.
+func (c *Conn) Addr() string {
+	return c.addr
+}
+
 //Dup 根据自身拨号信息 重新拨号新建conn链接
 func (c *Conn) Dup() *Conn {
-	return DialWithTimeout(c.addr, c.dialTimeout, c.readTimeout, c.writeTimeout)
+	return DialTLSWithTimeout(c.addr, c.tls, c.dialTimeout, c.readTimeout, c.writeTimeout)
 }
 
 //Read 定义链接读超时
@@ -61,6 +81,15 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 	return
 }
 
+//SetReadTimeout 修改读超时，0表示不超时
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	c.readTimeout = timeout
+	if timeout == 0 && c.Conn != nil {
+		// NOTE: Read不再设置截止时间，清除上次读留下的，否则复用的连接会读超时
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
 //Write 定义链接写超时
 func (c *Conn) Write(b []byte) (n int, err error) {
 	if c.closed || c.Conn == nil {
@@ -75,6 +104,60 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	return
 }
 
+// CloseRead shutdown the reading side, the blocked Read returns io.EOF and the replies can still be written.
+// NOTE: only the blocked Read is interrupted by deadline if the conn can not shutdown the reading side.
+func (c *Conn) CloseRead() error {
+	if c.Conn == nil {
+		return ErrConnClosed
+	}
+	sock := c.Conn
+	if nc, ok := sock.(interface{ NetConn() net.Conn }); ok {
+		sock = nc.NetConn() // tls
+	}
+	if cr, ok := sock.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return c.Conn.SetReadDeadline(time.Now())
+}
+
+// WatchClose watch the peer while no data is expected from it, gone is called once the peer closes the conn.
+// The returned stop ends the watching, it must be called before the next Read.
+// NOTE: data sent by the peer is not consumed, the watching just ends as the data arrives.
+func (c *Conn) WatchClose(gone func()) (stop func()) {
+	stop = func() {}
+	if c.closed || c.Conn == nil {
+		return
+	}
+	sock := c.Conn
+	if nc, ok := sock.(interface{ NetConn() net.Conn }); ok {
+		sock = nc.NetConn() // tls
+	}
+	sc, ok := sock.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+	// NOTE: 清除上次Read设置的截止时间，阻塞命令可能等待很久
+	if err = sock.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if peekClosed(rc) {
+			gone()
+		}
+	}()
+	return func() {
+		_ = sock.SetReadDeadline(time.Now())
+		<-done
+		_ = sock.SetReadDeadline(time.Time{})
+	}
+}
+
 // Close 关闭链接.关闭真正持有链接的对象
 func (c *Conn) Close() error {
 	if c.Conn != nil && !c.closed {
@@ -1,7 +1,11 @@
 package net
 
 import (
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -100,18 +104,22 @@ func TestConnNoConn(t *testing.T) {
 	l, err := net.ListenTCP("tcp", addr)
 	assert.NoError(t, err)
 	laddr := l.Addr()
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		defer l.Close()
 		buf := make([]byte, 1024)
-		for {
-			sock, err := l.Accept()
-			assert.NoError(t, err)
-			n, err := sock.Read(buf)
-			assert.NoError(t, err)
-			assert.NotZero(t, n)
-		}
+		sock, err := l.Accept()
+		assert.NoError(t, err)
+		defer sock.Close()
+		n, err := sock.Read(buf)
+		assert.NoError(t, err)
+		assert.NotZero(t, n)
 	}()
 	conn := DialWithTimeout(laddr.String(), time.Second, time.Second, time.Second)
+	// NOTE: keep the client sock, it is closed by its finalizer once it is collected
+	sock := conn.Conn
+	defer sock.Close()
 	conn.Conn = nil
 
 	bs := make([]byte, 1)
@@ -127,4 +135,143 @@ func TestConnNoConn(t *testing.T) {
 	n64, err := conn.Writev(&buffers)
 	assert.Equal(t, int64(0), n64)
 	assert.Equal(t, ErrConnClosed, err)
+
+	_, err = sock.Write(bs)
+	assert.NoError(t, err)
+	<-done
+}
+
+func TestSplitNetwork(t *testing.T) {
+	network, address := SplitNetwork("unix:/var/run/redis.sock")
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/var/run/redis.sock", address)
+	network, address = SplitNetwork("127.0.0.1:6379")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:6379", address)
+}
+
+func TestUnixListenAndDial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.sock")
+	l, err := Listen("unix", path)
+	assert.NoError(t, err)
+	assert.NoError(t, SetUnixPerm(path, 0600, ""))
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	_echoTLS(t, l)
+	conn := DialWithTimeout(UnixPrefix+path, time.Second, time.Second, time.Second)
+	assert.NotNil(t, conn.Conn)
+	reply, err := _echo(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", reply)
+	conn.Close()
+
+	// NOTE: the socket file is removed on close
+	assert.NoError(t, l.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConnCloseRead(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	sock, err := l.Accept()
+	assert.NoError(t, err)
+	conn := NewConn(sock, 0, time.Second)
+	defer conn.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, rerr := conn.Read(make([]byte, 8))
+		errCh <- rerr
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, conn.CloseRead())
+	select {
+	case err = <-errCh:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("read is not interrupted")
+	}
+	// NOTE: the reply can still be written
+	_, err = conn.Write([]byte("ok"))
+	assert.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(client, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(buf))
+}
+
+func TestConnSetReadTimeoutZero(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	sock, err := l.Accept()
+	assert.NoError(t, err)
+	conn := NewConn(sock, 20*time.Millisecond, time.Second)
+	defer conn.Close()
+
+	_, err = client.Write([]byte("a"))
+	assert.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.NoError(t, err)
+	// NOTE: the deadline of the last read is passed
+	time.Sleep(50 * time.Millisecond)
+	conn.SetReadTimeout(0)
+	_, err = client.Write([]byte("b"))
+	assert.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
+
+func TestConnWatchClose(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	sock, err := l.Accept()
+	assert.NoError(t, err)
+	conn := NewConn(sock, 20*time.Millisecond, time.Second)
+	defer conn.Close()
+
+	// NOTE: the data sent while watching is kept for the next read
+	gone := make(chan struct{}, 1)
+	stop := conn.WatchClose(func() { gone <- struct{}{} })
+	time.Sleep(50 * time.Millisecond)
+	_, err = client.Write([]byte("a"))
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	stop()
+	assert.Len(t, gone, 0)
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(buf))
+
+	// NOTE: stop without anything received
+	stop = conn.WatchClose(func() { gone <- struct{}{} })
+	stop()
+	assert.Len(t, gone, 0)
+
+	stop = conn.WatchClose(func() { gone <- struct{}{} })
+	defer stop()
+	assert.NoError(t, client.Close())
+	select {
+	case <-gone:
+	case <-time.After(time.Second):
+		t.Fatal("close of peer is not watched")
+	}
 }
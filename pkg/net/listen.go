@@ -3,6 +3,9 @@ package net
 import (
 	"net"
 	"os"
+	"os/user"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -37,3 +40,53 @@ func listenUnix(addr string) (net.Listener, error) {
 	}
 	return net.ListenUnix("unix", unixAddr)
 }
+
+// SetUnixPerm change the file mode and owner of unix socket, owner is like user:group or uid:gid, the group is optional.
+// NOTE: the socket file is removed when the listener is closed.
+func SetUnixPerm(path string, mode os.FileMode, owner string) (err error) {
+	if mode != 0 {
+		if err = os.Chmod(path, mode); err != nil {
+			return errors.Wrapf(err, "Proxy Listen unix chmod %s", path)
+		}
+	}
+	if owner == "" {
+		return
+	}
+	uid, gid, err := lookupOwner(owner)
+	if err != nil {
+		return
+	}
+	if err = os.Chown(path, uid, gid); err != nil {
+		return errors.Wrapf(err, "Proxy Listen unix chown %s", path)
+	}
+	return
+}
+
+// lookupOwner returns the uid and gid of owner, gid is -1 if the group is not set.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	gid = -1
+	name, group := owner, ""
+	if idx := strings.IndexByte(owner, ':'); idx != -1 {
+		name, group = owner[:idx], owner[idx+1:]
+	}
+	if uid, err = strconv.Atoi(name); err != nil {
+		u, lerr := user.Lookup(name)
+		if lerr != nil {
+			return 0, 0, errors.Wrapf(lerr, "Proxy Listen unix owner %s", owner)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		err = nil
+	}
+	if group == "" {
+		return
+	}
+	if gid, err = strconv.Atoi(group); err != nil {
+		g, lerr := user.LookupGroup(group)
+		if lerr != nil {
+			return 0, 0, errors.Wrapf(lerr, "Proxy Listen unix group %s", owner)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package net
+
+import (
+	"syscall"
+)
+
+// peekClosed wait until the conn is readable, it returns true if the peer closed the conn.
+// The data is peeked and kept in the socket, it returns false if the waiting is interrupted by deadline.
+func peekClosed(rc syscall.RawConn) (closed bool) {
+	buf := make([]byte, 1)
+	err := rc.Read(func(fd uintptr) bool {
+		n, _, rerr := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		if rerr == syscall.EAGAIN || rerr == syscall.EINTR {
+			return false // NOTE: wait for readable
+		}
+		closed = n <= 0
+		return true
+	})
+	return err == nil && closed
+}
@@ -0,0 +1,10 @@
+package net
+
+import (
+	"syscall"
+)
+
+// peekClosed is not supported on windows, it waits for nothing.
+func peekClosed(rc syscall.RawConn) (closed bool) {
+	return false
+}
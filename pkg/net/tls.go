@@ -0,0 +1,180 @@
+package net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"mycache/pkg/log"
+
+	"github.com/pkg/errors"
+)
+
+// tlsCheckInterval 检查证书文件是否变化的间隔
+const tlsCheckInterval = time.Second
+
+// TLSOptions the files and options of TLS, the files are reloaded when they change.
+type TLSOptions struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string // 服务端用来校验客户端证书(mTLS)，客户端用来校验服务端证书，为空用系统CA
+	ServerName string // 客户端校验的服务端名字(SNI)，为空用地址里的host
+	SkipVerify bool   // 客户端不校验服务端证书，只用于测试
+}
+
+type tlsKey struct {
+	TLSOptions
+	server bool
+}
+
+// tlsCache 相同的配置共用一个TLS，避免每次拨号都加载证书
+var tlsCache sync.Map
+
+// TLS keeps the tls config which is reloaded when the files change on disk.
+type TLS struct {
+	opts   TLSOptions
+	server bool
+
+	lock    sync.Mutex
+	conf    *tls.Config
+	mod     time.Time // 证书文件最新的修改时间
+	checked time.Time
+}
+
+// ServerTLS returns the TLS of listener, the client certificate is required and verified if CAFile is set.
+func ServerTLS(opts TLSOptions) (*TLS, error) {
+	return loadTLS(opts, true)
+}
+
+// ClientTLS returns the TLS of dialing, the certificate is sent to server if CertFile is set.
+// NOTE: the TLS is returned even if fail to load, which fails every dialing until the files are fixed.
+func ClientTLS(opts TLSOptions) (*TLS, error) {
+	return loadTLS(opts, false)
+}
+
+func loadTLS(opts TLSOptions, server bool) (*TLS, error) {
+	key := tlsKey{TLSOptions: opts, server: server}
+	if t, ok := tlsCache.Load(key); ok {
+		return t.(*TLS), t.(*TLS).Err()
+	}
+	t := &TLS{opts: opts, server: server}
+	t.lock.Lock()
+	err := t.reload()
+	t.lock.Unlock()
+	if err != nil {
+		return t, err
+	}
+	actual, _ := tlsCache.LoadOrStore(key, t)
+	return actual.(*TLS), nil
+}
+
+// Err returns the error if the tls config is not loaded.
+func (t *TLS) Err() error {
+	if t.Config() == nil {
+		return errors.Errorf("tls config of cert:%s key:%s ca:%s is not loaded", t.opts.CertFile, t.opts.KeyFile, t.opts.CAFile)
+	}
+	return nil
+}
+
+// Config returns the current tls config, the files are checked at most once every second.
+func (t *TLS) Config() *tls.Config {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if now := time.Now(); now.Sub(t.checked) >= tlsCheckInterval {
+		t.checked = now
+		if err := t.reload(); err != nil {
+			// NOTE: keep the old config, the files may be written half
+			log.Warnf("fail to reload tls files cert:%s key:%s ca:%s with error:%v", t.opts.CertFile, t.opts.KeyFile, t.opts.CAFile, err)
+		}
+	}
+	return t.conf
+}
+
+// reload load the files again if any of them is modified.
+// NOTE: t.lock must be held.
+func (t *TLS) reload() (err error) {
+	var mod time.Time
+	for _, file := range []string{t.opts.CertFile, t.opts.KeyFile, t.opts.CAFile} {
+		if file == "" {
+			continue
+		}
+		fi, serr := os.Stat(file)
+		if serr != nil {
+			return errors.WithStack(serr)
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	if t.conf != nil && !mod.After(t.mod) {
+		return
+	}
+	conf := &tls.Config{ServerName: t.opts.ServerName, InsecureSkipVerify: t.opts.SkipVerify}
+	if t.opts.CertFile != "" || t.opts.KeyFile != "" {
+		cert, lerr := tls.LoadX509KeyPair(t.opts.CertFile, t.opts.KeyFile)
+		if lerr != nil {
+			return errors.WithStack(lerr)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	} else if t.server {
+		return errors.New("certificate is required by tls listener")
+	}
+	if t.opts.CAFile != "" {
+		pem, rerr := ioutil.ReadFile(t.opts.CAFile)
+		if rerr != nil {
+			return errors.WithStack(rerr)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate in ca file:%s", t.opts.CAFile)
+		}
+		if t.server {
+			conf.ClientCAs = pool
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			conf.RootCAs = pool
+		}
+	}
+	t.conf, t.mod = conf, mod
+	return
+}
+
+// ListenTLS listen and serve tls on the accepted conns, the config is reloaded for new conns.
+func ListenTLS(proto string, addr string, t *TLS) (net.Listener, error) {
+	l, err := Listen(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			if conf := t.Config(); conf != nil {
+				return conf, nil
+			}
+			return nil, t.Err()
+		},
+	}), nil
+}
+
+// DialTLSWithTimeout dial with tls and handshake within the dial timeout, it is the same as DialWithTimeout if t is nil.
+func DialTLSWithTimeout(addr string, t *TLS, dialTimeout, readTimeout, writeTimeout time.Duration) (c *Conn) {
+	if t == nil {
+		return DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	}
+	c = &Conn{addr: addr, tls: t, dialTimeout: dialTimeout, readTimeout: readTimeout, writeTimeout: writeTimeout}
+	conf := t.Config()
+	if conf == nil {
+		return
+	}
+	network, address := SplitNetwork(addr)
+	sock, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, network, address, conf)
+	if err != nil {
+		log.Warnf("fail to dial tls addr:%s with error:%v", addr, err)
+		return
+	}
+	c.Conn = sock
+	return
+}
@@ -0,0 +1,206 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// _writeCert 生成由ca签发的证书，ca为nil时生成自签的CA，写入dir/name.crt和dir/name.key
+func _writeCert(t *testing.T, dir, name string, serial int64, ca *_testCert) *_testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, parentKey := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return &_testCert{cert: cert, key: key}
+}
+
+// _echoTLS 接受一个连接，把读到的数据原样写回
+func _echoTLS(t *testing.T, l net.Listener) {
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		conn.Write(buf[:n])
+	}()
+}
+
+func _echo(conn *Conn) (string, error) {
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+func TestTLSListenAndDial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := _writeCert(t, dir, "ca", 1, nil)
+	_writeCert(t, dir, "server", 2, ca)
+
+	st, err := ServerTLS(TLSOptions{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")})
+	assert.NoError(t, err)
+	l, err := ListenTLS("tcp", "127.0.0.1:0", st)
+	assert.NoError(t, err)
+	defer l.Close()
+	_echoTLS(t, l)
+
+	ct, err := ClientTLS(TLSOptions{CAFile: filepath.Join(dir, "ca.crt"), ServerName: "localhost"})
+	assert.NoError(t, err)
+	conn := DialTLSWithTimeout(l.Addr().String(), ct, time.Second, time.Second, time.Second)
+	assert.NotNil(t, conn.Conn)
+	defer conn.Close()
+	reply, err := _echo(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", reply)
+
+	// NOTE: the same options share the TLS
+	again, err := ClientTLS(TLSOptions{CAFile: filepath.Join(dir, "ca.crt"), ServerName: "localhost"})
+	assert.NoError(t, err)
+	assert.True(t, ct == again)
+
+	// NOTE: fail to verify the self-signed ca of server
+	_echoTLS(t, l)
+	conn = DialTLSWithTimeout(l.Addr().String(), &TLS{conf: &tls.Config{}, checked: time.Now()}, time.Second, time.Second, time.Second)
+	assert.Nil(t, conn.Conn)
+}
+
+func TestTLSMutual(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := _writeCert(t, dir, "ca", 1, nil)
+	_writeCert(t, dir, "server", 2, ca)
+	_writeCert(t, dir, "client", 3, ca)
+
+	st, err := ServerTLS(TLSOptions{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key"), CAFile: filepath.Join(dir, "ca.crt")})
+	assert.NoError(t, err)
+	l, err := ListenTLS("tcp", "127.0.0.1:0", st)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	_echoTLS(t, l)
+	ct, err := ClientTLS(TLSOptions{SkipVerify: true})
+	assert.NoError(t, err)
+	conn := DialTLSWithTimeout(l.Addr().String(), ct, time.Second, time.Second, time.Second)
+	if conn.Conn != nil {
+		// NOTE: the client certificate is checked after the handshake of client with TLS 1.3
+		_, err = _echo(conn)
+		assert.Error(t, err)
+		conn.Close()
+	}
+
+	_echoTLS(t, l)
+	ct, err = ClientTLS(TLSOptions{CertFile: filepath.Join(dir, "client.crt"), KeyFile: filepath.Join(dir, "client.key"), CAFile: filepath.Join(dir, "ca.crt")})
+	assert.NoError(t, err)
+	conn = DialTLSWithTimeout(l.Addr().String(), ct, time.Second, time.Second, time.Second)
+	assert.NotNil(t, conn.Conn)
+	defer conn.Close()
+	reply, err := _echo(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", reply)
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := _writeCert(t, dir, "ca", 1, nil)
+	_writeCert(t, dir, "server", 2, ca)
+
+	st, err := ServerTLS(TLSOptions{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")})
+	assert.NoError(t, err)
+	l, err := ListenTLS("tcp", "127.0.0.1:0", st)
+	assert.NoError(t, err)
+	defer l.Close()
+	ct, err := ClientTLS(TLSOptions{CAFile: filepath.Join(dir, "ca.crt")})
+	assert.NoError(t, err)
+
+	serial := func() int64 {
+		_echoTLS(t, l)
+		conn := DialTLSWithTimeout(l.Addr().String(), ct, time.Second, time.Second, time.Second)
+		assert.NotNil(t, conn.Conn)
+		defer conn.Close()
+		_, err := _echo(conn)
+		assert.NoError(t, err)
+		return conn.Conn.(*tls.Conn).ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(2), serial())
+
+	_writeCert(t, dir, "server", 4, ca)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "server.crt"), future, future))
+	st.lock.Lock()
+	st.checked = time.Time{} // NOTE: check the files now
+	st.lock.Unlock()
+	assert.Equal(t, int64(4), serial())
+
+	// NOTE: keep the old config if the files are broken
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "server.key"), []byte("broken"), 0600))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "server.key"), future, future))
+	st.lock.Lock()
+	st.checked = time.Time{}
+	st.lock.Unlock()
+	assert.Equal(t, int64(4), serial())
+}
+
+func TestTLSServerNoCert(t *testing.T) {
+	_, err := ServerTLS(TLSOptions{})
+	assert.Error(t, err)
+	ct, err := ClientTLS(TLSOptions{CAFile: "/not/exist/ca.crt"})
+	assert.Error(t, err)
+	assert.NotNil(t, ct)
+	conn := DialTLSWithTimeout("127.0.0.1:1", ct, time.Second, time.Second, time.Second)
+	assert.Nil(t, conn.Conn)
+}
@@ -0,0 +1,134 @@
+package prom
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mycache"
+
+// stages of command latency
+const (
+	StageTotal  = "total"  // 解码到回写客户端
+	StageRemote = "remote" // 写后端到读完后端回复
+	StagePipe   = "pipe"   // 转发到回复
+	StageInput  = "input"  // 在pipe的input chan里等待
+)
+
+// ping events of node
+const (
+	PingFail  = "fail"  // ping失败
+	PingEject = "eject" // 失败次数达到上限，剔除出hash环
+	PingReadd = "readd" // ping恢复，重新加入hash环
+)
+
+// reload results
+const (
+	ReloadSuccess = "success"
+	ReloadFail    = "fail"
+)
+
+var (
+	// On is true if the metrics are exposed, the callers should check it before recording.
+	On bool
+
+	conns = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "conns",
+		Help:      "The number of client connections of cluster.",
+	}, []string{"cluster"})
+
+	cmdDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "command_duration_seconds",
+		Help:      "The latency of commands by cluster, command and stage.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15), // 100us ~ 1.6s
+	}, []string{"cluster", "cmd", "stage"})
+
+	errs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "errors_total",
+		Help:      "The number of errors by cluster, node, command and reason.",
+	}, []string{"cluster", "node", "cmd", "reason"})
+
+	pings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "ping_events_total",
+		Help:      "The number of ping failures, ejections and readditions of nodes.",
+	}, []string{"cluster", "node", "event"})
+
+	reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "reloads_total",
+		Help:      "The number of cluster config reloads by result.",
+	}, []string{"cluster", "result"})
+
+	failedShards = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "failed_shards_total",
+		Help:      "The number of failed shards in MSET/MGET/DEL/EXISTS which are partially succeed.",
+	})
+
+	versionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "version",
+		Help:      "The version of proxy.",
+	}, []string{"version"})
+)
+
+// Init register the metrics and serve /metrics on http.DefaultServeMux, which is served on the pprof address.
+func Init() {
+	prometheus.MustRegister(conns, cmdDuration, errs, pings, reloads, failedShards, versionState)
+	http.Handle("/metrics", promhttp.Handler())
+	On = true
+}
+
+// ConnIncr increase the client connections of cluster.
+func ConnIncr(cluster string) {
+	conns.WithLabelValues(cluster).Inc()
+}
+
+// ConnDecr decrease the client connections of cluster.
+func ConnDecr(cluster string) {
+	conns.WithLabelValues(cluster).Dec()
+}
+
+// ProxyTime observe the latency of command at the stage.
+func ProxyTime(cluster, cmd, stage string, d time.Duration) {
+	cmdDuration.WithLabelValues(cluster, cmd, stage).Observe(d.Seconds())
+}
+
+// ErrIncr increase the errors of node.
+func ErrIncr(cluster, node, cmd, reason string) {
+	errs.WithLabelValues(cluster, node, cmd, reason).Inc()
+}
+
+// PingEvent increase the ping event of node.
+func PingEvent(cluster, node, event string) {
+	pings.WithLabelValues(cluster, node, event).Inc()
+}
+
+// ReloadIncr increase the reload result of cluster.
+func ReloadIncr(cluster, result string) {
+	reloads.WithLabelValues(cluster, result).Inc()
+}
+
+// FailedShardsAdd increase the failed shards of multi-key commands.
+func FailedShardsAdd(n int) {
+	failedShards.Add(float64(n))
+}
+
+// VersionState set the version of proxy.
+func VersionState(version string) {
+	versionState.WithLabelValues(version).Set(1)
+}
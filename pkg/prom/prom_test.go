@@ -0,0 +1,46 @@
+package prom
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	Init()
+	assert.True(t, On)
+	ConnIncr("test")
+	ConnIncr("test")
+	ConnDecr("test")
+	ProxyTime("test", "GET", StageTotal, time.Millisecond)
+	ErrIncr("test", "127.0.0.1:6379", "GET", "timeout")
+	PingEvent("test", "127.0.0.1:6379", PingEject)
+	ReloadIncr("test", ReloadSuccess)
+	FailedShardsAdd(2)
+	VersionState("0.0.1")
+
+	srv := httptest.NewServer(http.DefaultServeMux)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	for _, s := range []string{
+		`mycache_proxy_conns{cluster="test"} 1`,
+		`mycache_proxy_command_duration_seconds_count{cluster="test",cmd="GET",stage="total"} 1`,
+		`mycache_proxy_errors_total{cluster="test",cmd="GET",node="127.0.0.1:6379",reason="timeout"} 1`,
+		`mycache_proxy_ping_events_total{cluster="test",event="eject",node="127.0.0.1:6379"} 1`,
+		`mycache_proxy_reloads_total{cluster="test",result="success"} 1`,
+		`mycache_proxy_failed_shards_total 2`,
+		`mycache_proxy_version{version="0.0.1"} 1`,
+	} {
+		assert.Contains(t, string(body), s)
+	}
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/log"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// redacted replaces the secrets of config in admin api.
+const redacted = "******"
+
+// secretKeys the config keys which are redacted.
+var secretKeys = map[string]bool{
+	"password":   true,
+	"redis_auth": true,
+}
+
+// HandleAdmin register the admin api of proxy on mux, it is served on the pprof address:
+//
+//	GET  /clusters                                   the clusters with effective config
+//	GET  /clusters/{name}/nodes                      the nodes of cluster
+//	POST /clusters/{name}/nodes/eject?node=          eject the node by address or alias from hash ring
+//	POST /clusters/{name}/nodes/readd?node=          readd the ejected node
+//	POST /clusters/{name}/nodes/weight?node=&weight= change the weight of node
+//	GET  /clients?cluster=                           the client conns, of all clusters if cluster is empty
+//	POST /reload?dry_run=1                           reload the config files, nothing is applied if dry_run
+func (p *Proxy) HandleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/clusters", p.adminClusters)
+	mux.HandleFunc("/clusters/", p.adminNodes)
+	mux.HandleFunc("/clients", p.adminClients)
+	mux.HandleFunc("/reload", p.adminReload)
+}
+
+// adminCluster is the cluster shown by admin api.
+type adminCluster struct {
+	Name   string                 `json:"name"`
+	Config map[string]interface{} `json:"config"`
+}
+
+func (p *Proxy) adminClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	p.lock.Lock()
+	clusters := make([]*adminCluster, 0, len(p.clusters))
+	for name, c := range p.clusters {
+		clusters = append(clusters, &adminCluster{Name: name, Config: confMap(reflect.ValueOf(c.current().cc).Elem())})
+	}
+	p.lock.Unlock()
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	adminJSON(w, http.StatusOK, clusters)
+}
+
+// adminNodes serve /clusters/{name}/nodes and /clusters/{name}/nodes/{eject|readd|weight}.
+func (p *Proxy) adminNodes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/clusters/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "nodes" {
+		adminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	p.lock.Lock()
+	c, ok := p.clusters[parts[0]]
+	p.lock.Unlock()
+	if !ok {
+		adminError(w, http.StatusNotFound, "no such cluster")
+		return
+	}
+	forwarder := c.current().forwarder
+	if len(parts) == 2 {
+		if r.Method != http.MethodGet {
+			adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		infos := []*proto.NodeInfo{}
+		if nf, ok := forwarder.(proto.NodeInfoForwarder); ok {
+			infos = append(infos, nf.NodeInfos()...)
+		}
+		adminJSON(w, http.StatusOK, infos)
+		return
+	}
+	if r.Method != http.MethodPost {
+		adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	af, ok := forwarder.(proto.NodeAdminForwarder)
+	if !ok {
+		adminError(w, http.StatusBadRequest, "nodes of cluster can't be changed")
+		return
+	}
+	node := r.URL.Query().Get("node")
+	var err error
+	switch parts[2] {
+	case "eject":
+		err = af.EjectNode(node)
+	case "readd":
+		err = af.ReaddNode(node)
+	case "weight":
+		weight, werr := strconv.Atoi(r.URL.Query().Get("weight"))
+		if werr != nil {
+			adminError(w, http.StatusBadRequest, "weight should be an integer")
+			return
+		}
+		err = af.SetNodeWeight(node, weight)
+	default:
+		adminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch errors.Cause(err) {
+		case ErrForwarderNoSuchNode:
+			code = http.StatusNotFound
+		case ErrForwarderNodeWeight:
+			code = http.StatusBadRequest
+		}
+		adminError(w, code, err.Error())
+		return
+	}
+	adminJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+}
+
+// adminClient is the client conn shown by admin api.
+type adminClient struct {
+	Cluster    string `json:"cluster"`
+	RemoteAddr string `json:"remote_addr"`
+	Age        int64  `json:"age"`  // 连接建立的秒数
+	Idle       int64  `json:"idle"` // 没有请求的秒数
+	Cmds       int64  `json:"cmds"`
+}
+
+func (p *Proxy) adminClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	cluster := r.URL.Query().Get("cluster")
+	now := time.Now()
+	clients := []*adminClient{}
+	p.hlock.Lock()
+	for h := range p.handlers {
+		if cluster != "" && h.cc.Name != cluster {
+			continue
+		}
+		clients = append(clients, &adminClient{
+			Cluster:    h.cc.Name,
+			RemoteAddr: h.conn.RemoteAddr().String(),
+			Age:        int64(now.Sub(h.created) / time.Second),
+			Idle:       int64(now.Sub(time.Unix(0, atomic.LoadInt64(&h.active))) / time.Second),
+			Cmds:       atomic.LoadInt64(&h.cmds),
+		})
+	}
+	p.hlock.Unlock()
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Cluster != clients[j].Cluster {
+			return clients[i].Cluster < clients[j].Cluster
+		}
+		return clients[i].RemoteAddr < clients[j].RemoteAddr
+	})
+	adminJSON(w, http.StatusOK, clients)
+}
+
+func (p *Proxy) adminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	reports, err := p.ReloadConf(dryRun)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if reports == nil {
+		reports = []*ReloadReport{}
+	}
+	adminJSON(w, http.StatusOK, reports)
+}
+
+// confMap convert the config struct to map keyed by toml keys, the secrets are redacted.
+func confMap(v reflect.Value) map[string]interface{} {
+	t := v.Type()
+	m := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fv := v.Field(i)
+		switch {
+		case secretKeys[key] && fv.Kind() == reflect.String && fv.Len() > 0:
+			m[key] = redacted
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Ptr && fv.Type().Elem().Elem().Kind() == reflect.Struct:
+			elems := make([]map[string]interface{}, 0, fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				elems = append(elems, confMap(fv.Index(j).Elem()))
+			}
+			m[key] = elems
+		default:
+			m[key] = fv.Interface()
+		}
+	}
+	return m
+}
+
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("admin write response error:%v", err)
+	}
+}
+
+func adminError(w http.ResponseWriter, code int, msg string) {
+	adminJSON(w, code, map[string]string{"error": msg})
+}
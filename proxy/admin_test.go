@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminReloadDryRun(t *testing.T) {
+	backend := _fakeRedis(t)
+	defer backend.Close()
+	c := DefaultConfig()
+	p, err := New(c)
+	assert.NoError(t, err)
+	newCC := func(name string) *ClusterConfig {
+		cc := &ClusterConfig{Name: name, CacheType: types.CacheTypeRedis, ListenAddr: _freeAddr(t), Servers: []string{backend.Addr().String() + ":1"},
+			DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000}
+		cc.SetDefault()
+		return cc
+	}
+	a := newCC("a")
+	p.Serve([]*ClusterConfig{a})
+	defer p.Close()
+	mux := http.NewServeMux()
+	p.HandleAdmin(mux)
+
+	// no loader and no cluster config file
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reload?dry_run=1", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	nc := DefaultConfig()
+	nc.Pprof = "127.0.0.1:0"
+	nc.Proxy.DrainTimeout = 100
+	b := newCC("b")
+	p.SetConfLoader(func() (*Config, []*ClusterConfig, error) {
+		return nc, []*ClusterConfig{b}, nil
+	})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reload?dry_run=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var reports []*ReloadReport
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&reports))
+	var lines []string
+	for _, r := range reports {
+		lines = append(lines, r.String())
+	}
+	assert.Equal(t, []string{
+		"proxy action=update changed=drain_timeout result=success",
+		"proxy action=ignore changed=pprof result=fail error=restart is required",
+		"cluster=a action=remove result=success",
+		"cluster=b action=add result=success",
+	}, lines)
+	// nothing is applied
+	assert.Equal(t, c, p.conf())
+	_, err = _redisGet(t, a.ListenAddr)
+	assert.NoError(t, err)
+	_, err = _redisGet(t, b.ListenAddr)
+	assert.Error(t, err)
+
+	reports, err = p.ReloadConf(false)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 4)
+	assert.Equal(t, 100, p.conf().Proxy.DrainTimeout)
+	assert.Equal(t, c.Pprof, p.conf().Pprof)
+	_, err = _redisGet(t, b.ListenAddr)
+	assert.NoError(t, err)
+}
+
+func _adminDo(t *testing.T, mux *http.ServeMux, method, url string, v interface{}) int {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	if v != nil && w.Code == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(v))
+	}
+	return w.Code
+}
+
+func TestAdminClustersNodesClients(t *testing.T) {
+	backend := _fakeRedis(t)
+	defer backend.Close()
+	p, err := New(DefaultConfig())
+	assert.NoError(t, err)
+	cc := &ClusterConfig{Name: "admin", CacheType: types.CacheTypeRedis, ListenAddr: _freeAddr(t), Servers: []string{backend.Addr().String() + ":1 node1"},
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000, Password: "pwd", Users: []*UserConfig{{Name: "u", Password: "upwd"}}}
+	cc.SetDefault()
+	p.Serve([]*ClusterConfig{cc})
+	defer p.Close()
+	mux := http.NewServeMux()
+	p.HandleAdmin(mux)
+
+	var clusters []*adminCluster
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clusters", &clusters))
+	if assert.Len(t, clusters, 1) {
+		assert.Equal(t, "admin", clusters[0].Name)
+		assert.Equal(t, redacted, clusters[0].Config["password"])
+		assert.Equal(t, "", clusters[0].Config["redis_auth"])
+		assert.Equal(t, cc.ListenAddr, clusters[0].Config["listen_addr"])
+		users := clusters[0].Config["users"].([]interface{})
+		assert.Equal(t, redacted, users[0].(map[string]interface{})["password"])
+	}
+
+	var nodes []*proto.NodeInfo
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clusters/admin/nodes", &nodes))
+	assert.Equal(t, []*proto.NodeInfo{{Addr: backend.Addr().String(), Alias: "node1", Weight: 1}}, nodes)
+	assert.Equal(t, http.StatusNotFound, _adminDo(t, mux, http.MethodGet, "/clusters/none/nodes", nil))
+	assert.Equal(t, http.StatusNotFound, _adminDo(t, mux, http.MethodPost, "/clusters/admin/nodes/eject?node=none", nil))
+	assert.Equal(t, http.StatusBadRequest, _adminDo(t, mux, http.MethodPost, "/clusters/admin/nodes/weight?node=node1&weight=0", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, _adminDo(t, mux, http.MethodGet, "/clusters/admin/nodes/eject?node=node1", nil))
+
+	// the ejected node takes the new weight when readded
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodPost, "/clusters/admin/nodes/eject?node=node1", nil))
+	conn, err := net.Dial("tcp", cc.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("*2\r\n$4\r\nAUTH\r\n$3\r\npwd\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	reply, _ := br.ReadString('\n')
+	assert.Equal(t, "+OK\r\n", reply)
+	reply, _ = br.ReadString('\n')
+	assert.True(t, strings.HasPrefix(reply, "-"), reply)
+	conn.Close()
+
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodPost, "/clusters/admin/nodes/weight?node="+backend.Addr().String()+"&weight=3", nil))
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clusters/admin/nodes", &nodes))
+	assert.Equal(t, 3, nodes[0].Weight)
+	assert.True(t, nodes[0].Ejected)
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodPost, "/clusters/admin/nodes/readd?node=node1", nil))
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clusters/admin/nodes", &nodes))
+	assert.False(t, nodes[0].Ejected)
+	conn, err = net.Dial("tcp", cc.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	br = bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("*2\r\n$4\r\nAUTH\r\n$3\r\npwd\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	reply, _ = br.ReadString('\n')
+	assert.Equal(t, "+OK\r\n", reply)
+	reply, err = br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\n", reply)
+	br.ReadString('\n')
+
+	var clients []*adminClient
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clients?cluster=admin", &clients))
+	var found *adminClient
+	for _, client := range clients {
+		if client.RemoteAddr == conn.LocalAddr().String() {
+			found = client
+		}
+	}
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "admin", found.Cluster)
+		assert.Equal(t, int64(2), found.Cmds)
+		assert.Equal(t, int64(0), found.Idle)
+	}
+	assert.Equal(t, http.StatusOK, _adminDo(t, mux, http.MethodGet, "/clients?cluster=none", &clients))
+	assert.Empty(t, clients)
+}
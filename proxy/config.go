@@ -3,12 +3,16 @@ package proxy
 import (
 	errs "errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"mycache/pkg/log"
+	libnet "mycache/pkg/net"
 	"mycache/pkg/types"
+	"mycache/proxy/proto/redis"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Pallinder/go-randomdata"
@@ -30,6 +34,7 @@ type Config struct {
 		WriteTimeout   int   `toml:"write_timeout"`   //0
 		MaxConnections int32 `toml:"max_connections"` //proxy负载的最大连接数：无限
 		UseMetrics     bool  `toml:"use_metrics"`     //true
+		DrainTimeout   int   `toml:"drain_timeout"`   //5000, 关闭时等待客户端连接处理完的毫秒数
 	}
 }
 
@@ -54,6 +59,58 @@ func (c *Config) LoadFromFile(path string) error {
 	return c.Validate()
 }
 
+// splitServer split the server like ip:port:weight or unix:/path:weight into address and weight.
+func splitServer(server string) (addr, weight string, ok bool) {
+	idx := strings.LastIndex(server, ":")
+	if idx == -1 {
+		return
+	}
+	addr, weight = server[:idx], server[idx+1:]
+	if strings.HasPrefix(addr, libnet.UnixPrefix) {
+		return addr, weight, len(addr) > len(libnet.UnixPrefix)
+	}
+	// NOTE: sentinel:<master name>:weight is resolved by sentinels
+	if strings.HasPrefix(addr, sentinelPrefix) {
+		return addr, weight, len(addr) > len(sentinelPrefix)
+	}
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	if port, e := strconv.Atoi(p); e != nil || port <= 0 {
+		return
+	}
+	return addr, weight, true
+}
+
+// validateListen check the listen proto and the mode of unix socket.
+func (cc *ClusterConfig) validateListen() error {
+	switch cc.ListenProto {
+	case "", "tcp":
+	case "unix":
+		if cc.ListenMode != "" {
+			if _, err := strconv.ParseUint(cc.ListenMode, 8, 32); err != nil {
+				return errors.Wrapf(ErrClusterConfInvalid, "listen_mode:%s", cc.ListenMode)
+			}
+		}
+	default:
+		return errors.Wrapf(ErrClusterConfInvalid, "listen_proto:%s", cc.ListenProto)
+	}
+	return nil
+}
+
+// listenKey returns the key to detect duplicate listeners: the port of tcp or the path of unix socket.
+func (cc *ClusterConfig) listenKey() (string, bool) {
+	if cc.ListenProto == "unix" {
+		return libnet.UnixPrefix + filepath.Clean(cc.ListenAddr), cc.ListenAddr != ""
+	}
+	ipPort := strings.Split(cc.ListenAddr, ":")
+	if len(ipPort) != 2 {
+		return "", false
+	}
+	return ipPort[1], true
+}
+
 // Validate validate config field value.
 func (c *Config) Validate() error {
 	// TODO(felix): complete validates
@@ -69,17 +126,55 @@ type ClusterConfig struct {
 	CacheType        types.CacheType `toml:"cache_type"`        //"redis"
 	ListenProto      string          `toml:"listen_proto"`      //"tcp"
 	ListenAddr       string          `toml:"listen_addr"`       //"0.0.0.0:26379"
+	ListenMode       string          `toml:"listen_mode"`       //"", unix socket的文件权限，如"0660"
+	ListenOwner      string          `toml:"listen_owner"`      //"", unix socket的属主，如"redis:redis"
 	RedisAuth        string          `toml:"redis_auth"`        //""
+	RedisUser        string          `toml:"redis_user"`        //"", 后端的ACL用户名，为空只用redis_auth认证
+	RedisDB          int             `toml:"redis_db"`          //0, 连接后端后SELECT的db，redis cluster只能是0
+	Databases        int             `toml:"databases"`         //16, 客户端可以SELECT的db数量，和后端的databases一致，0是默认的16
 	DialTimeout      int             `toml:"dial_timeout"`      //1000
 	ReadTimeout      int             `toml:"read_timeout"`      //1000
 	WriteTimeout     int             `toml:"write_timeout"`     //1000
 	NodeConnections  int32           `toml:"node_connections"`  //2
 	PingFailLimit    int             `toml:"ping_fail_limit"`   //3
 	PingAutoEject    bool            `toml:"ping_auto_eject"`   //false
-	// SlowlogSlowerThan int             `toml:"slowlog_slower_than"`
+	MGetPartialNil   bool            `toml:"mget_partial_nil"`  //false, MGET部分分片失败时失败的key回复nil
+	ScriptRouteKey   string          `toml:"script_route_key"`  //"", numkeys为0的EVAL/EVALSHA按这个key路由
+	EnableKeys       bool            `toml:"enable_keys"`       //false, 是否允许KEYS广播到所有node
+	KeysMaxReply     int             `toml:"keys_max_reply"`    //10000, KEYS最多回复的key数量
+	PubSubNode       string          `toml:"pubsub_node"`       //"", 所有pub/sub都走这个node(地址或别名)，为空按频道hash
+	BlockingIdle     int             `toml:"blocking_idle"`     //2, 每个node保留的阻塞命令空闲连接数
+	BlockingConns    int             `toml:"blocking_conns"`    //0, 每个node最多同时阻塞的命令数，0不限制
+
+	SlowlogSlowerThan int `toml:"slowlog_slower_than"` //0, 执行超过多少微秒记录慢日志，0不记录
+
+	Replicas       map[string][]string `toml:"replicas"`        //{"redis1" = ["127.0.0.1:6380"]}, node(地址或别名)的从库，READONLY的客户端读从库
+	ReplicaBalance string              `toml:"replica_balance"` //"round_robin", 读请求在健康的从库间的分配: round_robin | least_pending
 
-	Servers  []string `toml:"servers"`  //"127.0.0.1:6379:1 redis2","127.0.0.1:6378:1 redis1"
-	Password string   `toml:"password"` //""
+	Sentinels []string `toml:"sentinels"` //["127.0.0.1:26379"], servers里sentinel:<master name>:weight的node由sentinel解析和切换主库
+
+	TLSCert        string `toml:"tls_cert"`                //"", 监听端的证书，设置后客户端必须用TLS连接
+	TLSKey         string `toml:"tls_key"`                 //"", 监听端证书的私钥
+	TLSClientCA    string `toml:"tls_client_ca"`           //"", 校验客户端证书的CA，设置后开启mTLS
+	BackendTLS     bool   `toml:"backend_tls"`             //false, 用TLS连接后端redis
+	BackendTLSCA   string `toml:"backend_tls_ca"`          //"", 校验后端证书的CA，为空用系统CA
+	BackendTLSCert string `toml:"backend_tls_cert"`        //"", 发给后端的客户端证书
+	BackendTLSKey  string `toml:"backend_tls_key"`         //"", 客户端证书的私钥
+	BackendTLSName string `toml:"backend_tls_name"`        //"", 校验后端证书的名字(SNI)，为空用地址里的host
+	BackendTLSSkip bool   `toml:"backend_tls_skip_verify"` //false, 不校验后端证书，只用于测试
+
+	Servers  []string      `toml:"servers"`  //"127.0.0.1:6379:1 redis2","127.0.0.1:6378:1 redis1"
+	Password string        `toml:"password"` //""
+	Users    []*UserConfig `toml:"users"`    //ACL用户，AUTH username password认证
+}
+
+// UserConfig is the ACL user of redis proxy.
+type UserConfig struct {
+	Name     string   `toml:"name"`
+	Password string   `toml:"password"`
+	Commands []string `toml:"commands"`  //["read", "write"], 允许的命令类别，为空允许所有
+	Keys     []string `toml:"keys"`      //["app:*"], 允许访问的key的glob模式，为空不限制
+	ReadOnly bool     `toml:"read_only"` //false, 只读用户不能执行写命令
 }
 
 // ValidateStandalone validate redis/memcache address is valid or not
@@ -97,16 +192,12 @@ func ValidateStandalone(servers []string) (err error) {
 			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
 			return
 		}
-		ipPort := strings.Split(ipAlias[0], ":")
-		if len(ipPort) != 3 {
-			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
-			return
-		}
-		if port, e := strconv.Atoi(ipPort[1]); e != nil || port <= 0 {
+		_, w, ok := splitServer(ipAlias[0])
+		if !ok {
 			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
 			return
 		}
-		if weight, e := strconv.Atoi(ipPort[2]); e != nil || weight < 0 {
+		if weight, e := strconv.Atoi(w); e != nil || weight < 0 {
 			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
 			return
 		}
@@ -117,12 +208,169 @@ func ValidateStandalone(servers []string) (err error) {
 // Validate validate config field value.
 func (cc *ClusterConfig) Validate() error {
 	// TODO(felix): complete validates
+	if err := cc.validateUsers(); err != nil {
+		return err
+	}
+	if err := cc.validateTLS(); err != nil {
+		return err
+	}
+	if err := cc.validateListen(); err != nil {
+		return err
+	}
 	if cc.CacheType != types.CacheTypeRedisCluster {
-		return ValidateStandalone(cc.Servers)
+		if err := ValidateStandalone(cc.Servers); err != nil {
+			return err
+		}
+		if cc.CacheType == types.CacheTypeRedis && len(cc.Servers) > redis.ScanMaxNodes {
+			return errors.Wrapf(ErrClusterConfInvalid, "servers:%d more than %d which SCAN can iterate", len(cc.Servers), redis.ScanMaxNodes)
+		}
+		databases := cc.Databases
+		if databases == 0 {
+			databases = defaultDatabases // NOTE: 0 means the default, see SetDefault
+		}
+		if databases < 0 || cc.RedisDB < 0 || cc.RedisDB >= databases {
+			return errors.Wrapf(ErrClusterConfInvalid, "redis_db:%d out of databases:%d", cc.RedisDB, databases)
+		}
+		if err := cc.validateSentinels(); err != nil {
+			return err
+		}
+		if err := cc.validateReplicas(); err != nil {
+			return err
+		}
+		return cc.validatePubSubNode()
+	}
+	if len(cc.Sentinels) > 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "sentinels only for redis, cache_type:%s", cc.CacheType)
+	}
+	if len(cc.Replicas) > 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "replicas only for redis, cache_type:%s", cc.CacheType)
+	}
+	if cc.RedisDB != 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "redis_db:%d must be 0 for redis cluster", cc.RedisDB)
 	}
 	return nil
 }
 
+// validatePubSubNode check the pubsub node is one of the servers.
+func (cc *ClusterConfig) validatePubSubNode() error {
+	if cc.PubSubNode == "" || cc.hasNode(cc.PubSubNode) {
+		return nil
+	}
+	return errors.Wrapf(ErrClusterConfInvalid, "pubsub_node:%s not in servers", cc.PubSubNode)
+}
+
+// validateReplicas check the replicas belong to the servers of redis and the balance method.
+func (cc *ClusterConfig) validateReplicas() error {
+	if cc.ReplicaBalance != "" && cc.ReplicaBalance != replicaRoundRobin && cc.ReplicaBalance != replicaLeastPending {
+		return errors.Wrapf(ErrClusterConfInvalid, "replica_balance:%s must be %s or %s", cc.ReplicaBalance, replicaRoundRobin, replicaLeastPending)
+	}
+	if len(cc.Replicas) == 0 {
+		return nil
+	}
+	if cc.CacheType != types.CacheTypeRedis {
+		return errors.Wrapf(ErrClusterConfInvalid, "replicas only for redis, cache_type:%s", cc.CacheType)
+	}
+	for node, replicas := range cc.Replicas {
+		if !cc.hasNode(node) {
+			return errors.Wrapf(ErrClusterConfInvalid, "replicas of node:%s not in servers", node)
+		}
+		for _, replica := range replicas {
+			if _, _, ok := splitServer(replica + ":1"); !ok || strings.HasPrefix(replica, sentinelPrefix) {
+				return errors.Wrapf(ErrClusterConfInvalid, "replica:%s of node:%s", replica, node)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSentinels check the sentinels are set for the servers declared by sentinel master name.
+func (cc *ClusterConfig) validateSentinels() error {
+	var masters bool
+	for _, server := range cc.Servers {
+		masters = masters || strings.HasPrefix(server, sentinelPrefix)
+	}
+	if !masters && len(cc.Sentinels) == 0 {
+		return nil
+	}
+	if cc.CacheType != types.CacheTypeRedis {
+		return errors.Wrapf(ErrClusterConfInvalid, "sentinels only for redis, cache_type:%s", cc.CacheType)
+	}
+	if masters && len(cc.Sentinels) == 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "sentinels must be set for the servers of %s<master name>", sentinelPrefix)
+	}
+	for _, sentinel := range cc.Sentinels {
+		if _, _, ok := splitServer(sentinel + ":1"); !ok || strings.HasPrefix(sentinel, sentinelPrefix) {
+			return errors.Wrapf(ErrClusterConfInvalid, "sentinel:%s", sentinel)
+		}
+	}
+	return nil
+}
+
+// hasNode check the node is the address or alias of one of the servers.
+func (cc *ClusterConfig) hasNode(node string) bool {
+	for _, server := range cc.Servers {
+		ipAlias := strings.Split(server, " ")
+		addr := ipAlias[0][:strings.LastIndex(ipAlias[0], ":")]
+		if addr == node || (len(ipAlias) == 2 && ipAlias[1] == node) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateUsers check the ACL users, which are only for redis.
+func (cc *ClusterConfig) validateUsers() error {
+	if len(cc.Users) == 0 {
+		return nil
+	}
+	if cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "users only for redis, cache_type:%s", cc.CacheType)
+	}
+	names := map[string]struct{}{}
+	for _, u := range cc.Users {
+		if u.Name == "" || u.Name == "default" || u.Password == "" {
+			return errors.Wrapf(ErrClusterConfInvalid, "user:%s needs name and password, and default is reserved", u.Name)
+		}
+		if _, ok := names[u.Name]; ok {
+			return errors.Wrapf(ErrClusterConfDuplicate, "user:%s", u.Name)
+		}
+		names[u.Name] = struct{}{}
+		for _, cmd := range u.Commands {
+			if cmd != "read" && cmd != "write" {
+				return errors.Wrapf(ErrClusterConfInvalid, "user:%s commands:%s must be read or write", u.Name, cmd)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTLS load the certificate files to check them.
+func (cc *ClusterConfig) validateTLS() error {
+	if cc.TLSCert != "" || cc.TLSKey != "" || cc.TLSClientCA != "" {
+		if _, err := libnet.ServerTLS(cc.tlsOptions()); err != nil {
+			return errors.Wrapf(ErrClusterConfInvalid, "tls_cert:%s tls_key:%s tls_client_ca:%s error:%v", cc.TLSCert, cc.TLSKey, cc.TLSClientCA, err)
+		}
+	}
+	if !cc.BackendTLS {
+		return nil
+	}
+	if cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "backend_tls only for redis, cache_type:%s", cc.CacheType)
+	}
+	if _, err := libnet.ClientTLS(cc.backendTLSOptions()); err != nil {
+		return errors.Wrapf(ErrClusterConfInvalid, "backend_tls error:%v", err)
+	}
+	return nil
+}
+
+func (cc *ClusterConfig) tlsOptions() libnet.TLSOptions {
+	return libnet.TLSOptions{CertFile: cc.TLSCert, KeyFile: cc.TLSKey, CAFile: cc.TLSClientCA}
+}
+
+func (cc *ClusterConfig) backendTLSOptions() libnet.TLSOptions {
+	return libnet.TLSOptions{CertFile: cc.BackendTLSCert, KeyFile: cc.BackendTLSKey, CAFile: cc.BackendTLSCA, ServerName: cc.BackendTLSName, SkipVerify: cc.BackendTLSSkip}
+}
+
 // SetDefault config content with cluster config
 func (cc *ClusterConfig) SetDefault() {
 	if len(cc.Servers) == 0 {
@@ -152,9 +400,21 @@ func (cc *ClusterConfig) SetDefault() {
 		cc.NodeConnections = 2
 	}
 
+	if cc.KeysMaxReply == 0 {
+		cc.KeysMaxReply = 10000
+	}
+
+	if cc.BlockingIdle == 0 {
+		cc.BlockingIdle = 2
+	}
+
+	if cc.Databases == 0 {
+		cc.Databases = defaultDatabases
+	}
+
 	if len(cc.ListenAddr) == 0 {
 		fmt.Fprint(os.Stderr, "checking out ListenAddr may only using for [anzi] from\n")
-	} else if !strings.Contains(cc.ListenAddr, ":") {
+	} else if cc.ListenProto != "unix" && !strings.Contains(cc.ListenAddr, ":") {
 		addr := fmt.Sprintf("%s:%s", "0.0.0.0", cc.ListenAddr)
 		fmt.Fprintf(os.Stderr, "cluster(%s).cc.ListenAddr don't contains ':', using %s\n", cc.Name, addr)
 		cc.ListenAddr = addr
@@ -206,12 +466,11 @@ func LoadClusterConf(path string) (ccs []*ClusterConfig, err error) {
 			return
 		}
 		checks[cc.Name] = struct{}{}
-		ipPort := strings.Split(cc.ListenAddr, ":")
-		if len(ipPort) != 2 {
+		port, ok := cc.listenKey()
+		if !ok {
 			err = errors.Wrapf(ErrClusterConfInvalid, "addr:%s", cc.ListenAddr)
 			return
 		}
-		port := ipPort[1]
 		if _, ok := checks[port]; ok {
 			err = errors.Wrapf(ErrClusterConfDuplicate, "addr:%s", cc.ListenAddr)
 			return
@@ -223,6 +482,9 @@ func LoadClusterConf(path string) (ccs []*ClusterConfig, err error) {
 }
 
 //defaultConfig 伴生默认主程配置
+// defaultDatabases the number of databases of redis by default.
+const defaultDatabases = 16
+
 const defaultConfig = `
 ##################################################
 #                                                #
@@ -246,4 +508,6 @@ write_timeout = 0
 max_connections = 0
 # proxy support prometheus metrics, reuse the pprof port. By default, we use it.
 use_metrics = true
+# The time in msec that we wait for the client connections to finish the current requests on close. 0 means closing them at once.
+drain_timeout = 5000
 `
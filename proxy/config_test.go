@@ -1,9 +1,15 @@
 package proxy
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
+	"mycache/pkg/types"
+	"mycache/proxy/proto/redis"
+
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -131,3 +137,151 @@ func TestClusterConfigLoadFromFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, ccs.Clusters, 3)
 }
+
+func TestClusterConfigValidatePubSubNode(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1 abc", "127.0.0.1:7001:2 def"}}
+	for _, node := range []string{"", "127.0.0.1:7000", "def"} {
+		cc.PubSubNode = node
+		assert.NoError(t, cc.Validate())
+	}
+	cc.PubSubNode = "127.0.0.1:7002"
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigValidateRedisDB(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1"}, RedisDB: 2}
+	assert.NoError(t, cc.Validate())
+	// NOTE: 0 databases means the default 16
+	cc.RedisDB = 16
+	assert.Error(t, cc.Validate())
+	cc.Databases = 32
+	assert.NoError(t, cc.Validate())
+	cc.Databases = -1
+	assert.Error(t, cc.Validate())
+	cc = &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}, RedisDB: 2}
+	assert.Error(t, cc.Validate())
+	cc.RedisDB = 0
+	assert.NoError(t, cc.Validate())
+}
+
+func TestClusterConfigValidateScanNodes(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis}
+	for i := 0; i < redis.ScanMaxNodes; i++ {
+		cc.Servers = append(cc.Servers, fmt.Sprintf("127.0.0.1:%d:1", 7000+i))
+	}
+	assert.NoError(t, cc.Validate())
+	// NOTE: the node index of SCAN cursor is out of range
+	cc.Servers = append(cc.Servers, "127.0.0.1:9000:1")
+	assert.Error(t, cc.Validate())
+	cc.CacheType = types.CacheTypeMemcache
+	assert.NoError(t, cc.Validate())
+}
+
+func TestClusterConfigValidateUsers(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1"}}
+	cc.Users = []*UserConfig{{Name: "app", Password: "pw", Commands: []string{"read"}}}
+	assert.NoError(t, cc.Validate())
+	cc.Users = append(cc.Users, &UserConfig{Name: "app", Password: "pw"})
+	assert.Error(t, cc.Validate())
+	cc.Users = []*UserConfig{{Name: "default", Password: "pw"}}
+	assert.Error(t, cc.Validate())
+	cc.Users = []*UserConfig{{Name: "app", Password: "pw", Commands: []string{"admin"}}}
+	assert.Error(t, cc.Validate())
+	cc = &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:7000:1"}, Users: []*UserConfig{{Name: "app", Password: "pw"}}}
+	assert.Error(t, cc.Validate())
+}
+
+func TestRedisUsers(t *testing.T) {
+	users := redisUsers(&ClusterConfig{Users: []*UserConfig{
+		{Name: "all", Password: "pw"},
+		{Name: "ro", Password: "pw", ReadOnly: true},
+		{Name: "w", Password: "pw", Commands: []string{"write"}, Keys: []string{"w:*"}},
+	}})
+	assert.True(t, users["all"].Read && users["all"].Write)
+	assert.True(t, users["ro"].Read && !users["ro"].Write)
+	assert.True(t, !users["w"].Read && users["w"].Write)
+	assert.Equal(t, []string{"w:*"}, users["w"].Keys)
+}
+
+func TestClusterConfigValidateTLS(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1"}, TLSCert: "/not/exist.crt", TLSKey: "/not/exist.key"}
+	assert.Error(t, cc.Validate())
+	cc = &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:7000:1"}, BackendTLS: true}
+	assert.Error(t, cc.Validate())
+	cc.CacheType = types.CacheTypeRedis
+	assert.NoError(t, cc.Validate())
+	cc.BackendTLSCA = "/not/exist.crt"
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigValidateUnix(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, ListenProto: "unix", ListenAddr: "/tmp/proxy.sock", ListenMode: "0660",
+		Servers: []string{"unix:/var/run/redis.sock:1 abc", "127.0.0.1:7001:2 def"}}
+	assert.NoError(t, cc.Validate())
+	cc.ListenMode = "0999"
+	assert.Error(t, cc.Validate())
+	cc.ListenMode = ""
+	cc.Servers = []string{"unix::1"}
+	assert.Error(t, cc.Validate())
+	cc.Servers = []string{"127.0.0.1:1"}
+	assert.Error(t, cc.Validate())
+	cc.ListenProto = "udp"
+	cc.Servers = []string{"127.0.0.1:7001:1"}
+	assert.Error(t, cc.Validate())
+}
+
+func TestLoadClusterConfDuplicateUnix(t *testing.T) {
+	const unixCluster = `
+[[clusters]]
+name = "%s"
+cache_type = "redis"
+listen_proto = "unix"
+listen_addr = "%s"
+servers = ["unix:/var/run/redis.sock:1"]
+`
+	fd, err := ioutil.TempFile("", "proxy-backend-conf")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(fd.Name())
+	_, err = fmt.Fprintf(fd, unixCluster+unixCluster, "a", "/tmp/proxy.sock", "b", "/tmp/./proxy.sock")
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	_, err = LoadClusterConf(fd.Name())
+	assert.Equal(t, ErrClusterConfDuplicate, errors.Cause(err))
+}
+
+func TestClusterConfigValidateReplicas(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1 abc", "127.0.0.1:7001:1 def"}}
+	cc.Replicas = map[string][]string{"abc": {"127.0.0.1:8000"}, "127.0.0.1:7001": {"127.0.0.1:8001", "unix:/var/run/redis.sock"}}
+	assert.NoError(t, cc.Validate())
+	cc.ReplicaBalance = replicaLeastPending
+	assert.NoError(t, cc.Validate())
+	cc.ReplicaBalance = "random"
+	assert.Error(t, cc.Validate())
+	cc.ReplicaBalance = ""
+	cc.Replicas["127.0.0.1:7002"] = []string{"127.0.0.1:8002"}
+	assert.Error(t, cc.Validate())
+	cc.Replicas = map[string][]string{"abc": {"127.0.0.1"}}
+	assert.Error(t, cc.Validate())
+
+	cc = &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}}
+	cc.Replicas = map[string][]string{"127.0.0.1:7000": {"127.0.0.1:8000"}}
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigValidateSentinels(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"sentinel:mymaster:1", "127.0.0.1:7000:1"}}
+	assert.Error(t, cc.Validate())
+	cc.Sentinels = []string{"127.0.0.1:26379"}
+	assert.NoError(t, cc.Validate())
+	cc.PubSubNode = "sentinel:mymaster"
+	assert.NoError(t, cc.Validate())
+	cc.Sentinels = []string{"127.0.0.1"}
+	assert.Error(t, cc.Validate())
+
+	cc = &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"sentinel:mymaster:1"}, Sentinels: []string{"127.0.0.1:26379"}}
+	assert.Error(t, cc.Validate())
+	cc = &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}, Sentinels: []string{"127.0.0.1:26379"}}
+	assert.Error(t, cc.Validate())
+}
@@ -10,8 +10,8 @@ import (
 	"bytes"
 	"context"
 	errs "errors"
-	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,14 +20,14 @@ import (
 	"mycache/pkg/log"
 	libnet "mycache/pkg/net"
 
-	// "mycache/pkg/prom"
+	"mycache/pkg/prom"
 	"mycache/pkg/types"
 	"mycache/proxy/proto"
 
-	// "mycache/proxy/proto/memcache"
-	// mcbin "mycache/proxy/proto/memcache/binary"
+	"mycache/proxy/proto/memcache"
+	mcbin "mycache/proxy/proto/memcache/binary"
 	"mycache/proxy/proto/redis"
-	// rclstr "mycache/proxy/proto/redis/cluster"
+	rclstr "mycache/proxy/proto/redis/cluster"
 	"github.com/pkg/errors"
 )
 
@@ -44,6 +44,8 @@ var (
 	ErrForwarderHashNoNode = errs.New("forwarder hash no hit node")
 	ErrForwarderClosed     = errs.New("forwarder already closed")
 	ErrConnectionNotExist  = errs.New("connection of forwarder is not initialized")
+	ErrForwarderNoSuchNode = errs.New("forwarder has no such node")
+	ErrForwarderNodeWeight = errs.New("forwarder node weight must be positive")
 )
 
 var (
@@ -60,43 +62,105 @@ var (
 func NewForwarder(cc *ClusterConfig) proto.Forwarder {
 	//默认先构建单机协议转发器
 	if _, ok := defaultForwardCacheTypes[cc.CacheType]; ok {
-		return newDefaultForwarder(cc)
+		return withBlockingPool(cc, newDefaultForwarder(cc))
 	}
 	//redis cluster的协议转发器
-	// if cc.CacheType == types.CacheTypeRedisCluster {
-	// 	dto := time.Duration(cc.DialTimeout) * time.Millisecond
-	// 	rto := time.Duration(cc.ReadTimeout) * time.Millisecond
-	// 	wto := time.Duration(cc.WriteTimeout) * time.Millisecond
-	// 	return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, dto, rto, wto, []byte(cc.HashTag))
-	// }
+	if cc.CacheType == types.CacheTypeRedisCluster {
+		dto := time.Duration(cc.DialTimeout) * time.Millisecond
+		rto := time.Duration(cc.ReadTimeout) * time.Millisecond
+		wto := time.Duration(cc.WriteTimeout) * time.Millisecond
+		return withBlockingPool(cc, rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, dto, rto, wto, []byte(cc.HashTag), redisAuth(cc)))
+	}
 	panic("unsupported protocol")
 }
 
+// blockingForwarder 持有redis阻塞命令独占连接池的转发器
+type blockingForwarder interface {
+	BlockingPool() *redis.DedicatedPool
+}
+
+func withBlockingPool(cc *ClusterConfig, f proto.Forwarder) proto.Forwarder {
+	if bf, ok := f.(blockingForwarder); ok && bf.BlockingPool() != nil {
+		bf.BlockingPool().SetLimit(cc.BlockingIdle, cc.BlockingConns)
+		bf.BlockingPool().SetAuth(redisAuth(cc))
+	}
+	return f
+}
+
+// redisUsers returns the ACL users of redis proxy conn.
+func redisUsers(cc *ClusterConfig) map[string]*redis.User {
+	users := make(map[string]*redis.User, len(cc.Users))
+	for _, uc := range cc.Users {
+		u := &redis.User{Name: uc.Name, Password: uc.Password, Read: len(uc.Commands) == 0, Write: len(uc.Commands) == 0, Keys: uc.Keys}
+		for _, cmd := range uc.Commands {
+			u.Read = u.Read || cmd == "read"
+			u.Write = u.Write || cmd == "write"
+		}
+		u.Write = u.Write && !uc.ReadOnly
+		users[u.Name] = u
+	}
+	return users
+}
+
+// redisAuth returns the TLS and the AUTH and SELECT of redis backend, nil if nothing to do.
+func redisAuth(cc *ClusterConfig) *redis.Auth {
+	if cc.RedisAuth == "" && cc.RedisDB == 0 && !cc.BackendTLS {
+		return nil
+	}
+	auth := &redis.Auth{User: cc.RedisUser, Password: cc.RedisAuth, DB: cc.RedisDB}
+	if cc.BackendTLS {
+		var err error
+		// NOTE: the TLS which fails to load fails every dialing, never fall back to plaintext
+		if auth.TLS, err = libnet.ClientTLS(cc.backendTLSOptions()); err != nil {
+			log.Errorf("cluster:%s fail to load backend tls with error:%v", cc.Name, err)
+		}
+	}
+	return auth
+}
+
 // defaultForwarder implement the default hashring router and msgbatch.
 type defaultForwarder struct {
 	cc      *ClusterConfig
 	hashTag []byte       // 46->ascii：“.”
 	conns   atomic.Value //node连接,node元信息管理connections
 	state   int32        //0
+
+	blocking *redis.DedicatedPool //redis阻塞命令独占的后端连接池
+
+	lock     sync.Mutex       //串行Update、Close和sentinel切换主库
+	sentinel *sentinelWatcher //servers里有sentinel:<master name>时跟随主库切换
 }
 
 // newDefaultForwarder must combinf.
 func newDefaultForwarder(cc *ClusterConfig) proto.Forwarder {
 	f := &defaultForwarder{cc: cc}
 	f.hashTag = []byte(cc.HashTag) //hash tag定位后端机器（hash一致性）
+	if cc.CacheType == types.CacheTypeRedis {
+		f.blocking = redis.NewDedicatedPool(time.Duration(cc.DialTimeout)*time.Millisecond, time.Duration(cc.ReadTimeout)*time.Millisecond, time.Duration(cc.WriteTimeout)*time.Millisecond)
+	}
 	// parse servers config
 	addrs, ws, ans, alias, err := parseServers(cc.Servers)
 	if err != nil {
 		panic(err)
 	}
+	if cc.CacheType == types.CacheTypeRedis && len(cc.Sentinels) > 0 {
+		f.sentinel = newSentinelWatcher(cc)
+	}
+	addrs, ans, alias, names := f.resolveMasters(addrs, ans, alias)
 	//新建预置proxy.connections对象
 	conns := newConnections(cc)
+	conns.masterNames = names
 	//初始化集群backend node的元信息到该proxy.connections对象上
 	conns.init(addrs, ans, ws, alias, nil)
+	conns.initReplicas(nil)
 	//基于已有的元信息去检查下代理的bakcend node的健康状态
 	conns.startPinger() //转发器 事前去ping下这些backend node是否存活
+	conns.startReplicaPinger()
 	// 该proxy.connections对象一切就绪可用，绑到f.conns原子变量里
 	f.conns.Store(conns)
+	if f.sentinel != nil {
+		go f.watchSentinel()
+	}
 	return f //返回预热配置好的转发器出去 给Hander对象，handler方法里去使用
 }
 
@@ -112,31 +176,72 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 		return ErrConnectionNotExist
 	}
 	//迭代消息组
+	var err error
 	for _, m := range msgs {
 		if m.IsBatch() { //检测是否是批处理
 			for _, subm := range m.Batch() {
-				key := subm.Request().Key()                   //获取每个请求命令的数据key
-				ncp, ok := conns.getPipes(f.trimHashTag(key)) //该数据key路由到指定backend hash node上去处理（一致性hash）
+				key := subm.Request().Key()                         //获取每个请求命令的数据key
+				addr, ncp, ok := conns.getPipes(f.trimHashTag(key)) //该数据key路由到指定backend hash node上去处理（一致性hash）
 				if !ok {
-					m.WithError(ErrForwarderHashNoNode)
-					return errors.WithStack(ErrForwarderHashNoNode)
+					// NOTE: only fail the sub message, others are still sent for partial failure
+					err = errors.WithStack(ErrForwarderHashNoNode)
+					subm.WithError(err)
+					continue
 				}
+				addr, ncp = conns.replicaPipe(addr, ncp, subm.Request())
+				subm.MarkAddr(addr)
 				subm.MarkStartPipe()
 				ncp.Push(subm)
 			}
 		} else {
+			//广播消息
+			if proto.IsBroadcast(m) {
+				f.broadcast(conns, m)
+				continue
+			}
+			//游标消息，按游标里的node序号转发
+			if idx, ok, cerr := proto.CursorNode(m, len(conns.addrs)); ok {
+				if cerr != nil {
+					m.WithError(cerr)
+					continue
+				}
+				addr := conns.addrs[idx]
+				m.MarkAddr(addr)
+				m.MarkStartPipe()
+				conns.nodePipe[addr].Push(m)
+				continue
+			}
+			//发布消息，配置了pubsub node时都发到该node
+			if f.cc.PubSubNode != "" && proto.IsPublish(m) {
+				addr, ok := conns.pubsubAddr(f.cc.PubSubNode)
+				if !ok {
+					m.WithError(ErrForwarderHashNoNode)
+					return errors.WithStack(ErrForwarderHashNoNode)
+				}
+				m.MarkAddr(addr)
+				m.MarkStartPipe()
+				conns.nodePipe[addr].Push(m)
+				continue
+			}
 			//正常消息
-			key := m.Request().Key()
-			ncp, ok := conns.getPipes(f.trimHashTag(key))
+			if !f.sameNode(conns, m.Request()) {
+				m.WithError(proto.ErrCrossNode)
+				continue
+			}
+			key := f.routeKey(m.Request())
+			addr, ncp, ok := conns.getPipes(f.trimHashTag(key))
 			if !ok {
 				m.WithError(ErrForwarderHashNoNode)
 				return errors.WithStack(ErrForwarderHashNoNode)
 			}
+			//READONLY的客户端读从库
+			addr, ncp = conns.replicaPipe(addr, ncp, m.Request())
+			m.MarkAddr(addr)
 			m.MarkStartPipe()
 			ncp.Push(m) //把m处理的消息推到ncp连接pipne里
 		}
 	}
-	return nil
+	return err
 }
 
 //Update 更新backend的集群信息
@@ -145,16 +250,28 @@ func (f *defaultForwarder) Update(servers []string) error {
 	if err != nil {
 		return err
 	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	oldConns, ok := f.conns.Load().(*connections)
 	if !ok {
 		return errors.WithStack(ErrConnectionNotExist)
 	}
+	addrs, ans, alias, names := f.resolveMasters(addrs, ans, alias)
+	f.swapConns(oldConns, addrs, ans, ws, alias, names)
+	return nil
+}
 
+// swapConns replace the conns of forwarder, the pipes to the same addresses are reused and the others are closed.
+func (f *defaultForwarder) swapConns(oldConns *connections, addrs, ans []string, ws []int, alias bool, masterNames []string) {
 	newConns := newConnections(f.cc)
+	newConns.masterNames = masterNames
 	copyed := newConns.init(addrs, ans, ws, alias, oldConns.nodePipe)
+	rcopyed := newConns.initReplicas(oldConns.replicas)
+	newConns.keepEjected(oldConns)
 	f.conns.Store(newConns)
 	oldConns.cancel()
 	newConns.startPinger()
+	newConns.startReplicaPinger()
 	// close unused
 	for addr, conn := range oldConns.nodePipe {
 		if copyed[addr] {
@@ -163,13 +280,26 @@ func (f *defaultForwarder) Update(servers []string) error {
 		log.Infof("connection to node:%s is not used anymore, just close it", addr)
 		conn.Close()
 	}
-	return nil
+	for _, rs := range oldConns.replicas {
+		for _, r := range rs.replicas {
+			if !rcopyed[r.addr] {
+				log.Infof("connection to replica:%s is not used anymore, just close it", r.addr)
+				r.ncp.Close()
+			}
+		}
+	}
 }
 
 // Close close forwarder.
 func (f *defaultForwarder) Close() error {
 	if atomic.CompareAndSwapInt32(&f.state, forwarderStateOpening, forwarderStateClosed) {
 		// first closed
+		// NOTE: wait for the switch of master in progress, the conns are not swapped after closed
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.sentinel != nil {
+			f.sentinel.stop()
+		}
 		var curConns, ok = f.conns.Load().(*connections)
 		if !ok {
 			return errors.WithStack(ErrConnectionNotExist)
@@ -177,12 +307,173 @@ func (f *defaultForwarder) Close() error {
 		for _, np := range curConns.nodePipe {
 			go np.Close()
 		}
+		for _, rs := range curConns.replicas {
+			for _, r := range rs.replicas {
+				go r.ncp.Close()
+			}
+		}
 		curConns.cancel()
+		if f.blocking != nil {
+			_ = f.blocking.Close()
+		}
 		return nil
 	}
 	return nil
 }
 
+// PubSubAddr impl proto.PubSubForwarder, the channel is hashed like key when no pubsub node configured.
+func (f *defaultForwarder) PubSubAddr(channel []byte) (addr string, ok bool) {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return
+	}
+	if f.cc.PubSubNode != "" {
+		return conns.pubsubAddr(f.cc.PubSubNode)
+	}
+	return f.KeyAddr(channel)
+}
+
+// PubSubShared impl proto.PubSubForwarder, all channels are on the pubsub node if configured.
+func (f *defaultForwarder) PubSubShared() bool {
+	return f.cc.PubSubNode != ""
+}
+
+// KeyAddr impl proto.KeyLocator.
+func (f *defaultForwarder) KeyAddr(key []byte) (addr string, ok bool) {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return
+	}
+	addr, _, ok = conns.getPipes(f.trimHashTag(key))
+	return
+}
+
+// BlockingPool returns the dedicated conn pool for redis blocking commands, nil for others.
+func (f *defaultForwarder) BlockingPool() *redis.DedicatedPool {
+	return f.blocking
+}
+
+// NodeInfos impl proto.NodeInfoForwarder.
+func (f *defaultForwarder) NodeInfos() []*proto.NodeInfo {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return nil
+	}
+	infos := make([]*proto.NodeInfo, 0, len(conns.addrs))
+	for idx, addr := range conns.addrs {
+		info := &proto.NodeInfo{Addr: addr, Alias: addr, Weight: conns.weight(idx)}
+		if conns.alias {
+			info.Alias = conns.ans[idx]
+		}
+		_, info.Ejected = conns.ejected.Load(addr)
+		if ncp, ok := conns.nodePipe[addr]; ok {
+			info.Pending = ncp.Pending()
+		}
+		info.Replicas = conns.replicaInfos(addr)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// EjectNode impl proto.NodeAdminForwarder, the node is out of hash ring until it is readded or removed from the servers.
+func (f *defaultForwarder) EjectNode(node string) error {
+	conns, idx, err := f.adminNode(node)
+	if err != nil {
+		return err
+	}
+	conns.elock.Lock()
+	conns.adminEjected[conns.addrs[idx]] = struct{}{}
+	conns.ring.DelNode(conns.ringNode(idx))
+	conns.ejected.Store(conns.addrs[idx], struct{}{})
+	conns.elock.Unlock()
+	log.Infof("cluster:%s node:%s is ejected by admin", f.cc.Name, node)
+	return nil
+}
+
+// ReaddNode impl proto.NodeAdminForwarder.
+func (f *defaultForwarder) ReaddNode(node string) error {
+	conns, idx, err := f.adminNode(node)
+	if err != nil {
+		return err
+	}
+	conns.elock.Lock()
+	delete(conns.adminEjected, conns.addrs[idx])
+	conns.ring.AddNode(conns.ringNode(idx), conns.weight(idx))
+	conns.ejected.Delete(conns.addrs[idx])
+	conns.elock.Unlock()
+	log.Infof("cluster:%s node:%s is readded by admin", f.cc.Name, node)
+	return nil
+}
+
+// SetNodeWeight impl proto.NodeAdminForwarder, the ejected node takes the weight when readded.
+func (f *defaultForwarder) SetNodeWeight(node string, weight int) error {
+	if weight <= 0 {
+		return errors.WithStack(ErrForwarderNodeWeight)
+	}
+	conns, idx, err := f.adminNode(node)
+	if err != nil {
+		return err
+	}
+	conns.wlock.Lock()
+	conns.ws[idx] = weight
+	conns.wlock.Unlock()
+	if _, ejected := conns.ejected.Load(conns.addrs[idx]); !ejected {
+		conns.ring.AddNode(conns.ringNode(idx), weight)
+	}
+	log.Infof("cluster:%s node:%s weight is set to %d by admin", f.cc.Name, node, weight)
+	return nil
+}
+
+func (f *defaultForwarder) adminNode(node string) (conns *connections, idx int, err error) {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		err = errors.WithStack(ErrConnectionNotExist)
+		return
+	}
+	if idx, ok = conns.node(node); !ok {
+		err = errors.Wrapf(ErrForwarderNoSuchNode, "node:%s", node)
+	}
+	return
+}
+
+// broadcast send the message to every node.
+func (f *defaultForwarder) broadcast(conns *connections, m *proto.Message) {
+	for i, subm := range proto.Broadcast(m, len(conns.addrs)) {
+		addr := conns.addrs[i]
+		subm.MarkAddr(addr)
+		subm.MarkStartPipe()
+		conns.nodePipe[addr].Push(subm)
+	}
+}
+
+// routeKey returns the key for routing, the request without key like EVAL with numkeys 0 uses the script route key.
+func (f *defaultForwarder) routeKey(req proto.Request) []byte {
+	key := req.Key()
+	if len(key) == 0 && f.cc.ScriptRouteKey != "" {
+		return []byte(f.cc.ScriptRouteKey)
+	}
+	return key
+}
+
+// sameNode check if all keys of the multi-key request hash to the same node.
+func (f *defaultForwarder) sameNode(conns *connections, req proto.Request) bool {
+	mk, ok := req.(proto.MultiKeyRequest)
+	if !ok {
+		return true
+	}
+	keys := mk.Keys()
+	if len(keys) < 2 {
+		return true
+	}
+	first, _ := conns.ring.GetNode(f.trimHashTag(keys[0]))
+	for _, key := range keys[1:] {
+		if node, _ := conns.ring.GetNode(f.trimHashTag(key)); node != first {
+			return false
+		}
+	}
+	return true
+}
+
 func (f *defaultForwarder) trimHashTag(key []byte) []byte {
 	if len(f.hashTag) != 2 {
 		return key
@@ -206,10 +497,18 @@ type connections struct {
 	cc         *ClusterConfig
 	alias      bool     //true
 	addrs, ans []string //addrs:"127.0.0.1:6379","127.0.0.1:6378" | ans: "redis2","redis1"
-	ws         []int    // [1,1]
+	ws         []int    // [1,1], admin修改时由wlock保护
+	wlock      sync.Mutex
 	aliasMap   map[string]string
 	nodePipe   map[string]*proto.NodeConnPipe
-	ring       *hashkit.HashRing //hash槽-->节点的映射
+	replicas   map[string]*replicaSet //master addr -> 从库
+	// sentinel声明的server的master name，按序号，其他server为空
+	masterNames []string
+	ejected    sync.Map               //被pinger或admin剔除的node addr
+	// NOTE: admin剔除的node只由admin加回，elock串行admin和pinger的剔除和加回
+	elock        sync.Mutex
+	adminEjected map[string]struct{}
+	ring       *hashkit.HashRing      //hash槽-->节点的映射
 }
 
 func newConnections(cc *ClusterConfig) *connections {
@@ -217,6 +516,7 @@ func newConnections(cc *ClusterConfig) *connections {
 	c.cc = cc
 	c.aliasMap = make(map[string]string)
 	c.nodePipe = make(map[string]*proto.NodeConnPipe)
+	c.adminEjected = make(map[string]struct{})
 	//新建一个指定hash函数的散列环
 	c.ring = hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
 	c.ctx, c.cancel = context.WithCancel(context.Background())
@@ -255,8 +555,31 @@ func (c *connections) init(addrs, ans []string, ws []int, alias bool, oldNcps ma
 	return copyed
 }
 
-func (c *connections) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
-	var addr string
+// node returns the index of node by address or alias.
+func (c *connections) node(node string) (idx int, ok bool) {
+	for idx = range c.addrs {
+		if c.addrs[idx] == node || (c.alias && c.ans[idx] == node) {
+			return idx, true
+		}
+	}
+	return -1, false
+}
+
+// ringNode returns the name of node on hash ring, which is the alias if configured.
+func (c *connections) ringNode(idx int) string {
+	if c.alias {
+		return c.ans[idx]
+	}
+	return c.addrs[idx]
+}
+
+func (c *connections) weight(idx int) int {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	return c.ws[idx]
+}
+
+func (c *connections) getPipes(key []byte) (addr string, ncp *proto.NodeConnPipe, ok bool) {
 	if addr, ok = c.ring.GetNode(key); !ok {
 		return
 	}
@@ -269,16 +592,25 @@ func (c *connections) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
 	return
 }
 
+// pubsubAddr returns the address of the pubsub node, which can be an alias.
+func (c *connections) pubsubAddr(node string) (addr string, ok bool) {
+	addr = node
+	if c.alias {
+		if an, has := c.aliasMap[node]; has {
+			addr = an
+		}
+	}
+	_, ok = c.nodePipe[addr]
+	return
+}
+
 func (c *connections) startPinger() {
 	//自动剔除
 	if !c.cc.PingAutoEject {
 		return
 	}
 	for idx, addr := range c.addrs {
-		p := &pinger{cc: c.cc, addr: addr, alias: addr, weight: c.ws[idx]}
-		if c.alias {
-			p.alias = c.ans[idx]
-		}
+		p := &pinger{cc: c.cc, idx: idx, addr: addr, alias: c.ringNode(idx)}
 		go c.processPing(p)
 	}
 }
@@ -293,10 +625,7 @@ var pingSleepTime = func(t bool) time.Duration {
 
 // 处理ping
 func (c *connections) processPing(p *pinger) {
-	var (
-		err error
-		del bool
-	)
+	var err error
 	p.ping = newPingConn(p.cc, p.addr)
 	for {
 		select {
@@ -308,9 +637,7 @@ func (c *connections) processPing(p *pinger) {
 			err = p.ping.Ping()
 			if err == nil {
 				p.failure = 0
-				if del {
-					del = false
-					c.ring.AddNode(p.alias, p.weight)
+				if c.pingReadd(p) {
 					if log.V(4) {
 						log.Infof("node ping node:%s addr:%s success and readd", p.alias, p.addr)
 					}
@@ -319,9 +646,9 @@ func (c *connections) processPing(p *pinger) {
 				continue
 			} else {
 				_ = p.ping.Close()
-				// if prom.On {
-				// 	prom.ErrIncr(c.cc.Name, p.addr, "ping", "network err")
-				// }
+				if prom.On {
+					prom.PingEvent(c.cc.Name, p.addr, prom.PingFail)
+				}
 			}
 
 			p.failure++
@@ -333,12 +660,7 @@ func (c *connections) processPing(p *pinger) {
 				p.ping = newPingConn(p.cc, p.addr)
 				continue
 			}
-			if !del {
-				c.ring.DelNode(p.alias)
-				// if prom.On {
-				// 	prom.ErrIncr(c.cc.Name, p.addr, "ping", "del node")
-				// }
-				del = true
+			if c.pingEject(p) {
 				if log.V(2) {
 					log.Errorf("ping node:%s addr:%s fail times:%d ge to limit:%d then del", p.alias, p.addr, p.failure, c.cc.PingFailLimit)
 				}
@@ -351,12 +673,66 @@ func (c *connections) processPing(p *pinger) {
 	}
 }
 
+// pingReadd readd the node ejected by pinger, false if it is not ejected or ejected by admin.
+func (c *connections) pingReadd(p *pinger) bool {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	if _, ok := c.adminEjected[p.addr]; ok {
+		return false
+	}
+	if _, ok := c.ejected.Load(p.addr); !ok {
+		return false
+	}
+	// NOTE: the weight may be changed by admin
+	c.ring.AddNode(p.alias, c.weight(p.idx))
+	c.ejected.Delete(p.addr)
+	if prom.On {
+		prom.PingEvent(c.cc.Name, p.addr, prom.PingReadd)
+	}
+	return true
+}
+
+// pingEject eject the failed node, false if it is already ejected.
+// NOTE: the node readded by admin is ejected again if it's still failed.
+func (c *connections) pingEject(p *pinger) bool {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	if _, ok := c.adminEjected[p.addr]; ok {
+		return false
+	}
+	if _, ok := c.ejected.Load(p.addr); ok {
+		return false
+	}
+	c.ring.DelNode(p.alias)
+	c.ejected.Store(p.addr, struct{}{})
+	if prom.On {
+		prom.PingEvent(c.cc.Name, p.addr, prom.PingEject)
+	}
+	return true
+}
+
+// keepEjected keep the ejected nodes of old conns out of ring, which are readded by pinger or admin as before.
+func (c *connections) keepEjected(old *connections) {
+	old.elock.Lock()
+	defer old.elock.Unlock()
+	for idx, addr := range c.addrs {
+		if _, ok := old.ejected.Load(addr); !ok {
+			continue
+		}
+		if _, ok := old.adminEjected[addr]; ok {
+			c.adminEjected[addr] = struct{}{}
+		}
+		c.ring.DelNode(c.ringNode(idx))
+		c.ejected.Store(addr, struct{}{})
+	}
+}
+
 type pinger struct {
-	cc     *ClusterConfig
-	ping   proto.Pinger
-	addr   string
-	alias  string // NOTE: default is addr
-	weight int
+	cc    *ClusterConfig
+	ping  proto.Pinger
+	idx   int
+	addr  string
+	alias string // NOTE: default is addr
 
 	failure int
 }
@@ -367,12 +743,12 @@ func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
 	rto := time.Duration(cc.ReadTimeout) * time.Millisecond
 	wto := time.Duration(cc.WriteTimeout) * time.Millisecond
 	switch cc.CacheType {
-	// case types.CacheTypeMemcache:
-	// 	return memcache.NewNodeConn(cc.Name, addr, dto, rto, wto)
-	// case types.CacheTypeMemcacheBinary:
-	// 	return mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
+	case types.CacheTypeMemcache:
+		return memcache.NewNodeConn(cc.Name, addr, dto, rto, wto)
+	case types.CacheTypeMemcacheBinary:
+		return mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case types.CacheTypeRedis:
-		return redis.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		return redis.NewAuthNodeConn(cc.Name, addr, dto, rto, wto, redisAuth(cc))
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
@@ -381,14 +757,16 @@ func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
 //新建backend node健康检查的命令连接
 func newPingConn(cc *ClusterConfig, addr string) proto.Pinger {
 	const timeout = 100 * time.Millisecond
+	if cc.CacheType == types.CacheTypeRedis {
+		auth := redisAuth(cc)
+		return redis.NewAuthPinger(auth.Dial(addr, timeout, timeout, timeout), auth)
+	}
 	conn := libnet.DialWithTimeout(addr, timeout, timeout, timeout)
 	switch cc.CacheType {
-	// case types.CacheTypeMemcache:
-	// 	return memcache.NewPinger(conn)
-	// case types.CacheTypeMemcacheBinary:
-	// 	return mcbin.NewPinger(conn)
-	case types.CacheTypeRedis:
-		return redis.NewPinger(conn)
+	case types.CacheTypeMemcache:
+		return memcache.NewPinger(conn)
+	case types.CacheTypeMemcacheBinary:
+		return mcbin.NewPinger(conn)
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
@@ -418,13 +796,14 @@ func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias
 		} else {
 			addrW = svr
 		}
-		ss = strings.Split(addrW, ":")
-		if len(ss) != 3 {
+		// NOTE: unix:/path:weight dials the unix socket
+		addr, weight, ok := splitServer(addrW)
+		if !ok {
 			err = errors.Wrapf(ErrConfigServerFormat, "server:%s", svr)
 			return
 		}
-		addrs = append(addrs, net.JoinHostPort(ss[0], ss[1]))
-		w, we := conv.Btoi([]byte(ss[2]))
+		addrs = append(addrs, addr)
+		w, we := conv.Btoi([]byte(weight))
 		if we != nil || w <= 0 {
 			err = errors.Wrapf(ErrConfigServerFormat, "server:%s", svr)
 			return
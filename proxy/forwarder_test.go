@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _decodeRedisReq(t *testing.T, data string) proto.Request {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte(data), 1), time.Second, time.Second)
+	msgs, err := redis.NewProxyConn(conn, "").Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	return msgs[0].Request()
+}
+
+func TestForwarderSameNode(t *testing.T) {
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama", HashTag: "{}"}
+	f := &defaultForwarder{cc: cc, hashTag: []byte(cc.HashTag)}
+	conns := newConnections(cc)
+	conns.ring.Init([]string{"127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"}, []int{1, 1, 1})
+
+	assert.True(t, f.sameNode(conns, _decodeRedisReq(t, "GET a\r\n")))
+	assert.True(t, f.sameNode(conns, _decodeRedisReq(t, "MSETNX {u1}a 1 {u1}b 2\r\n")))
+	assert.True(t, f.sameNode(conns, _decodeRedisReq(t, "RENAME {u1}a {u1}b\r\n")))
+
+	first, _ := conns.ring.GetNode([]byte("a"))
+	var other string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if node, _ := conns.ring.GetNode([]byte(key)); node != first {
+			other = key
+			break
+		}
+	}
+	assert.NotEmpty(t, other)
+	assert.False(t, f.sameNode(conns, _decodeRedisReq(t, "SINTERSTORE a "+other+"\r\n")))
+	assert.False(t, f.sameNode(conns, _decodeRedisReq(t, "BITOP OR a a "+other+"\r\n")))
+}
+
+type recorder struct {
+	lock sync.Mutex
+	got  map[string]int
+}
+
+type recordNodeConn struct {
+	addr string
+	r    *recorder
+}
+
+func (n *recordNodeConn) Addr() string { return n.addr }
+func (n *recordNodeConn) Write(m *proto.Message) error {
+	n.r.lock.Lock()
+	n.r.got[n.addr+" "+m.Request().CmdString()]++
+	n.r.lock.Unlock()
+	return nil
+}
+func (n *recordNodeConn) Read(*proto.Message) error { return nil }
+func (n *recordNodeConn) Flush() error              { return nil }
+func (n *recordNodeConn) Close() error              { return nil }
+
+func TestForwarderBroadcastAndRouteKey(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"}
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama", HashTag: "{}", ScriptRouteKey: "route"}
+	f := &defaultForwarder{cc: cc, hashTag: []byte(cc.HashTag)}
+	conns := newConnections(cc)
+	conns.addrs = addrs
+	conns.ring.Init(addrs, []int{1, 1, 1})
+	r := &recorder{got: map[string]int{}}
+	for _, addr := range addrs {
+		toAddr := addr
+		conns.nodePipe[addr] = proto.NewNodeConnPipe(1, func() proto.NodeConn {
+			return &recordNodeConn{addr: toAddr, r: r}
+		})
+	}
+	f.conns.Store(conns)
+
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(2)
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("SCRIPT LOAD return\r\nEVAL return 0\r\n"), 1), time.Second, time.Second)
+	pc := redis.NewProxyConn(conn, "")
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	assert.Len(t, msgs[0].Requests(), 3)
+	for _, addr := range addrs {
+		assert.Equal(t, 1, r.got[addr+" SCRIPT"])
+	}
+	route, _ := conns.ring.GetNode([]byte("route"))
+	assert.Equal(t, route, msgs[1].Addr())
+	assert.Empty(t, msgs[1].Request().Key())
+}
+
+func TestForwarderScan(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379", "127.0.0.1:6380"}
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama"}
+	f := &defaultForwarder{cc: cc}
+	conns := newConnections(cc)
+	conns.addrs = addrs
+	conns.ring.Init(addrs, []int{1, 1})
+	r := &recorder{got: map[string]int{}}
+	for _, addr := range addrs {
+		toAddr := addr
+		conns.nodePipe[addr] = proto.NewNodeConnPipe(1, func() proto.NodeConn {
+			return &recordNodeConn{addr: toAddr, r: r}
+		})
+	}
+	f.conns.Store(conns)
+
+	wg := &sync.WaitGroup{}
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("SCAN 1025\r\nSCAN 2\r\n"), 1), time.Second, time.Second)
+	msgs, err := redis.NewProxyConn(conn, "").Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	assert.Equal(t, 1, r.got["127.0.0.1:6380 SCAN"])
+	assert.NoError(t, msgs[0].Err())
+	assert.Equal(t, proto.ErrBadCursor, msgs[1].Err())
+}
+
+func TestForwarderPubSub(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379", "127.0.0.1:6380"}
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama", PubSubNode: "127.0.0.1:6380"}
+	f := &defaultForwarder{cc: cc}
+	conns := newConnections(cc)
+	conns.addrs = addrs
+	conns.ring.Init(addrs, []int{1, 1})
+	r := &recorder{got: map[string]int{}}
+	for _, addr := range addrs {
+		toAddr := addr
+		conns.nodePipe[addr] = proto.NewNodeConnPipe(1, func() proto.NodeConn {
+			return &recordNodeConn{addr: toAddr, r: r}
+		})
+	}
+	f.conns.Store(conns)
+
+	addr, ok := f.PubSubAddr([]byte("ch"))
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:6380", addr)
+
+	wg := &sync.WaitGroup{}
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("PUBLISH a 1\r\nPUBLISH b 2\r\nPUBLISH c 3\r\n"), 1), time.Second, time.Second)
+	msgs, err := redis.NewProxyConn(conn, "").Decode(proto.GetMsgs(3))
+	assert.NoError(t, err)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	assert.Equal(t, 3, r.got["127.0.0.1:6380 PUBLISH"])
+
+	// hashed by channel without pubsub node
+	cc.PubSubNode = ""
+	addr, ok = f.PubSubAddr([]byte("ch"))
+	assert.True(t, ok)
+	expect, _ := conns.ring.GetNode([]byte("ch"))
+	assert.Equal(t, expect, addr)
+}
+
+func TestParseServersUnix(t *testing.T) {
+	addrs, ws, ans, alias, err := parseServers([]string{"unix:/var/run/redis.sock:2 local", "127.0.0.1:7000:1 remote"})
+	assert.NoError(t, err)
+	assert.True(t, alias)
+	assert.Equal(t, []string{"unix:/var/run/redis.sock", "127.0.0.1:7000"}, addrs)
+	assert.Equal(t, []int{2, 1}, ws)
+	assert.Equal(t, []string{"local", "remote"}, ans)
+
+	_, _, _, _, err = parseServers([]string{"unix:/var/run/redis.sock:0"})
+	assert.Error(t, err)
+}
+
+func TestForwarderReplica(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379"}
+	replicas := []string{"127.0.0.1:7379", "127.0.0.1:7380"}
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama"}
+	f := &defaultForwarder{cc: cc}
+	conns := newConnections(cc)
+	conns.addrs, conns.ws = addrs, []int{1}
+	conns.ring.Init(addrs, []int{1})
+	r := &recorder{got: map[string]int{}}
+	newPipe := func(addr string) *proto.NodeConnPipe {
+		return proto.NewNodeConnPipe(1, func() proto.NodeConn {
+			return &recordNodeConn{addr: addr, r: r}
+		})
+	}
+	conns.nodePipe[addrs[0]] = newPipe(addrs[0])
+	rs := &replicaSet{}
+	for _, addr := range replicas {
+		rs.replicas = append(rs.replicas, &replica{addr: addr, ncp: newPipe(addr)})
+	}
+	conns.replicas = map[string]*replicaSet{addrs[0]: rs}
+	f.conns.Store(conns)
+
+	data := "READONLY\r\nGET a\r\nGET b\r\nSET a 1\r\nSCAN 0\r\nMGET a b\r\nGET a\r\n"
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte(data), 1), time.Second, time.Second)
+	pc := redis.NewProxyConn(conn, "")
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	_, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	forward := func(n int) {
+		msgs, err := pc.Decode(proto.GetMsgs(n))
+		assert.NoError(t, err)
+		wg := &sync.WaitGroup{}
+		for _, m := range msgs {
+			m.WithWaitGroup(wg)
+		}
+		assert.NoError(t, f.Forward(msgs))
+		wg.Wait()
+	}
+
+	forward(4)
+	assert.Equal(t, 1, r.got["127.0.0.1:7379 GET"])
+	assert.Equal(t, 1, r.got["127.0.0.1:7380 GET"])
+	assert.Equal(t, 1, r.got["127.0.0.1:6379 SET"])
+	assert.Equal(t, 1, r.got["127.0.0.1:6379 SCAN"])
+
+	// the down replica is skipped, and the master serves the reads if all are down
+	rs.replicas[0].down = 1
+	forward(1)
+	// NOTE: MGET is split into GET of each key
+	assert.Equal(t, 3, r.got["127.0.0.1:7380 GET"])
+	rs.replicas[1].down = 1
+	forward(1)
+	assert.Equal(t, 1, r.got["127.0.0.1:6379 GET"])
+
+	infos := f.NodeInfos()
+	assert.Len(t, infos, 1)
+	assert.Len(t, infos[0].Replicas, 2)
+	assert.True(t, infos[0].Replicas[0].Ejected)
+}
+
+func TestReplicaSetLeastPending(t *testing.T) {
+	rs := &replicaSet{leastPending: true}
+	for _, addr := range []string{"127.0.0.1:7379", "127.0.0.1:7380"} {
+		rs.replicas = append(rs.replicas, &replica{addr: addr, ncp: proto.NewNodeConnPipe(1, func() proto.NodeConn {
+			return &recordNodeConn{addr: addr, r: &recorder{got: map[string]int{}}}
+		})})
+	}
+	r, ok := rs.pick()
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:7379", r.addr)
+	rs.replicas[0].down = 1
+	r, ok = rs.pick()
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:7380", r.addr)
+	rs.replicas[1].down = 1
+	_, ok = rs.pick()
+	assert.False(t, ok)
+}
+
+func TestForwarderAdminEjectAndPing(t *testing.T) {
+	addrs := []string{"127.0.0.1:6379", "127.0.0.1:6380"}
+	cc := &ClusterConfig{HashMethod: "fnv1a_64", HashDistribution: "ketama"}
+	f := &defaultForwarder{cc: cc}
+	conns := newConnections(cc)
+	conns.addrs, conns.ws = addrs, []int{1, 1}
+	conns.ring.Init(addrs, []int{1, 1})
+	f.conns.Store(conns)
+	ejected := func(addr string) bool {
+		_, ok := conns.ejected.Load(addr)
+		return ok
+	}
+	p := &pinger{cc: cc, idx: 0, addr: addrs[0], alias: addrs[0]}
+
+	// the node ejected by admin is not readded by pinger
+	assert.NoError(t, f.EjectNode(addrs[0]))
+	assert.False(t, conns.pingEject(p))
+	assert.False(t, conns.pingReadd(p))
+	assert.True(t, ejected(addrs[0]))
+	for i := 0; i < 10; i++ {
+		node, ok := conns.ring.GetNode([]byte(fmt.Sprint(i)))
+		assert.True(t, ok)
+		assert.Equal(t, addrs[1], node)
+	}
+
+	// the failed node readded by admin is ejected again by pinger
+	assert.NoError(t, f.ReaddNode(addrs[0]))
+	assert.False(t, ejected(addrs[0]))
+	assert.True(t, conns.pingEject(p))
+	assert.True(t, ejected(addrs[0]))
+	assert.False(t, conns.pingEject(p))
+	assert.True(t, conns.pingReadd(p))
+	assert.False(t, ejected(addrs[0]))
+}
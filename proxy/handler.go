@@ -10,15 +10,15 @@ import (
 	"mycache/pkg/log"
 	libnet "mycache/pkg/net"
 
-	// "overlord/pkg/prom"
+	"mycache/pkg/prom"
 	"mycache/pkg/types"
 	"mycache/proxy/proto"
 
-	// "overlord/proxy/proto/memcache"
-	// mcbin "overlord/proxy/proto/memcache/binary"
+	"mycache/proxy/proto/memcache"
+	mcbin "mycache/proxy/proto/memcache/binary"
 	"mycache/proxy/proto/redis"
-	// rclstr "overlord/proxy/proto/redis/cluster"
-	// "overlord/proxy/slowlog"
+	rclstr "mycache/proxy/proto/redis/cluster"
+	"mycache/proxy/slowlog"
 
 	"github.com/pkg/errors"
 )
@@ -41,16 +41,22 @@ type Handler struct {
 	p  *Proxy
 	cc *ClusterConfig
 
-	// slog       slowlog.Handler
-	// slowerThan time.Duration
+	slog       slowlog.Handler
+	slowerThan time.Duration
 
 	forwarder proto.Forwarder //
+	b         *backend        //跟踪时的backend，reload原地替换后在下个请求前换成新的，由Proxy.hlock保护
 
 	conn *libnet.Conn    //超时控制终端连接 tcp层
 	pc   proto.ProxyConn //封装编解码功能的 超时控制终端连接 app层
 
 	closed int32
+	done   chan struct{} //关闭后close，drain时等待
 	err    error
+
+	created time.Time //建立连接的时间
+	active  int64     //最后读到请求的时间，UnixNano
+	cmds    int64     //处理的命令数
 }
 
 // NewHandler new a conn handler.
@@ -60,35 +66,98 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 		p:         p,         //当前代理服务实例
 		cc:        cc,        //代理的node配置
 		forwarder: forwarder, //该类型协议的转发器
+		done:      make(chan struct{}),
+		created:   time.Now(),
 	}
+	h.active = h.created.UnixNano()
 
-	// if cc.SlowlogSlowerThan != 0 {
-	// 	h.slowerThan = time.Duration(cc.SlowlogSlowerThan) * time.Microsecond
-	// 	h.slog = slowlog.Get(cc.Name)
-	// }
-
+	pconf := p.conf().Proxy
 	//h.conn 为客户端的连接conn加上rw超时参数（成员实现方法继承）
-	h.conn = libnet.NewConn(conn, time.Second*time.Duration(h.p.c.Proxy.ReadTimeout), time.Second*time.Duration(h.p.c.Proxy.WriteTimeout))
+	h.conn = libnet.NewConn(conn, time.Second*time.Duration(pconf.ReadTimeout), time.Second*time.Duration(pconf.WriteTimeout))
 	// cache type
 	//B case: 进来连接的正常处理调用，
 	//根据连接的具体类型来处理
 	switch cc.CacheType {
-	// case types.CacheTypeMemcache:
-	// 	h.pc = memcache.NewProxyConn(h.conn) //该具有超时控制的新conn业务连接的代理 (Memcache编码协议的代理)
-	// case types.CacheTypeMemcacheBinary:
-	// 	h.pc = mcbin.NewProxyConn(h.conn)
+	case types.CacheTypeMemcache:
+		h.pc = memcache.NewProxyConn(h.conn) //该具有超时控制的新conn业务连接的代理 (Memcache编码协议的代理)
+	case types.CacheTypeMemcacheBinary:
+		h.pc = mcbin.NewProxyConn(h.conn)
 	case types.CacheTypeRedis:
 		// 复制proxy代理具体协议的conn
 		h.pc = redis.NewProxyConn(h.conn, h.cc.Password) //redis编码协议的代理，并对该连接认证
-	// case types.CacheTypeRedisCluster:
-	// 	h.pc = rclstr.NewProxyConn(h.conn, forwarder, h.cc.Password) //rediscluster编码协议的代理;redis单实例和redis cluster的编解码协议略有增减
+	case types.CacheTypeRedisCluster:
+		h.pc = rclstr.NewProxyConn(h.conn, forwarder, h.cc.Password) //rediscluster编码协议的代理;redis单实例和redis cluster的编解码协议略有增减
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
-	// prom.ConnIncr(cc.Name)
+	h.bind(cc, forwarder)
+	//独占的后端连接也要AUTH和SELECT
+	if apc, ok := h.pc.(interface{ SetNodeAuth(*redis.Auth) }); ok {
+		apc.SetNodeAuth(redisAuth(cc))
+	}
+	//ACL用户
+	if upc, ok := h.pc.(interface{ SetUsers(map[string]*redis.User) }); ok && len(cc.Users) > 0 {
+		upc.SetUsers(redisUsers(cc))
+	}
+	//客户端SELECT的db保存在连接上，转发时切换后端的db
+	if spc, ok := h.pc.(interface{ SetDatabases(int) }); ok {
+		spc.SetDatabases(cc.Databases)
+	}
+	if prom.On {
+		prom.ConnIncr(cc.Name)
+	}
 	return
 }
 
+// bind handle the conn with the config and forwarder, it's called again when the config is reloaded in place.
+// NOTE: the auth, the users and the db of conn are set only when it's accepted.
+func (h *Handler) bind(cc *ClusterConfig, forwarder proto.Forwarder) {
+	h.cc, h.forwarder = cc, forwarder
+	h.slowerThan, h.slog = 0, nil
+	if cc.SlowlogSlowerThan > 0 {
+		h.slowerThan = time.Duration(cc.SlowlogSlowerThan) * time.Microsecond
+		h.slog = slowlog.Get(cc.Name)
+	}
+	if pnc, ok := h.pc.(interface{ SetMGetPartialNil(bool) }); ok {
+		pnc.SetMGetPartialNil(cc.MGetPartialNil)
+	}
+	if kpc, ok := h.pc.(interface{ SetKeys(bool, int) }); ok {
+		kpc.SetKeys(cc.EnableKeys, cc.KeysMaxReply)
+	}
+	//订阅模式下独占一个后端连接
+	if psf, ok := forwarder.(proto.PubSubForwarder); ok {
+		if ppc, ok := h.pc.(interface {
+			SetPubSub(redis.PubSubLocator, bool, time.Duration, time.Duration)
+		}); ok {
+			ppc.SetPubSub(psf.PubSubAddr, psf.PubSubShared(), time.Duration(cc.DialTimeout)*time.Millisecond, time.Duration(cc.WriteTimeout)*time.Millisecond)
+		}
+	}
+	//WATCH独占一个后端连接，直到EXEC
+	if kl, ok := forwarder.(proto.KeyLocator); ok {
+		if tpc, ok := h.pc.(interface {
+			SetTx(redis.NodeLocator, time.Duration, time.Duration, time.Duration)
+		}); ok {
+			tpc.SetTx(kl.KeyAddr, time.Duration(cc.DialTimeout)*time.Millisecond, time.Duration(cc.ReadTimeout)*time.Millisecond, time.Duration(cc.WriteTimeout)*time.Millisecond)
+		}
+	}
+	//阻塞命令从独占连接池借用后端连接，不阻塞msgPipe
+	if bf, ok := forwarder.(blockingForwarder); ok && bf.BlockingPool() != nil {
+		if bpc, ok := h.pc.(interface{ SetBlocking(*redis.DedicatedPool) }); ok {
+			bpc.SetBlocking(bf.BlockingPool())
+		}
+	}
+	//SLOWLOG由proxy回复，没开启慢日志时为空
+	if spc, ok := h.pc.(interface{ SetSlowlog(redis.Slowlogs) }); ok {
+		spc.SetSlowlog(slowlog.Get(cc.Name))
+	}
+	//INFO由proxy回复
+	if ipc, ok := h.pc.(interface{ SetInfo(redis.InfoFunc) }); ok {
+		ipc.SetInfo(func(sections []string) string {
+			return h.p.info(cc, forwarder, sections)
+		})
+	}
+}
+
 // Handle reads Msg from client connection and dispatchs Msg back to cache servers,
 // then reads response from cache server and writes response into client connection.
 func (h *Handler) Handle() {
@@ -120,8 +189,16 @@ func (h *Handler) handle() {
 			return
 		}
 
+		// reload原地替换了配置，处理请求前换到新的转发器
+		if h.b != nil && h.b.next.Load() != nil {
+			h.p.rebind(h)
+		}
+
 		// 2. handle special command: AUTH,PING,QUIT,COMMAND
 		isSpecialCmd := false
+		atomic.AddInt64(&h.p.stats.ops, int64(len(msgs)))
+		atomic.AddInt64(&h.cmds, int64(len(msgs)))
+		atomic.StoreInt64(&h.active, time.Now().UnixNano())
 		//成功解码到message数据
 		if len(msgs) > 0 {
 			//检查msgs里是否有特殊cmd-PING
@@ -149,15 +226,22 @@ func (h *Handler) handle() {
 			for _, msg := range msgs {
 				//msg发送结束标记
 				msg.MarkEndPipe()
+				if merr := msg.Err(); merr != nil {
+					h.p.stats.incrErr(merr)
+				}
 				if err = h.pc.Encode(msg); err != nil {
 					h.pc.Flush() //清空写缓冲(发送出去)
 					h.deferHandle(messages, err)
 					return
 				}
 				msg.MarkEnd()
-				// if prom.On {
-				// 	prom.ProxyTime(h.cc.Name, msg.Request().CmdString(), int64(msg.TotalDur()/time.Microsecond))
-				// }
+				if prom.On {
+					h.promMsg(msg)
+				}
+				// NOTE: check slowlog before release resource
+				if h.slowerThan != 0 && msg.TotalDur() > h.slowerThan {
+					h.recordSlowlog(msg)
+				}
 			}
 		}
 
@@ -167,14 +251,6 @@ func (h *Handler) handle() {
 			return
 		}
 
-		// 5. check slowlog before release resource
-		// if h.slowerThan != 0 {
-		// 	for _, msg := range msgs {
-		// 		if msg.TotalDur() > h.slowerThan {
-		// 			h.slog.Record(msg.Slowlog())
-		// 		}
-		// 	}
-		// }
 		//发送完 就重置
 		for _, msg := range msgs {
 			msg.ResetSubs() //先重置该消息的所有子消息
@@ -186,6 +262,49 @@ func (h *Handler) handle() {
 	}
 }
 
+// recordSlowlog record the slow message with the client address.
+func (h *Handler) recordSlowlog(msg *proto.Message) {
+	e := msg.Slowlog()
+	if e == nil {
+		return
+	}
+	e.Client = h.conn.RemoteAddr().String()
+	h.slog.Record(e)
+}
+
+// promMsg observe the latency of every stage and the errors of nodes.
+// NOTE: the remote and input stages are marked on sub messages of batch.
+func (h *Handler) promMsg(msg *proto.Message) {
+	cmd := promCmd(msg.Request())
+	prom.ProxyTime(h.cc.Name, cmd, prom.StageTotal, msg.TotalDur())
+	prom.ProxyTime(h.cc.Name, cmd, prom.StagePipe, msg.PipeDur())
+	subs := []*proto.Message{msg}
+	if msg.IsBatch() {
+		subs = msg.Subs()
+	}
+	for _, sub := range subs {
+		if err := sub.Err(); err != nil {
+			prom.ErrIncr(h.cc.Name, sub.Addr(), cmd, errType(err))
+			continue
+		}
+		prom.ProxyTime(h.cc.Name, cmd, prom.StageRemote, sub.RemoteDur())
+		prom.ProxyTime(h.cc.Name, cmd, prom.StageInput, sub.InputDur())
+	}
+}
+
+// drain stop reading new requests, the handler is closed after the buffered requests are replied.
+func (h *Handler) drain() {
+	if err := h.conn.CloseRead(); err != nil {
+		h.forceClose()
+	}
+}
+
+// forceClose close the client socket, the handler fails on the next read or write and closes itself.
+// NOTE: the conn is closed by the goroutine of handler, see closeWithError.
+func (h *Handler) forceClose() {
+	_ = h.conn.Conn.Close()
+}
+
 // 分配并发要求的内存空间，并给每个msg加个共享的并发阻塞器wg
 func (h *Handler) allocMaxConcurrent(wg *sync.WaitGroup, msgs []*proto.Message, lastCount int) []*proto.Message {
 	var alloc int
@@ -221,13 +340,18 @@ func (h *Handler) closeWithError(err error) {
 	if atomic.CompareAndSwapInt32(&h.closed, handlerOpening, handlerClosed) {
 		h.err = err
 		_ = h.conn.Close()
+		if cpc, ok := h.pc.(io.Closer); ok {
+			_ = cpc.Close() //关闭订阅模式下的后端连接
+		}
 		atomic.AddInt32(&h.p.conns, -1) // NOTE: decr!!!
+		h.p.untrack(h)
+		close(h.done)
+		if prom.On {
+			prom.ConnDecr(h.cc.Name)
+		}
 		if err == proto.ErrQuit {
 			return
 		}
-		// if prom.On {
-		// 	prom.ConnDecr(h.cc.Name)
-		// }
 		if log.V(2) && errors.Cause(err) != io.EOF {
 			log.Warnf("cluster(%s) addr(%s) remoteAddr(%s) handler close error:%+v", h.cc.Name, h.cc.ListenAddr, h.conn.RemoteAddr(), err)
 		}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+	"mycache/version"
+
+	"github.com/pkg/errors"
+)
+
+// info sections in the order of INFO reply.
+var infoSections = []string{"server", "clients", "stats", "nodes"}
+
+// stats the counters of proxy shown by INFO.
+type stats struct {
+	ops      int64    //处理的命令数
+	rejected int64    //超过max_connections被拒绝的连接数
+	errs     sync.Map //错误类型 -> *int64
+}
+
+// incrErr count the error of message by type.
+func (s *stats) incrErr(err error) {
+	typ := errType(err)
+	cnt, ok := s.errs.Load(typ)
+	if !ok {
+		cnt, _ = s.errs.LoadOrStore(typ, new(int64))
+	}
+	atomic.AddInt64(cnt.(*int64), 1)
+}
+
+// errType returns the type of error: timeout, network, eof, nonode, closed or other.
+func errType(err error) string {
+	cause := errors.Cause(err)
+	switch cause {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return "eof"
+	case ErrForwarderHashNoNode:
+		return "nonode"
+	case ErrForwarderClosed:
+		return "closed"
+	}
+	if ne, ok := cause.(net.Error); ok {
+		if ne.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
+
+// promCmd returns the cmd label of metrics, the commands unknown to proxy share the label other to bound the series.
+func promCmd(req proto.Request) string {
+	if sr, ok := req.(interface{ IsSupport() bool }); ok && !sr.IsSupport() {
+		return "other"
+	}
+	return req.CmdString()
+}
+
+// info render INFO of the cluster like redis, all sections are returned if sections is empty or has all, default or everything.
+func (p *Proxy) info(cc *ClusterConfig, forwarder proto.Forwarder, sections []string) string {
+	want := map[string]bool{}
+	for _, section := range sections {
+		switch section {
+		case "all", "default", "everything":
+			for _, s := range infoSections {
+				want[s] = true
+			}
+		default:
+			want[section] = true
+		}
+	}
+	var buf bytes.Buffer
+	for _, section := range infoSections {
+		if len(sections) > 0 && !want[section] {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		switch section {
+		case "server":
+			uptime := int64(time.Since(p.started) / time.Second)
+			fmt.Fprintf(&buf, "# Server\r\nversion:%s\r\ncluster:%s\r\ncache_type:%s\r\nuptime_in_seconds:%d\r\nuptime_in_days:%d\r\n",
+				version.Str(), cc.Name, cc.CacheType, uptime, uptime/86400)
+		case "clients":
+			fmt.Fprintf(&buf, "# Clients\r\nconnected_clients:%d\r\nrejected_connections:%d\r\n",
+				atomic.LoadInt32(&p.conns), atomic.LoadInt64(&p.stats.rejected))
+		case "stats":
+			p.infoStats(&buf)
+		case "nodes":
+			buf.WriteString("# Nodes\r\n")
+			nf, ok := forwarder.(proto.NodeInfoForwarder)
+			if !ok {
+				continue
+			}
+			for i, node := range nf.NodeInfos() {
+				ejected := 0
+				if node.Ejected {
+					ejected = 1
+				}
+				fmt.Fprintf(&buf, "node%d:addr=%s,alias=%s,weight=%d,ejected=%d,pending=%d\r\n", i, node.Addr, node.Alias, node.Weight, ejected, node.Pending)
+			}
+		}
+	}
+	return buf.String()
+}
+
+func (p *Proxy) infoStats(buf *bytes.Buffer) {
+	var (
+		total int64
+		typs  []string
+		cnts  = map[string]int64{}
+	)
+	p.stats.errs.Range(func(k, v interface{}) bool {
+		cnt := atomic.LoadInt64(v.(*int64))
+		typs = append(typs, k.(string))
+		cnts[k.(string)] = cnt
+		total += cnt
+		return true
+	})
+	sort.Strings(typs)
+	fmt.Fprintf(buf, "# Stats\r\ntotal_commands_processed:%d\r\ntotal_error_replies:%d\r\nfailed_shards:%d\r\n",
+		atomic.LoadInt64(&p.stats.ops), total, redis.FailedShards())
+	for _, typ := range typs {
+		fmt.Fprintf(buf, "errorstat_%s:count=%d\r\n", typ, cnts[typ])
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type _infoForwarder struct {
+	proto.Forwarder
+}
+
+func (f *_infoForwarder) NodeInfos() []*proto.NodeInfo {
+	return []*proto.NodeInfo{{Addr: "127.0.0.1:7000", Alias: "a", Weight: 1, Ejected: true, Pending: 3}}
+}
+
+func TestErrType(t *testing.T) {
+	assert.Equal(t, "eof", errType(errors.WithStack(io.EOF)))
+	assert.Equal(t, "nonode", errType(errors.WithStack(ErrForwarderHashNoNode)))
+	assert.Equal(t, "timeout", errType(&net.OpError{Op: "read", Err: _timeoutErr{}}))
+	assert.Equal(t, "other", errType(errors.New("boom")))
+}
+
+type _promReq struct {
+	proto.Request
+	cmd     string
+	support bool
+}
+
+func (r *_promReq) CmdString() string { return r.cmd }
+func (r *_promReq) IsSupport() bool   { return r.support }
+
+func TestPromCmd(t *testing.T) {
+	assert.Equal(t, "GET", promCmd(&_promReq{cmd: "GET", support: true}))
+	assert.Equal(t, "other", promCmd(&_promReq{cmd: "RANDOM-CMD-1", support: false}))
+}
+
+type _timeoutErr struct{}
+
+func (_timeoutErr) Error() string   { return "i/o timeout" }
+func (_timeoutErr) Timeout() bool   { return true }
+func (_timeoutErr) Temporary() bool { return true }
+
+func TestProxyInfo(t *testing.T) {
+	p := &Proxy{conns: 2}
+	p.stats.ops = 10
+	p.stats.rejected = 1
+	p.stats.incrErr(io.EOF)
+	p.stats.incrErr(io.EOF)
+	cc := &ClusterConfig{Name: "test", CacheType: "redis"}
+
+	text := p.info(cc, &_infoForwarder{}, nil)
+	for _, s := range []string{"# Server", "cluster:test", "connected_clients:2", "rejected_connections:1",
+		"total_commands_processed:10", "total_error_replies:2", "failed_shards:", "errorstat_eof:count=2",
+		"node0:addr=127.0.0.1:7000,alias=a,weight=1,ejected=1,pending=3"} {
+		assert.Contains(t, text, s)
+	}
+
+	text = p.info(cc, &_infoForwarder{}, []string{"clients"})
+	assert.True(t, strings.HasPrefix(text, "# Clients\r\n"))
+	assert.NotContains(t, text, "# Server")
+	assert.Equal(t, "", p.info(cc, &_infoForwarder{}, []string{"unknown"}))
+}
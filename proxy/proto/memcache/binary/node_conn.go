@@ -0,0 +1,147 @@
+package binary
+
+import (
+	errs "errors"
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opened = int32(0)
+	closed = int32(1)
+
+	nodeReadBufSize = 512 * 1024 // NOTE: 512k
+)
+
+var (
+	// ErrNodeConnClosed err node conn closed.
+	ErrNodeConnClosed = errs.New("memcache binary node conn closed")
+)
+
+type nodeConn struct {
+	cluster string
+	addr    string
+	conn    *libnet.Conn
+	bw      *bufio.Writer
+	br      *bufio.Reader
+
+	state int32
+}
+
+// NewNodeConn returns node conn.
+func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	return newNodeConn(cluster, addr, conn)
+}
+
+func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
+	return &nodeConn{
+		cluster: cluster,
+		addr:    addr,
+		conn:    conn,
+		bw:      bufio.NewWriter(conn),
+		br:      bufio.NewReader(conn, bufio.Get(nodeReadBufSize)),
+	}
+}
+
+func (n *nodeConn) Addr() string {
+	return n.addr
+}
+
+func (n *nodeConn) Cluster() string {
+	return n.cluster
+}
+
+// Write write request data into server node.
+func (n *nodeConn) Write(m *proto.Message) (err error) {
+	if n.Closed() {
+		err = errors.WithStack(ErrNodeConnClosed)
+		return
+	}
+	mcr, ok := m.Request().(*MCRequest)
+	if !ok {
+		err = errors.WithStack(ErrBadAssert)
+		return
+	}
+	// quiet命令换成非quiet命令发送，保证backend对每个请求都有回复
+	_ = n.bw.Write(mcr.header[:1])
+	_ = n.bw.Write([]byte{byte(mcr.rTp.loud())})
+	_ = n.bw.Write(mcr.header[2:])
+	if err = n.bw.Write(mcr.data); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// Flush flush writer buffer.
+func (n *nodeConn) Flush() error {
+	if n.Closed() {
+		return errors.WithStack(ErrNodeConnClosed)
+	}
+	return n.bw.Flush()
+}
+
+// Read read response from server node into request.
+func (n *nodeConn) Read(m *proto.Message) (err error) {
+	if n.Closed() {
+		err = errors.WithStack(ErrNodeConnClosed)
+		return
+	}
+	mcr, ok := m.Request().(*MCRequest)
+	if !ok {
+		err = errors.WithStack(ErrBadAssert)
+		return
+	}
+	for {
+		if err = n.fillResp(mcr); err == bufio.ErrBufferFull {
+			if err = n.br.Read(); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			continue
+		} else if err != nil {
+			err = errors.WithStack(err)
+		}
+		return
+	}
+}
+
+// fillResp read one complete response, returns ErrBufferFull and rollback when data is not enough.
+func (n *nodeConn) fillResp(mcr *MCRequest) (err error) {
+	mark := n.br.Mark()
+	header, err := n.br.ReadExact(headerLen)
+	if err != nil {
+		return
+	}
+	if header[0] != magicResp {
+		err = errors.Wrapf(ErrBadResp, "bad magic:%x", header[0])
+		return
+	}
+	_, _, bodyLen := parseHeader(header)
+	n.br.AdvanceTo(mark)
+	data, err := n.br.ReadExact(headerLen + bodyLen)
+	if err != nil {
+		return
+	}
+	mcr.resp = append(mcr.resp[:0], data...)
+	return
+}
+
+// Close close the node conn.
+func (n *nodeConn) Close() error {
+	if atomic.CompareAndSwapInt32(&n.state, opened, closed) {
+		return n.conn.Close()
+	}
+	return nil
+}
+
+// Closed return whether the node conn is closed.
+func (n *nodeConn) Closed() bool {
+	return atomic.LoadInt32(&n.state) == closed
+}
@@ -0,0 +1,78 @@
+package binary
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _createNodeConn(data []byte) *nodeConn {
+	conn := libnet.NewConn(mockconn.CreateMockConn(data, 1), time.Second, time.Second)
+	return newNodeConn("test", "127.0.0.1:11211", conn).(*nodeConn)
+}
+
+func _createReqMsg(data []byte) *proto.Message {
+	mcr := newReq()
+	mcr.rTp = RequestType(data[1])
+	mcr.header = data[:headerLen]
+	mcr.data = data[headerLen:]
+	msg := proto.NewMessage()
+	msg.WithRequest(mcr)
+	return msg
+}
+
+func TestNodeConnWriteOk(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Req    []byte
+		Expect []byte
+	}{
+		{Name: "get", Req: _req(RequestTypeGet, 1, "", "a", ""), Expect: _req(RequestTypeGet, 1, "", "a", "")},
+		{Name: "getq", Req: _req(RequestTypeGetQ, 1, "", "a", ""), Expect: _req(RequestTypeGet, 1, "", "a", "")},
+		{Name: "getkq", Req: _req(RequestTypeGetKQ, 1, "", "a", ""), Expect: _req(RequestTypeGetK, 1, "", "a", "")},
+		{Name: "setq", Req: _req(RequestTypeSetQ, 1, "\x00\x00\x00\x00\x00\x00\x00\x00", "a", "abc"), Expect: _req(RequestTypeSet, 1, "\x00\x00\x00\x00\x00\x00\x00\x00", "a", "abc")},
+		{Name: "noop", Req: _req(RequestTypeNoop, 1, "", "", ""), Expect: _req(RequestTypeNoop, 1, "", "", "")},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			nc := _createNodeConn(nil)
+			assert.NoError(t, nc.Write(_createReqMsg(tt.Req)))
+			assert.NoError(t, nc.Flush())
+			m, ok := nc.conn.Conn.(*mockconn.MockConn)
+			assert.True(t, ok)
+			assert.Equal(t, tt.Expect, m.Wbuf.Bytes())
+		})
+	}
+}
+
+func TestNodeConnReadOk(t *testing.T) {
+	resp := _resp(RequestTypeGet, 0, 1, "\x00\x00\x00\x00", "", "abc")
+	nc := _createNodeConn(_join(resp, resp))
+	msg := _createReqMsg(_req(RequestTypeGet, 1, "", "a", ""))
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, resp, msg.Request().(*MCRequest).Resp())
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, resp, msg.Request().(*MCRequest).Resp())
+}
+
+func TestNodeConnReadBadResp(t *testing.T) {
+	nc := _createNodeConn(_req(RequestTypeGet, 1, "", "a", ""))
+	err := nc.Read(_createReqMsg(_req(RequestTypeGet, 1, "", "a", "")))
+	assert.Equal(t, ErrBadResp, errors.Cause(err))
+}
+
+func TestNodeConnClosed(t *testing.T) {
+	nc := _createNodeConn(nil)
+	assert.NoError(t, nc.Close())
+	assert.NoError(t, nc.Close())
+	msg := _createReqMsg(_req(RequestTypeGet, 1, "", "a", ""))
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Write(msg)))
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Read(msg)))
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Flush()))
+}
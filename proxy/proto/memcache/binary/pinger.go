@@ -0,0 +1,83 @@
+package binary
+
+import (
+	"encoding/binary"
+	errs "errors"
+	"sync/atomic"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pingBufferSize = 128
+)
+
+// errors
+var (
+	ErrPingClosed = errs.New("ping interface has been closed")
+	ErrBadPong    = errs.New("pong response payload is bad")
+)
+
+var (
+	pingBytes = []byte{
+		magicReq, byte(RequestTypeNoop), 0x00, 0x00, // magic,opcode,key length
+		0x00, 0x00, 0x00, 0x00, // extra length,data type,vbucket
+		0x00, 0x00, 0x00, 0x00, // total body length
+		0x00, 0x00, 0x00, 0x00, // opaque
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // cas
+	}
+)
+
+// node健康检查器，使用noop命令探活，不会写入数据
+type pinger struct {
+	conn *libnet.Conn
+
+	br *bufio.Reader
+	bw *bufio.Writer
+
+	state int32
+}
+
+// NewPinger new pinger.
+func NewPinger(nc *libnet.Conn) proto.Pinger {
+	return &pinger{
+		conn:  nc,
+		bw:    bufio.NewWriter(nc),
+		br:    bufio.NewReader(nc, bufio.NewBuffer(pingBufferSize)),
+		state: opened,
+	}
+}
+
+func (p *pinger) Ping() (err error) {
+	if atomic.LoadInt32(&p.state) == closed {
+		err = errors.WithStack(ErrPingClosed)
+		return
+	}
+	_ = p.bw.Write(pingBytes)
+	if err = p.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	_ = p.br.Read()
+	defer p.br.Buffer().Reset()
+	head, err := p.br.ReadExact(headerLen)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if head[0] != magicResp || RequestType(head[1]) != RequestTypeNoop || binary.BigEndian.Uint16(head[6:8]) != statusNoErr {
+		err = errors.WithStack(ErrBadPong)
+	}
+	return
+}
+
+func (p *pinger) Close() error {
+	if atomic.CompareAndSwapInt32(&p.state, opened, closed) {
+		return p.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package binary
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingerPingOk(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn(_resp(RequestTypeNoop, 0, 0, "", "", ""), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.NoError(t, p.Ping())
+}
+
+func TestPingerClosed(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn(_resp(RequestTypeNoop, 0, 0, "", "", ""), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.NoError(t, p.Close())
+	assert.Equal(t, ErrPingClosed, errors.Cause(p.Ping()))
+	assert.NoError(t, p.Close())
+}
+
+func TestPingerWrongResp(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn(_resp(RequestTypeVersion, 0, 0, "", "", "1.6.9"), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.Equal(t, ErrBadPong, errors.Cause(p.Ping()))
+}
@@ -0,0 +1,225 @@
+package binary
+
+import (
+	"encoding/binary"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+type proxyConn struct {
+	br        *bufio.Reader //终端连接上的读缓冲区
+	bw        *bufio.Writer //终端连接上的写缓冲区
+	completed bool          //br缓冲是否需要再次从连接读取
+}
+
+// NewProxyConn new a memcache binary decoder and encode.
+func NewProxyConn(rw *libnet.Conn) proto.ProxyConn {
+	p := &proxyConn{
+		br:        bufio.NewReader(rw, bufio.Get(1024)),
+		bw:        bufio.NewWriter(rw),
+		completed: true,
+	}
+	return p
+}
+
+func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
+	var err error
+	if p.completed {
+		if err = p.br.Read(); err != nil {
+			return nil, err
+		}
+		p.completed = false
+	}
+	for i := range msgs {
+		msgs[i].Type = types.CacheTypeMemcacheBinary
+		mark := p.br.Mark()
+		// decode
+		if err = p.decode(msgs[i]); err == bufio.ErrBufferFull {
+			p.br.AdvanceTo(mark)
+			msgs[i].Reset()
+			p.completed = true
+			return msgs[:i], nil
+		} else if err != nil {
+			return nil, err
+		}
+		// NOTE: quit必须是消息组里唯一的消息，由CmdCheck处理
+		if isQuit(msgs[i]) {
+			if i > 0 {
+				p.br.AdvanceTo(mark)
+				msgs[i].Reset()
+				return msgs[:i], nil
+			}
+			msgs[i].MarkStart()
+			return msgs[:1], nil
+		}
+		msgs[i].MarkStart()
+	}
+	return msgs, nil
+}
+
+// decode decode one request, the quiet get pipeline like GETQ,GETKQ...NOOP decode into one message as batch.
+func (p *proxyConn) decode(m *proto.Message) (err error) {
+	r := nextReq(m)
+	if err = p.decodeOne(r); err != nil {
+		return
+	}
+	if !r.rTp.isQuietGet() {
+		return
+	}
+	for {
+		// 先看下一个请求的opcode，非quiet get和noop时结束pipeline，留给下一个消息
+		mark := p.br.Mark()
+		var header []byte
+		if header, err = p.br.ReadExact(headerLen); err != nil {
+			return
+		}
+		p.br.AdvanceTo(mark)
+		rtp := RequestType(header[1])
+		if !rtp.isQuietGet() && rtp != RequestTypeNoop {
+			return
+		}
+		if err = p.decodeOne(nextReq(m)); err != nil || rtp == RequestTypeNoop {
+			return
+		}
+	}
+}
+
+func (p *proxyConn) decodeOne(r *MCRequest) (err error) {
+	header, err := p.br.ReadExact(headerLen)
+	if err != nil {
+		return
+	}
+	if header[0] != magicReq {
+		err = errors.Wrapf(ErrBadRequest, "MC binary decoder bad magic:%x", header[0])
+		return
+	}
+	rtp := RequestType(header[1])
+	if _, ok := reqTypeStrings[rtp]; !ok {
+		err = errors.Wrapf(ErrBadRequest, "MC binary decoder unsupported opcode:%x", header[1])
+		return
+	}
+	extLen, keyLen, bodyLen := parseHeader(header)
+	if extLen+keyLen > bodyLen {
+		err = errors.Wrapf(ErrBadLength, "MC binary decoder bad body length:%d", bodyLen)
+		return
+	}
+	if keyLen > maxKeyLen || (keyLen == 0) == rtp.withKey() {
+		err = errors.Wrapf(ErrBadKey, "MC binary decoder %s with key length:%d", rtp, keyLen)
+		return
+	}
+	r.header = append(r.header[:0], header...)
+	data, err := p.br.ReadExact(bodyLen)
+	if err != nil {
+		return
+	}
+	r.rTp = rtp
+	r.data = append(r.data[:0], data...)
+	r.key = r.data[extLen : extLen+keyLen]
+	return
+}
+
+func nextReq(m *proto.Message) *MCRequest {
+	req := m.NextReq()
+	if req == nil {
+		r := newReq()
+		m.WithRequest(r)
+		return r
+	}
+	return req.(*MCRequest)
+}
+
+func isQuit(m *proto.Message) bool {
+	mcr, ok := m.Request().(*MCRequest)
+	return ok && (mcr.rTp == RequestTypeQuit || mcr.rTp == RequestTypeQuitQ)
+}
+
+// Encode encode response and write into writer.
+func (p *proxyConn) Encode(m *proto.Message) (err error) {
+	if !m.IsBatch() {
+		return p.encode(m.Request(), m.Err())
+	}
+	subs := m.Batch()
+	// NOTE: only the error of batch itself fails all subs, the failed sub doesn't affect others
+	perr := m.OwnErr()
+	for _, sub := range subs {
+		serr := sub.Err()
+		if serr == nil {
+			serr = perr
+		}
+		if err = p.encode(sub.Request(), serr); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *proxyConn) encode(req proto.Request, rerr error) (err error) {
+	mcr, ok := req.(*MCRequest)
+	if !ok {
+		return errors.WithStack(ErrBadAssert)
+	}
+	if rerr != nil {
+		return p.writeErr(mcr, errors.Cause(rerr).Error())
+	}
+	if len(mcr.resp) < headerLen {
+		return p.writeErr(mcr, ErrBadResp.Error())
+	}
+	if mcr.rTp.isQuiet() {
+		status := mcr.respStatus()
+		if status == statusNoErr && !mcr.rTp.isQuietGet() {
+			return // quiet写命令成功时不回复
+		}
+		if status == statusKeyNotFound && mcr.rTp.isQuietGet() {
+			return // quiet get未命中时不回复
+		}
+	}
+	// 还原成客户端请求的opcode
+	mcr.resp[1] = byte(mcr.rTp)
+	return p.bw.Write(mcr.resp)
+}
+
+// writeErr write the internal error response with the opaque of request.
+func (p *proxyConn) writeErr(mcr *MCRequest, msg string) error {
+	var header [headerLen]byte
+	header[0] = magicResp
+	header[1] = byte(mcr.rTp)
+	binary.BigEndian.PutUint16(header[6:8], statusInternalError)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(msg)))
+	if len(mcr.header) == headerLen {
+		copy(header[12:16], mcr.header[12:16]) // opaque
+	}
+	_ = p.bw.Write(header[:])
+	return p.bw.Write([]byte(msg))
+}
+
+// Flush flush the writer buffer.
+func (p *proxyConn) Flush() error {
+	return p.bw.Flush()
+}
+
+// IsAuthorized memcache has no auth, always true.
+func (p *proxyConn) IsAuthorized() bool {
+	return true
+}
+
+// CmdCheck handle the quit command which will close the client conn.
+func (p *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
+	if !isQuit(m) {
+		return
+	}
+	mcr := m.Request().(*MCRequest)
+	if mcr.rTp == RequestTypeQuit {
+		// quit需要回复后再关闭连接
+		var header [headerLen]byte
+		header[0] = magicResp
+		header[1] = byte(RequestTypeQuit)
+		copy(header[12:16], mcr.header[12:16])
+		_ = p.bw.Write(header[:])
+	}
+	return true, proto.ErrQuit
+}
@@ -0,0 +1,284 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// _packet build a binary packet: header + extras + key + value.
+func _packet(magic byte, rtp RequestType, status uint16, opaque uint32, extras, key, value string) []byte {
+	header := make([]byte, headerLen)
+	header[0] = magic
+	header[1] = byte(rtp)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], opaque)
+	buf := bytes.NewBuffer(header)
+	buf.WriteString(extras)
+	buf.WriteString(key)
+	buf.WriteString(value)
+	return buf.Bytes()
+}
+
+func _req(rtp RequestType, opaque uint32, extras, key, value string) []byte {
+	return _packet(magicReq, rtp, 0, opaque, extras, key, value)
+}
+
+func _resp(rtp RequestType, status uint16, opaque uint32, extras, key, value string) []byte {
+	return _packet(magicResp, rtp, status, opaque, extras, key, value)
+}
+
+func _join(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+func _decodeMessage(t *testing.T, data []byte) []*proto.Message {
+	conn := libnet.NewConn(mockconn.CreateMockConn(data, 1), time.Second, time.Second)
+	pc := NewProxyConn(conn)
+	msgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	return msgs
+}
+
+func TestProxyConnDecodeOk(t *testing.T) {
+	ts := []struct {
+		Name string
+		Data []byte
+		Type RequestType
+		Key  string
+		Body string
+	}{
+		{Name: "get", Data: _req(RequestTypeGet, 1, "", "mykey", ""), Type: RequestTypeGet, Key: "mykey", Body: "mykey"},
+		{Name: "set", Data: _req(RequestTypeSet, 1, "\x00\x00\x00\x00\x00\x00\x0e\x10", "mykey", "abc"), Type: RequestTypeSet, Key: "mykey", Body: "\x00\x00\x00\x00\x00\x00\x0e\x10mykeyabc"},
+		{Name: "setq", Data: _req(RequestTypeSetQ, 1, "\x00\x00\x00\x00\x00\x00\x00\x00", "mykey", "abc"), Type: RequestTypeSetQ, Key: "mykey", Body: "\x00\x00\x00\x00\x00\x00\x00\x00mykeyabc"},
+		{Name: "delete", Data: _req(RequestTypeDelete, 1, "", "mykey", ""), Type: RequestTypeDelete, Key: "mykey", Body: "mykey"},
+		{Name: "noop", Data: _req(RequestTypeNoop, 1, "", "", ""), Type: RequestTypeNoop, Key: "", Body: ""},
+		{Name: "version", Data: _req(RequestTypeVersion, 1, "", "", ""), Type: RequestTypeVersion, Key: "", Body: ""},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msgs := _decodeMessage(t, tt.Data)
+			if !assert.Len(t, msgs, 1) {
+				return
+			}
+			assert.False(t, msgs[0].IsBatch())
+			mcr := msgs[0].Request().(*MCRequest)
+			assert.Equal(t, tt.Type, mcr.rTp)
+			assert.Equal(t, tt.Key, string(mcr.Key()))
+			assert.Equal(t, tt.Body, string(mcr.data))
+			assert.Equal(t, tt.Data[:headerLen], mcr.header)
+		})
+	}
+}
+
+func TestProxyConnDecodeQuietPipeline(t *testing.T) {
+	data := _join(
+		_req(RequestTypeGetQ, 1, "", "a", ""),
+		_req(RequestTypeGetKQ, 2, "", "b", ""),
+		_req(RequestTypeGetQ, 3, "", "c", ""),
+		_req(RequestTypeNoop, 4, "", "", ""),
+		_req(RequestTypeGet, 5, "", "d", ""),
+	)
+	msgs := _decodeMessage(t, data)
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+	assert.True(t, msgs[0].IsBatch())
+	reqs := msgs[0].Requests()
+	assert.Len(t, reqs, 4)
+	for i, rtp := range []RequestType{RequestTypeGetQ, RequestTypeGetKQ, RequestTypeGetQ, RequestTypeNoop} {
+		assert.Equal(t, rtp, reqs[i].(*MCRequest).rTp)
+	}
+	assert.False(t, msgs[1].IsBatch())
+	assert.Equal(t, "d", string(msgs[1].Request().Key()))
+}
+
+func TestProxyConnDecodeQuietPipelineBreak(t *testing.T) {
+	data := _join(
+		_req(RequestTypeGetQ, 1, "", "a", ""),
+		_req(RequestTypeGetQ, 2, "", "b", ""),
+		_req(RequestTypeGetK, 3, "", "c", ""),
+	)
+	msgs := _decodeMessage(t, data)
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+	assert.Len(t, msgs[0].Requests(), 2)
+	assert.Equal(t, RequestTypeGetK, msgs[1].Request().(*MCRequest).rTp)
+}
+
+func TestProxyConnDecodeNotEnough(t *testing.T) {
+	data := _join(
+		_req(RequestTypeGet, 1, "", "a", ""),
+		_req(RequestTypeGetQ, 2, "", "b", ""),
+		_req(RequestTypeGetQ, 3, "", "c", ""),
+	)
+	msgs := _decodeMessage(t, data)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "a", string(msgs[0].Request().Key()))
+
+	data = _req(RequestTypeSet, 1, "\x00\x00\x00\x00\x00\x00\x00\x00", "a", "abc")
+	msgs = _decodeMessage(t, data[:len(data)-1])
+	assert.Len(t, msgs, 0)
+}
+
+func TestProxyConnDecodeQuit(t *testing.T) {
+	data := _join(
+		_req(RequestTypeGet, 1, "", "a", ""),
+		_req(RequestTypeQuit, 2, "", "", ""),
+	)
+	mc := mockconn.CreateMockConn(data, 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second))
+	msgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.False(t, special)
+	assert.NoError(t, err)
+
+	msgs, err = pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.True(t, special)
+	assert.Equal(t, proto.ErrQuit, err)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, _resp(RequestTypeQuit, 0, 2, "", "", ""), mc.Wbuf.Bytes())
+}
+
+func TestProxyConnDecodeErr(t *testing.T) {
+	badBody := _req(RequestTypeGet, 1, "", "a", "")
+	binary.BigEndian.PutUint32(badBody[8:12], 0)
+	ts := []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{Name: "badMagic", Data: _resp(RequestTypeGet, 0, 1, "", "a", ""), Err: ErrBadRequest},
+		{Name: "unsupported", Data: _req(RequestType(0x08), 1, "", "", ""), Err: ErrBadRequest},
+		{Name: "getNoKey", Data: _req(RequestTypeGet, 1, "", "", ""), Err: ErrBadKey},
+		{Name: "noopWithKey", Data: _req(RequestTypeNoop, 1, "", "a", ""), Err: ErrBadKey},
+		{Name: "longKey", Data: _req(RequestTypeGet, 1, "", string(make([]byte, 251)), ""), Err: ErrBadKey},
+		{Name: "badBodyLength", Data: badBody, Err: ErrBadLength},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := libnet.NewConn(mockconn.CreateMockConn(tt.Data, 1), time.Second, time.Second)
+			pc := NewProxyConn(conn)
+			_, err := pc.Decode(proto.GetMsgs(1))
+			assert.Equal(t, tt.Err, errors.Cause(err))
+		})
+	}
+}
+
+func TestProxyConnEncodeOk(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Types  []RequestType
+		Resps  [][]byte
+		Expect []byte
+	}{
+		{
+			Name:   "get",
+			Types:  []RequestType{RequestTypeGet},
+			Resps:  [][]byte{_resp(RequestTypeGet, 0, 1, "\x00\x00\x00\x00", "", "abc")},
+			Expect: _resp(RequestTypeGet, 0, 1, "\x00\x00\x00\x00", "", "abc"),
+		},
+		{
+			Name:   "setqOk",
+			Types:  []RequestType{RequestTypeSetQ},
+			Resps:  [][]byte{_resp(RequestTypeSet, 0, 1, "", "", "")},
+			Expect: nil,
+		},
+		{
+			Name:   "setqFail",
+			Types:  []RequestType{RequestTypeSetQ},
+			Resps:  [][]byte{_resp(RequestTypeSet, 0x0005, 1, "", "", "Not stored")},
+			Expect: _resp(RequestTypeSetQ, 0x0005, 1, "", "", "Not stored"),
+		},
+		{
+			Name:  "quietPipeline",
+			Types: []RequestType{RequestTypeGetQ, RequestTypeGetKQ, RequestTypeGetKQ, RequestTypeNoop},
+			Resps: [][]byte{
+				_resp(RequestTypeGet, statusKeyNotFound, 1, "", "", "Not found"),
+				_resp(RequestTypeGetK, 0, 2, "\x00\x00\x00\x00", "b", "2"),
+				_resp(RequestTypeGetK, statusKeyNotFound, 3, "", "c", "Not found"),
+				_resp(RequestTypeNoop, 0, 4, "", "", ""),
+			},
+			Expect: _join(
+				_resp(RequestTypeGetKQ, 0, 2, "\x00\x00\x00\x00", "b", "2"),
+				_resp(RequestTypeNoop, 0, 4, "", "", ""),
+			),
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for i, rs := range tt.Resps {
+				mcr := newReq()
+				mcr.rTp = tt.Types[i]
+				mcr.resp = rs
+				msg.WithRequest(mcr)
+			}
+			conn, buf := mockconn.CreateMockDownStremConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.Bytes())
+		})
+	}
+}
+
+func TestProxyConnEncodeErr(t *testing.T) {
+	msg := proto.NewMessage()
+	mcr := newReq()
+	mcr.rTp = RequestTypeGetQ
+	mcr.header = _req(RequestTypeGetQ, 7, "", "a", "")[:headerLen]
+	msg.WithRequest(mcr)
+	msg.WithError(errors.New("some error"))
+	conn, buf := mockconn.CreateMockDownStremConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, _resp(RequestTypeGetQ, statusInternalError, 7, "", "", "some error"), buf.Bytes())
+}
+
+func TestProxyConnEncodeSubErr(t *testing.T) {
+	msg := proto.NewMessage()
+	types := []RequestType{RequestTypeGetKQ, RequestTypeGetKQ, RequestTypeGetKQ, RequestTypeNoop}
+	resps := [][]byte{
+		nil,
+		_resp(RequestTypeGetK, 0, 2, "\x00\x00\x00\x00", "b", "2"),
+		_resp(RequestTypeGetK, statusKeyNotFound, 3, "", "c", "Not found"),
+		_resp(RequestTypeNoop, 0, 4, "", "", ""),
+	}
+	for i, rs := range resps {
+		mcr := newReq()
+		mcr.rTp = types[i]
+		mcr.header = _req(types[i], uint32(i+1), "", "", "")[:headerLen]
+		mcr.resp = rs
+		msg.WithRequest(mcr)
+	}
+	msg.Batch()[0].WithError(errors.New("some error"))
+	conn, buf := mockconn.CreateMockDownStremConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	// the hit and the quiet miss are not failed by the failed key
+	assert.Equal(t, _join(
+		_resp(RequestTypeGetKQ, statusInternalError, 1, "", "", "some error"),
+		_resp(RequestTypeGetKQ, 0, 2, "\x00\x00\x00\x00", "b", "2"),
+		_resp(RequestTypeNoop, 0, 4, "", "", ""),
+	), buf.Bytes())
+}
@@ -0,0 +1,248 @@
+package binary
+
+// memcache二进制协议消息数据对应的`Request`接口实现
+import (
+	"encoding/binary"
+	errs "errors"
+	"sync"
+
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+)
+
+const (
+	headerLen = 24  // 请求和回复的固定头部长度
+	maxKeyLen = 250 // memcached协议规定key最大长度
+
+	magicReq  = byte(0x80)
+	magicResp = byte(0x81)
+)
+
+// RequestType is the opcode of the binary command.
+type RequestType byte
+
+// all memcache binary request type
+const (
+	RequestTypeGet      RequestType = 0x00
+	RequestTypeSet      RequestType = 0x01
+	RequestTypeAdd      RequestType = 0x02
+	RequestTypeReplace  RequestType = 0x03
+	RequestTypeDelete   RequestType = 0x04
+	RequestTypeIncr     RequestType = 0x05
+	RequestTypeDecr     RequestType = 0x06
+	RequestTypeQuit     RequestType = 0x07
+	RequestTypeGetQ     RequestType = 0x09
+	RequestTypeNoop     RequestType = 0x0a
+	RequestTypeVersion  RequestType = 0x0b
+	RequestTypeGetK     RequestType = 0x0c
+	RequestTypeGetKQ    RequestType = 0x0d
+	RequestTypeAppend   RequestType = 0x0e
+	RequestTypePrepend  RequestType = 0x0f
+	RequestTypeSetQ     RequestType = 0x11
+	RequestTypeAddQ     RequestType = 0x12
+	RequestTypeReplaceQ RequestType = 0x13
+	RequestTypeDeleteQ  RequestType = 0x14
+	RequestTypeIncrQ    RequestType = 0x15
+	RequestTypeDecrQ    RequestType = 0x16
+	RequestTypeQuitQ    RequestType = 0x17
+	RequestTypeAppendQ  RequestType = 0x19
+	RequestTypePrependQ RequestType = 0x1a
+	RequestTypeTouch    RequestType = 0x1c
+	RequestTypeGat      RequestType = 0x1d
+	RequestTypeGatQ     RequestType = 0x1e
+	RequestTypeGatK     RequestType = 0x23
+	RequestTypeGatKQ    RequestType = 0x24
+
+	RequestTypeUnknown RequestType = 0xff
+)
+
+// response status
+const (
+	statusNoErr         = uint16(0x0000)
+	statusKeyNotFound   = uint16(0x0001)
+	statusInternalError = uint16(0x0084)
+)
+
+var reqTypeStrings = map[RequestType]string{
+	RequestTypeGet:      "get",
+	RequestTypeSet:      "set",
+	RequestTypeAdd:      "add",
+	RequestTypeReplace:  "replace",
+	RequestTypeDelete:   "delete",
+	RequestTypeIncr:     "incr",
+	RequestTypeDecr:     "decr",
+	RequestTypeQuit:     "quit",
+	RequestTypeGetQ:     "getq",
+	RequestTypeNoop:     "noop",
+	RequestTypeVersion:  "version",
+	RequestTypeGetK:     "getk",
+	RequestTypeGetKQ:    "getkq",
+	RequestTypeAppend:   "append",
+	RequestTypePrepend:  "prepend",
+	RequestTypeSetQ:     "setq",
+	RequestTypeAddQ:     "addq",
+	RequestTypeReplaceQ: "replaceq",
+	RequestTypeDeleteQ:  "deleteq",
+	RequestTypeIncrQ:    "incrq",
+	RequestTypeDecrQ:    "decrq",
+	RequestTypeQuitQ:    "quitq",
+	RequestTypeAppendQ:  "appendq",
+	RequestTypePrependQ: "prependq",
+	RequestTypeTouch:    "touch",
+	RequestTypeGat:      "gat",
+	RequestTypeGatQ:     "gatq",
+	RequestTypeGatK:     "gatk",
+	RequestTypeGatKQ:    "gatkq",
+}
+
+// quiet命令发往backend时换成对应的非quiet命令，保证每个请求都有回复
+var quietToLoud = map[RequestType]RequestType{
+	RequestTypeGetQ:     RequestTypeGet,
+	RequestTypeGetKQ:    RequestTypeGetK,
+	RequestTypeSetQ:     RequestTypeSet,
+	RequestTypeAddQ:     RequestTypeAdd,
+	RequestTypeReplaceQ: RequestTypeReplace,
+	RequestTypeDeleteQ:  RequestTypeDelete,
+	RequestTypeIncrQ:    RequestTypeIncr,
+	RequestTypeDecrQ:    RequestTypeDecr,
+	RequestTypeQuitQ:    RequestTypeQuit,
+	RequestTypeAppendQ:  RequestTypeAppend,
+	RequestTypePrependQ: RequestTypePrepend,
+	RequestTypeGatQ:     RequestTypeGat,
+	RequestTypeGatKQ:    RequestTypeGatK,
+}
+
+var (
+	unknownString = "unknown"
+	unknownBytes  = []byte(unknownString)
+)
+
+// errors
+var (
+	ErrBadAssert  = errs.New("bad assert for memcache binary")
+	ErrBadRequest = errs.New("bad request")
+	ErrBadKey     = errs.New("key is not legal")
+	ErrBadLength  = errs.New("bad request length")
+	ErrBadResp    = errs.New("bad response")
+)
+
+// String get reqtype string.
+func (rt RequestType) String() string {
+	if s, ok := reqTypeStrings[rt]; ok {
+		return s
+	}
+	return unknownString
+}
+
+// Bytes get reqtype bytes.
+func (rt RequestType) Bytes() []byte {
+	if s, ok := reqTypeStrings[rt]; ok {
+		return []byte(s)
+	}
+	return unknownBytes
+}
+
+// isQuiet reports whether the command is a quiet command.
+func (rt RequestType) isQuiet() bool {
+	_, ok := quietToLoud[rt]
+	return ok
+}
+
+// isQuietGet reports whether the command is a quiet retrieval which only replies on hit.
+func (rt RequestType) isQuietGet() bool {
+	switch rt {
+	case RequestTypeGetQ, RequestTypeGetKQ, RequestTypeGatQ, RequestTypeGatKQ:
+		return true
+	}
+	return false
+}
+
+// loud returns the non-quiet command of the quiet one.
+func (rt RequestType) loud() RequestType {
+	if l, ok := quietToLoud[rt]; ok {
+		return l
+	}
+	return rt
+}
+
+// withKey reports whether the command must carry a key.
+func (rt RequestType) withKey() bool {
+	switch rt {
+	case RequestTypeNoop, RequestTypeVersion, RequestTypeQuit, RequestTypeQuitQ:
+		return false
+	}
+	return true
+}
+
+// MCRequest is the type of a complete memcache binary command.
+type MCRequest struct {
+	rTp    RequestType
+	header []byte // 24字节请求头
+	data   []byte // 请求体：extras + key + value
+	key    []byte // data中key部分的切片
+	resp   []byte // backend返回的完整回复
+}
+
+var reqPool = &sync.Pool{
+	New: func() interface{} {
+		return &MCRequest{}
+	},
+}
+
+// newReq get a request from pool.
+func newReq() *MCRequest {
+	return reqPool.Get().(*MCRequest)
+}
+
+// CmdString get cmd.
+func (r *MCRequest) CmdString() string {
+	return r.rTp.String()
+}
+
+// Cmd get Msg cmd.
+func (r *MCRequest) Cmd() []byte {
+	return r.rTp.Bytes()
+}
+
+// Key get Msg key.
+func (r *MCRequest) Key() []byte {
+	return r.key
+}
+
+// Resp get response bytes.
+func (r *MCRequest) Resp() []byte {
+	return r.resp
+}
+
+// Slowlog impl the proto.Request interface, the binary body is not kept.
+func (r *MCRequest) Slowlog() *proto.SlowlogEntry {
+	slog := proto.NewSlowlogEntry(types.CacheTypeMemcacheBinary)
+	slog.Cmd = []string{r.rTp.String(), string(r.key)}
+	return slog
+}
+
+// Put put req back to pool.
+func (r *MCRequest) Put() {
+	r.rTp = RequestTypeUnknown
+	r.header = r.header[:0]
+	r.data = r.data[:0]
+	r.key = nil
+	r.resp = r.resp[:0]
+	reqPool.Put(r)
+}
+
+// respStatus returns the status of the response.
+func (r *MCRequest) respStatus() uint16 {
+	if len(r.resp) < headerLen {
+		return statusInternalError
+	}
+	return binary.BigEndian.Uint16(r.resp[6:8])
+}
+
+// parseHeader returns extras length, key length and total body length of the header.
+func parseHeader(header []byte) (extLen, keyLen, bodyLen int) {
+	keyLen = int(binary.BigEndian.Uint16(header[2:4]))
+	extLen = int(header[4])
+	bodyLen = int(binary.BigEndian.Uint32(header[8:12]))
+	return
+}
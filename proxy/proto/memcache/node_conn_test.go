@@ -0,0 +1,95 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _createNodeConn(data []byte) *nodeConn {
+	conn := libnet.NewConn(mockconn.CreateMockConn(data, 1), time.Second, time.Second)
+	return newNodeConn("test", "127.0.0.1:11211", conn).(*nodeConn)
+}
+
+func _createReqMsg(rtp RequestType, key, data string) *proto.Message {
+	mcr := newReq()
+	mcr.rTp = rtp
+	mcr.key = []byte(key)
+	mcr.data = []byte(data)
+	msg := proto.NewMessage()
+	msg.WithRequest(mcr)
+	return msg
+}
+
+func TestNodeConnWriteOk(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Type   RequestType
+		Key    string
+		Data   string
+		Expect string
+	}{
+		{Name: "set", Type: RequestTypeSet, Key: "a", Data: " 0 0 1\r\n1\r\n", Expect: "set a 0 0 1\r\n1\r\n"},
+		{Name: "get", Type: RequestTypeGet, Key: "a", Data: "\r\n", Expect: "get a\r\n"},
+		{Name: "gat", Type: RequestTypeGat, Key: "a", Data: "100", Expect: "gat 100 a\r\n"},
+		{Name: "incr", Type: RequestTypeIncr, Key: "a", Data: " 2\r\n", Expect: "incr a 2\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			nc := _createNodeConn(nil)
+			err := nc.Write(_createReqMsg(tt.Type, tt.Key, tt.Data))
+			assert.NoError(t, err)
+			assert.NoError(t, nc.Flush())
+			m, ok := nc.conn.Conn.(*mockconn.MockConn)
+			assert.True(t, ok)
+			assert.Equal(t, tt.Expect, m.Wbuf.String())
+		})
+	}
+}
+
+func TestNodeConnReadOk(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Type   RequestType
+		Data   string
+		Expect string
+	}{
+		{Name: "stored", Type: RequestTypeSet, Data: "STORED\r\n", Expect: "STORED\r\n"},
+		{Name: "incr", Type: RequestTypeIncr, Data: "12\r\n", Expect: "12\r\n"},
+		{Name: "getMiss", Type: RequestTypeGet, Data: "END\r\n", Expect: "END\r\n"},
+		{Name: "getHit", Type: RequestTypeGet, Data: "VALUE a 0 2\r\nab\r\nEND\r\n", Expect: "VALUE a 0 2\r\nab\r\nEND\r\n"},
+		{Name: "getsCRLFInData", Type: RequestTypeGets, Data: "VALUE a 0 4 99\r\na\r\nb\r\nEND\r\n", Expect: "VALUE a 0 4 99\r\na\r\nb\r\nEND\r\n"},
+		{Name: "serverError", Type: RequestTypeGet, Data: "SERVER_ERROR out of memory\r\n", Expect: "SERVER_ERROR out of memory\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			nc := _createNodeConn([]byte(tt.Data))
+			msg := _createReqMsg(tt.Type, "a", "\r\n")
+			err := nc.Read(msg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expect, string(msg.Request().(*MCRequest).Resp()))
+		})
+	}
+}
+
+func TestNodeConnReadBadResp(t *testing.T) {
+	nc := _createNodeConn([]byte("VALUE a 0\r\nab\r\nEND\r\n"))
+	err := nc.Read(_createReqMsg(RequestTypeGet, "a", "\r\n"))
+	assert.Equal(t, ErrBadResp, errors.Cause(err))
+}
+
+func TestNodeConnClosed(t *testing.T) {
+	nc := _createNodeConn(nil)
+	assert.NoError(t, nc.Close())
+	assert.NoError(t, nc.Close())
+	msg := _createReqMsg(RequestTypeGet, "a", "\r\n")
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Write(msg)))
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Read(msg)))
+	assert.Equal(t, ErrNodeConnClosed, errors.Cause(nc.Flush()))
+}
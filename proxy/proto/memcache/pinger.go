@@ -0,0 +1,78 @@
+package memcache
+
+import (
+	"bytes"
+	errs "errors"
+	"sync/atomic"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pingBufferSize = 128
+)
+
+// errors
+var (
+	ErrPingClosed = errs.New("ping interface has been closed")
+	ErrBadPong    = errs.New("pong response payload is bad")
+)
+
+var (
+	pingBytes    = []byte("version\r\n")
+	versionBytes = []byte("VERSION ")
+)
+
+// node健康检查器，使用version命令探活，不会写入数据
+type pinger struct {
+	conn *libnet.Conn
+
+	br *bufio.Reader
+	bw *bufio.Writer
+
+	state int32
+}
+
+// NewPinger new pinger.
+func NewPinger(nc *libnet.Conn) proto.Pinger {
+	return &pinger{
+		conn:  nc,
+		bw:    bufio.NewWriter(nc),
+		br:    bufio.NewReader(nc, bufio.NewBuffer(pingBufferSize)),
+		state: opened,
+	}
+}
+
+func (p *pinger) Ping() (err error) {
+	if atomic.LoadInt32(&p.state) == closed {
+		err = errors.WithStack(ErrPingClosed)
+		return
+	}
+	_ = p.bw.Write(pingBytes)
+	if err = p.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	_ = p.br.Read()
+	defer p.br.Buffer().Reset()
+	data, err := p.br.ReadLine()
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if !bytes.HasPrefix(data, versionBytes) {
+		err = errors.WithStack(ErrBadPong)
+	}
+	return
+}
+
+func (p *pinger) Close() error {
+	if atomic.CompareAndSwapInt32(&p.state, opened, closed) {
+		return p.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingerPingOk(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("VERSION 1.5.22\r\n"), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.NoError(t, p.Ping())
+}
+
+func TestPingerClosed(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("VERSION 1.5.22\r\n"), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.NoError(t, p.Close())
+	assert.Equal(t, ErrPingClosed, errors.Cause(p.Ping()))
+	assert.NoError(t, p.Close())
+}
+
+func TestPingerWrongResp(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("ERROR\r\n"), 1), time.Second, time.Second)
+	p := NewPinger(conn)
+	assert.Equal(t, ErrBadPong, errors.Cause(p.Ping()))
+}
@@ -0,0 +1,326 @@
+package memcache
+
+import (
+	"bytes"
+	"strconv"
+
+	"mycache/pkg/bufio"
+	"mycache/pkg/conv"
+	libnet "mycache/pkg/net"
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxKeyLen = 250 // memcached协议规定key最大长度
+)
+
+var (
+	serverErrorBytes = []byte("SERVER_ERROR ")
+)
+
+type proxyConn struct {
+	br        *bufio.Reader //终端连接上的读缓冲区
+	bw        *bufio.Writer //终端连接上的写缓冲区
+	completed bool          //br缓冲是否需要再次从连接读取
+}
+
+// NewProxyConn new a memcache decoder and encode.
+func NewProxyConn(rw *libnet.Conn) proto.ProxyConn {
+	p := &proxyConn{
+		br:        bufio.NewReader(rw, bufio.Get(1024)),
+		bw:        bufio.NewWriter(rw),
+		completed: true,
+	}
+	return p
+}
+
+func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
+	var err error
+	if p.completed {
+		if err = p.br.Read(); err != nil {
+			return nil, err
+		}
+		p.completed = false
+	}
+	for i := range msgs {
+		msgs[i].Type = types.CacheTypeMemcache
+		mark := p.br.Mark()
+		// decode
+		if err = p.decode(msgs[i]); err == bufio.ErrBufferFull {
+			p.completed = true
+			return msgs[:i], nil
+		} else if err != nil {
+			return nil, err
+		}
+		// NOTE: quit必须是消息组里唯一的消息，由CmdCheck处理
+		if isQuit(msgs[i]) {
+			if i > 0 {
+				p.br.AdvanceTo(mark)
+				msgs[i].Reset()
+				return msgs[:i], nil
+			}
+			msgs[i].MarkStart()
+			return msgs[:1], nil
+		}
+		msgs[i].MarkStart()
+	}
+	return msgs, nil
+}
+
+func (p *proxyConn) decode(m *proto.Message) (err error) {
+	mark := p.br.Mark()
+	line, err := p.br.ReadLine()
+	if err != nil {
+		return
+	}
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		err = errors.WithStack(ErrBadRequest)
+		return
+	}
+	conv.UpdateToLower(fields[0])
+	switch string(fields[0]) {
+	// Storage commands:
+	case setString:
+		return p.decodeStorage(m, mark, fields, RequestTypeSet)
+	case addString:
+		return p.decodeStorage(m, mark, fields, RequestTypeAdd)
+	case replaceString:
+		return p.decodeStorage(m, mark, fields, RequestTypeReplace)
+	case appendString:
+		return p.decodeStorage(m, mark, fields, RequestTypeAppend)
+	case prependString:
+		return p.decodeStorage(m, mark, fields, RequestTypePrepend)
+	case casString:
+		return p.decodeStorage(m, mark, fields, RequestTypeCas)
+	// Retrieval commands:
+	case getString:
+		return p.decodeRetrieval(m, fields[1:], nil, RequestTypeGet)
+	case getsString:
+		return p.decodeRetrieval(m, fields[1:], nil, RequestTypeGets)
+	case gatString:
+		if len(fields) < 2 {
+			break
+		}
+		return p.decodeRetrieval(m, fields[2:], fields[1], RequestTypeGat)
+	case gatsString:
+		if len(fields) < 2 {
+			break
+		}
+		return p.decodeRetrieval(m, fields[2:], fields[1], RequestTypeGats)
+	// Other commands:
+	case deleteString:
+		return p.decodeKeyArgs(m, fields, 0, RequestTypeDelete)
+	case incrString:
+		return p.decodeKeyArgs(m, fields, 1, RequestTypeIncr)
+	case decrString:
+		return p.decodeKeyArgs(m, fields, 1, RequestTypeDecr)
+	case touchString:
+		return p.decodeKeyArgs(m, fields, 1, RequestTypeTouch)
+	case quitString:
+		r := nextReq(m)
+		r.rTp = RequestTypeQuit
+		r.key = r.key[:0]
+		r.data = r.data[:0]
+		return
+	}
+	err = errors.Wrapf(ErrBadRequest, "MC decoder error:%s", fields[0])
+	return
+}
+
+// decodeStorage decode like: <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]\r\n<data block>\r\n
+func (p *proxyConn) decodeStorage(m *proto.Message, mark int, fields [][]byte, rtp RequestType) (err error) {
+	argc := 5 // cmd key flags exptime bytes
+	if rtp == RequestTypeCas {
+		argc++
+	}
+	noreply := len(fields) == argc+1 && bytes.Equal(fields[argc], noreplyBytes)
+	if len(fields) != argc && !noreply {
+		err = errors.Wrapf(ErrBadRequest, "MC decoder storage request:%s", fields[0])
+		return
+	}
+	key := fields[1]
+	if !legalKey(key) {
+		err = errors.Wrapf(ErrBadKey, "MC decoder storage request key:%s", key)
+		return
+	}
+	length, err := conv.Btoi(fields[4])
+	if err != nil || length < 0 {
+		err = errors.Wrapf(ErrBadLength, "MC decoder storage request length:%s", fields[4])
+		return
+	}
+	data, err := p.br.ReadExact(int(length) + 2)
+	if err == bufio.ErrBufferFull {
+		p.br.AdvanceTo(mark)
+		return
+	} else if err != nil {
+		return
+	}
+	if !bytes.HasSuffix(data, crlfBytes) {
+		err = errors.Wrapf(ErrBadLength, "MC decoder storage request data without crlf, key:%s", key)
+		return
+	}
+	r := nextReq(m)
+	r.rTp = rtp
+	r.noreply = noreply
+	r.key = append(r.key[:0], key...)
+	r.data = r.data[:0]
+	for _, f := range fields[2:argc] {
+		r.data = append(r.data, spaceBytes...)
+		r.data = append(r.data, f...)
+	}
+	r.data = append(r.data, crlfBytes...)
+	r.data = append(r.data, data...)
+	return
+}
+
+// decodeRetrieval decode like: get <key>*\r\n | gat <exptime> <key>*\r\n ,每个key拆成一个子请求
+func (p *proxyConn) decodeRetrieval(m *proto.Message, keys [][]byte, exptime []byte, rtp RequestType) (err error) {
+	if len(keys) == 0 {
+		err = errors.Wrapf(ErrBadRequest, "MC decoder retrieval request without key:%s", rtp)
+		return
+	}
+	for _, key := range keys {
+		if !legalKey(key) {
+			err = errors.Wrapf(ErrBadKey, "MC decoder retrieval request key:%s", key)
+			return
+		}
+	}
+	if exptime != nil {
+		if _, err = conv.Btoi(exptime); err != nil {
+			err = errors.Wrapf(ErrBadRequest, "MC decoder retrieval request exptime:%s", exptime)
+			return
+		}
+	}
+	for _, key := range keys {
+		r := nextReq(m)
+		r.rTp = rtp
+		r.key = append(r.key[:0], key...)
+		if exptime != nil {
+			r.data = append(r.data[:0], exptime...)
+		} else {
+			r.data = append(r.data[:0], crlfBytes...)
+		}
+	}
+	return
+}
+
+// decodeKeyArgs decode like: <cmd> <key> [<arg>]{argc} [noreply]\r\n
+func (p *proxyConn) decodeKeyArgs(m *proto.Message, fields [][]byte, argc int, rtp RequestType) (err error) {
+	argc += 2 // cmd key
+	noreply := len(fields) == argc+1 && bytes.Equal(fields[argc], noreplyBytes)
+	if len(fields) != argc && !noreply {
+		err = errors.Wrapf(ErrBadRequest, "MC decoder request:%s", fields[0])
+		return
+	}
+	key := fields[1]
+	if !legalKey(key) {
+		err = errors.Wrapf(ErrBadKey, "MC decoder request key:%s", key)
+		return
+	}
+	r := nextReq(m)
+	r.rTp = rtp
+	r.noreply = noreply
+	r.key = append(r.key[:0], key...)
+	r.data = r.data[:0]
+	for _, f := range fields[2:argc] {
+		r.data = append(r.data, spaceBytes...)
+		r.data = append(r.data, f...)
+	}
+	r.data = append(r.data, crlfBytes...)
+	return
+}
+
+func nextReq(m *proto.Message) *MCRequest {
+	req := m.NextReq()
+	if req == nil {
+		r := newReq()
+		m.WithRequest(r)
+		return r
+	}
+	r := req.(*MCRequest)
+	r.noreply = false
+	return r
+}
+
+func isQuit(m *proto.Message) bool {
+	mcr, ok := m.Request().(*MCRequest)
+	return ok && mcr.rTp == RequestTypeQuit
+}
+
+// legalKey checks the key by memcached protocol: no longer than 250 and no control characters.
+func legalKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for _, c := range key {
+		if c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode encode response and write into writer.
+func (p *proxyConn) Encode(m *proto.Message) (err error) {
+	if err = m.Err(); err != nil {
+		se := errors.Cause(err).Error()
+		_ = p.bw.Write(serverErrorBytes)
+		_ = p.bw.Write([]byte(se))
+		err = p.bw.Write(crlfBytes)
+		return
+	}
+	if !m.IsBatch() {
+		mcr, ok := m.Request().(*MCRequest)
+		if !ok {
+			return errors.WithStack(ErrBadAssert)
+		}
+		if mcr.noreply {
+			return
+		}
+		return p.bw.Write(mcr.resp)
+	}
+	// 合并多key的get回复：按key顺序拼接VALUE，最后统一写END
+	for _, req := range m.Requests() {
+		mcr, ok := req.(*MCRequest)
+		if !ok {
+			return errors.WithStack(ErrBadAssert)
+		}
+		_ = p.bw.Write(bytes.TrimSuffix(mcr.resp, endBytes))
+	}
+	return p.bw.Write(endBytes)
+}
+
+// Flush flush the writer buffer.
+func (p *proxyConn) Flush() error {
+	return p.bw.Flush()
+}
+
+// IsAuthorized memcache has no auth, always true.
+func (p *proxyConn) IsAuthorized() bool {
+	return true
+}
+
+// CmdCheck handle the quit command which will close the client conn.
+func (p *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
+	if isQuit(m) {
+		return true, proto.ErrQuit
+	}
+	return
+}
+
+func findLength(line []byte, cas bool) (int, error) {
+	fields := bytes.Fields(line)
+	// VALUE <key> <flags> <bytes> [<cas unique>]\r\n
+	if len(fields) < 4 || (cas && len(fields) < 5) {
+		return -1, errors.WithStack(ErrBadResp)
+	}
+	length, err := strconv.Atoi(string(fields[3]))
+	if err != nil || length < 0 {
+		return -1, errors.Wrapf(ErrBadResp, "bad VALUE length:%s", fields[3])
+	}
+	return length, nil
+}
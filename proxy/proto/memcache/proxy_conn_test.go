@@ -0,0 +1,174 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _decodeMessage(t *testing.T, data string) []*proto.Message {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn)
+	msgs := proto.GetMsgs(16)
+	nmsgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	return nmsgs
+}
+
+func TestProxyConnDecodeOk(t *testing.T) {
+	ts := []struct {
+		Name    string
+		Data    string
+		Type    RequestType
+		Key     string
+		Body    string
+		Noreply bool
+	}{
+		{Name: "set", Data: "set mykey 0 0 5\r\nabcde\r\n", Type: RequestTypeSet, Key: "mykey", Body: " 0 0 5\r\nabcde\r\n"},
+		{Name: "setNoreply", Data: "set mykey 0 0 5 noreply\r\nabcde\r\n", Type: RequestTypeSet, Key: "mykey", Body: " 0 0 5\r\nabcde\r\n", Noreply: true},
+		{Name: "addUpper", Data: "ADD mykey 1 2 3\r\nabc\r\n", Type: RequestTypeAdd, Key: "mykey", Body: " 1 2 3\r\nabc\r\n"},
+		{Name: "replace", Data: "replace mykey 0 0 1\r\na\r\n", Type: RequestTypeReplace, Key: "mykey", Body: " 0 0 1\r\na\r\n"},
+		{Name: "append", Data: "append mykey 0 0 1\r\na\r\n", Type: RequestTypeAppend, Key: "mykey", Body: " 0 0 1\r\na\r\n"},
+		{Name: "prepend", Data: "prepend mykey 0 0 1\r\na\r\n", Type: RequestTypePrepend, Key: "mykey", Body: " 0 0 1\r\na\r\n"},
+		{Name: "cas", Data: "cas mykey 0 0 3 47\r\nabc\r\n", Type: RequestTypeCas, Key: "mykey", Body: " 0 0 3 47\r\nabc\r\n"},
+		{Name: "get", Data: "get mykey\r\n", Type: RequestTypeGet, Key: "mykey", Body: "\r\n"},
+		{Name: "gets", Data: "gets mykey\r\n", Type: RequestTypeGets, Key: "mykey", Body: "\r\n"},
+		{Name: "gat", Data: "gat 100 mykey\r\n", Type: RequestTypeGat, Key: "mykey", Body: "100"},
+		{Name: "gats", Data: "gats 100 mykey\r\n", Type: RequestTypeGats, Key: "mykey", Body: "100"},
+		{Name: "delete", Data: "delete mykey\r\n", Type: RequestTypeDelete, Key: "mykey", Body: "\r\n"},
+		{Name: "incr", Data: "incr mykey 10\r\n", Type: RequestTypeIncr, Key: "mykey", Body: " 10\r\n"},
+		{Name: "decrNoreply", Data: "decr mykey 10 noreply\r\n", Type: RequestTypeDecr, Key: "mykey", Body: " 10\r\n", Noreply: true},
+		{Name: "touch", Data: "touch mykey 10\r\n", Type: RequestTypeTouch, Key: "mykey", Body: " 10\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msgs := _decodeMessage(t, tt.Data)
+			if !assert.Len(t, msgs, 1) {
+				return
+			}
+			mcr := msgs[0].Request().(*MCRequest)
+			assert.Equal(t, tt.Type, mcr.rTp)
+			assert.Equal(t, tt.Key, string(mcr.Key()))
+			assert.Equal(t, tt.Body, string(mcr.data))
+			assert.Equal(t, tt.Noreply, mcr.noreply)
+		})
+	}
+}
+
+func TestProxyConnDecodeMultiGet(t *testing.T) {
+	msgs := _decodeMessage(t, "get a b c\r\ngat 10 d e\r\n")
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+	assert.True(t, msgs[0].IsBatch())
+	subs := msgs[0].Batch()
+	assert.Len(t, subs, 3)
+	for i, key := range []string{"a", "b", "c"} {
+		mcr := subs[i].Request().(*MCRequest)
+		assert.Equal(t, RequestTypeGet, mcr.rTp)
+		assert.Equal(t, key, string(mcr.Key()))
+	}
+	subs = msgs[1].Batch()
+	assert.Len(t, subs, 2)
+	assert.Equal(t, "e", string(subs[1].Request().Key()))
+	assert.Equal(t, "10", string(subs[1].Request().(*MCRequest).data))
+}
+
+func TestProxyConnDecodeNotEnough(t *testing.T) {
+	msgs := _decodeMessage(t, "get a\r\nset b 0 0 10\r\nabc")
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "a", string(msgs[0].Request().Key()))
+}
+
+func TestProxyConnDecodeQuit(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("get a\r\nquit\r\nget b\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn)
+	msgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.False(t, special)
+	assert.NoError(t, err)
+
+	msgs, err = pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.True(t, special)
+	assert.Equal(t, proto.ErrQuit, err)
+}
+
+func TestProxyConnDecodeErr(t *testing.T) {
+	ts := []struct {
+		Name string
+		Data string
+		Err  error
+	}{
+		{Name: "unknown", Data: "stats\r\n", Err: ErrBadRequest},
+		{Name: "setArgs", Data: "set a 0 0\r\nab\r\n", Err: ErrBadRequest},
+		{Name: "badLength", Data: "set a 0 0 x\r\nab\r\n", Err: ErrBadLength},
+		{Name: "dataNoCrlf", Data: "set a 0 0 1\r\nabc\r\n", Err: ErrBadLength},
+		{Name: "getNoKey", Data: "get\r\n", Err: ErrBadRequest},
+		{Name: "longKey", Data: "get " + string(make([]byte, 251)) + "\r\n", Err: ErrBadKey},
+		{Name: "gatNoKey", Data: "gat 10\r\n", Err: ErrBadRequest},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := libnet.NewConn(mockconn.CreateMockConn([]byte(tt.Data), 1), time.Second, time.Second)
+			pc := NewProxyConn(conn)
+			_, err := pc.Decode(proto.GetMsgs(1))
+			assert.Equal(t, tt.Err, errors.Cause(err))
+		})
+	}
+}
+
+func TestProxyConnEncodeOk(t *testing.T) {
+	ts := []struct {
+		Name    string
+		Resps   []string
+		Noreply bool
+		Expect  string
+	}{
+		{Name: "stored", Resps: []string{"STORED\r\n"}, Expect: "STORED\r\n"},
+		{Name: "noreply", Resps: []string{"STORED\r\n"}, Noreply: true, Expect: ""},
+		{Name: "getOne", Resps: []string{"VALUE a 0 1\r\n1\r\nEND\r\n"}, Expect: "VALUE a 0 1\r\n1\r\nEND\r\n"},
+		{Name: "getMulti", Resps: []string{"VALUE a 0 1\r\n1\r\nEND\r\n", "END\r\n", "VALUE c 0 1\r\n3\r\nEND\r\n"}, Expect: "VALUE a 0 1\r\n1\r\nVALUE c 0 1\r\n3\r\nEND\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for _, rs := range tt.Resps {
+				mcr := newReq()
+				mcr.rTp = RequestTypeGet
+				mcr.noreply = tt.Noreply
+				mcr.resp = []byte(rs)
+				msg.WithRequest(mcr)
+			}
+			if msg.IsBatch() {
+				msg.Batch()
+			}
+			conn, buf := mockconn.CreateMockDownStremConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+}
+
+func TestProxyConnEncodeErr(t *testing.T) {
+	msg := proto.NewMessage()
+	msg.WithRequest(newReq())
+	msg.WithError(errors.New("some error"))
+	conn, buf := mockconn.CreateMockDownStremConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "SERVER_ERROR some error\r\n", buf.String())
+}
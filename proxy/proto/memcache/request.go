@@ -0,0 +1,238 @@
+package memcache
+
+// memcache文本协议消息数据对应的`Request`接口实现
+import (
+	"bytes"
+	errs "errors"
+	"sync"
+
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+)
+
+// RequestType is the protocol-agnostic identifier for the command
+type RequestType byte
+
+// all memcache request type
+const (
+	RequestTypeUnknown RequestType = iota
+	RequestTypeSet
+	RequestTypeAdd
+	RequestTypeReplace
+	RequestTypeAppend
+	RequestTypePrepend
+	RequestTypeCas
+	RequestTypeGet
+	RequestTypeGets
+	RequestTypeDelete
+	RequestTypeIncr
+	RequestTypeDecr
+	RequestTypeTouch
+	RequestTypeGat
+	RequestTypeGats
+	RequestTypeQuit
+)
+
+var (
+	getBytes     = []byte("get")
+	getsBytes    = []byte("gets")
+	setBytes     = []byte("set")
+	addBytes     = []byte("add")
+	replaceBytes = []byte("replace")
+	appendBytes  = []byte("append")
+	prependBytes = []byte("prepend")
+	casBytes     = []byte("cas")
+	deleteBytes  = []byte("delete")
+	incrBytes    = []byte("incr")
+	decrBytes    = []byte("decr")
+	touchBytes   = []byte("touch")
+	gatBytes     = []byte("gat")
+	gatsBytes    = []byte("gats")
+	quitBytes    = []byte("quit")
+	unknownBytes = []byte("unknown")
+)
+
+const (
+	getString     = "get"
+	getsString    = "gets"
+	setString     = "set"
+	addString     = "add"
+	replaceString = "replace"
+	appendString  = "append"
+	prependString = "prepend"
+	casString     = "cas"
+	deleteString  = "delete"
+	incrString    = "incr"
+	decrString    = "decr"
+	touchString   = "touch"
+	gatString     = "gat"
+	gatsString    = "gats"
+	quitString    = "quit"
+	unknownString = "unknown"
+)
+
+var (
+	spaceBytes   = []byte(" ")
+	crlfBytes    = []byte("\r\n")
+	endBytes     = []byte("END\r\n")
+	valueBytes   = []byte("VALUE ")
+	noreplyBytes = []byte("noreply")
+)
+
+// errors
+var (
+	ErrBadAssert  = errs.New("bad assert for memcache")
+	ErrBadRequest = errs.New("bad request")
+	ErrBadKey     = errs.New("key is not legal")
+	ErrBadLength  = errs.New("bad request length")
+	ErrBadResp    = errs.New("bad response")
+)
+
+// Bytes get reqtype bytes.
+func (rt RequestType) Bytes() []byte {
+	switch rt {
+	case RequestTypeSet:
+		return setBytes
+	case RequestTypeAdd:
+		return addBytes
+	case RequestTypeReplace:
+		return replaceBytes
+	case RequestTypeAppend:
+		return appendBytes
+	case RequestTypePrepend:
+		return prependBytes
+	case RequestTypeCas:
+		return casBytes
+	case RequestTypeGet:
+		return getBytes
+	case RequestTypeGets:
+		return getsBytes
+	case RequestTypeDelete:
+		return deleteBytes
+	case RequestTypeIncr:
+		return incrBytes
+	case RequestTypeDecr:
+		return decrBytes
+	case RequestTypeTouch:
+		return touchBytes
+	case RequestTypeGat:
+		return gatBytes
+	case RequestTypeGats:
+		return gatsBytes
+	case RequestTypeQuit:
+		return quitBytes
+	}
+	return unknownBytes
+}
+
+// String get reqtype string.
+func (rt RequestType) String() string {
+	switch rt {
+	case RequestTypeSet:
+		return setString
+	case RequestTypeAdd:
+		return addString
+	case RequestTypeReplace:
+		return replaceString
+	case RequestTypeAppend:
+		return appendString
+	case RequestTypePrepend:
+		return prependString
+	case RequestTypeCas:
+		return casString
+	case RequestTypeGet:
+		return getString
+	case RequestTypeGets:
+		return getsString
+	case RequestTypeDelete:
+		return deleteString
+	case RequestTypeIncr:
+		return incrString
+	case RequestTypeDecr:
+		return decrString
+	case RequestTypeTouch:
+		return touchString
+	case RequestTypeGat:
+		return gatString
+	case RequestTypeGats:
+		return gatsString
+	case RequestTypeQuit:
+		return quitString
+	}
+	return unknownString
+}
+
+// isRetrieval reports whether the reply of the command carries VALUE items and ends with END.
+func (rt RequestType) isRetrieval() bool {
+	switch rt {
+	case RequestTypeGet, RequestTypeGets, RequestTypeGat, RequestTypeGats:
+		return true
+	}
+	return false
+}
+
+// withCas reports whether the VALUE line of the reply carries the cas unique.
+func (rt RequestType) withCas() bool {
+	return rt == RequestTypeGets || rt == RequestTypeGats
+}
+
+// MCRequest is the type of a complete memcache command.
+type MCRequest struct {
+	rTp  RequestType
+	key  []byte
+	data []byte // 命令里key之后的部分（含\r\n），gat/gats时为exptime
+	resp []byte // backend返回的完整回复
+
+	noreply bool
+}
+
+var reqPool = &sync.Pool{
+	New: func() interface{} {
+		return &MCRequest{}
+	},
+}
+
+// newReq get a request from pool.
+func newReq() *MCRequest {
+	return reqPool.Get().(*MCRequest)
+}
+
+// CmdString get cmd.
+func (r *MCRequest) CmdString() string {
+	return r.rTp.String()
+}
+
+// Cmd get Msg cmd.
+func (r *MCRequest) Cmd() []byte {
+	return r.rTp.Bytes()
+}
+
+// Key get Msg key.
+func (r *MCRequest) Key() []byte {
+	return r.key
+}
+
+// Slowlog impl the proto.Request interface, the data after key is collapsed.
+func (r *MCRequest) Slowlog() *proto.SlowlogEntry {
+	slog := proto.NewSlowlogEntry(types.CacheTypeMemcache)
+	slog.Cmd = []string{r.rTp.String(), string(r.key)}
+	if data := bytes.TrimSpace(r.data); len(data) > 0 {
+		slog.Cmd = append(slog.Cmd, string(proto.CollapseBody(data)))
+	}
+	return slog
+}
+
+// Put put req back to pool.
+func (r *MCRequest) Put() {
+	r.rTp = RequestTypeUnknown
+	r.key = r.key[:0]
+	r.data = r.data[:0]
+	r.resp = r.resp[:0]
+	r.noreply = false
+	reqPool.Put(r)
+}
+
+// Resp return the response bytes of the request.
+func (r *MCRequest) Resp() []byte {
+	return r.resp
+}
@@ -79,6 +79,8 @@ type Message struct {
 	st, wt, rt, et, spt, ept, sit, eit time.Time
 	addr                               string //""
 	err                                error
+
+	redirect *NodeConnPipe // 重定向的node，读完回复后由pipe推给它
 }
 
 // NewMessage will create new message object.
@@ -94,6 +96,7 @@ func (m *Message) Reset() {
 	m.reqNum = 0
 	m.st, m.wt, m.rt, m.et, m.spt, m.ept, m.sit, m.eit = defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime
 	m.err = nil
+	m.redirect = nil
 }
 
 // clear will clean the msg
@@ -265,6 +268,14 @@ func (m *Message) Batch() []*Message {
 	return m.subs[:slen]
 }
 
+// Subs returns the sub Msgs which are built by Batch.
+func (m *Message) Subs() []*Message {
+	if !m.IsBatch() || len(m.subs) < m.reqNum {
+		return nil
+	}
+	return m.subs[:m.reqNum]
+}
+
 // WithWaitGroup with wait group.
 func (m *Message) WithWaitGroup(wg *sync.WaitGroup) {
 	m.wg = wg
@@ -290,6 +301,16 @@ func (m *Message) WithError(err error) {
 	m.err = err
 }
 
+// Redirect push the message into the pipe after the reply is read, the message is forwarded again by the pipe.
+func (m *Message) Redirect(ncp *NodeConnPipe) {
+	m.redirect = ncp
+}
+
+// OwnErr returns the error of message itself, the errors of sub messages are not included.
+func (m *Message) OwnErr() error {
+	return m.err
+}
+
 // Err returns error.
 func (m *Message) Err() error {
 	if m.err != nil {
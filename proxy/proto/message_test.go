@@ -75,3 +75,26 @@ func TestMessage(t *testing.T) {
 	err = emsg.Err()
 	assert.EqualError(t, err, "some error")
 }
+
+type _slowlogRequest struct {
+	mockRequest
+}
+
+func (*_slowlogRequest) Slowlog() *SlowlogEntry { return &SlowlogEntry{Cmd: []string{"GET", "a"}} }
+
+func TestMessageSlowlogNil(t *testing.T) {
+	msg := NewMessage()
+	assert.Nil(t, msg.Slowlog())
+	msg.WithRequest(&mockRequest{})
+	assert.Nil(t, msg.Slowlog())
+
+	// NOTE: the sub requests without slowlog are skipped
+	msg = NewMessage()
+	msg.WithRequest(&_slowlogRequest{})
+	msg.WithRequest(&mockRequest{})
+	msg.WithRequest(&_slowlogRequest{})
+	msg.Batch()
+	e := msg.Slowlog()
+	assert.NotNil(t, e)
+	assert.Len(t, e.Subs, 2)
+}
@@ -75,9 +75,10 @@ func (ncp *NodeConnPipe) Push(m *Message) {
 	}
 	ncp.l.RUnlock()
 	if input != nil {
+		// NOTE: mark before sending, the message belongs to the pipe after sent
+		m.MarkStartInput() //标记下该m输入input通道
 		select { //循环
 		case input <- m: //m输入到input chan里
-			m.MarkStartInput() //标记下该m已经输入input通道里
 			return
 		default:
 		}
@@ -86,6 +87,16 @@ func (ncp *NodeConnPipe) Push(m *Message) {
 	m.Done() //处理完成一个
 }
 
+// Pending returns the number of messages waiting in the input chans.
+func (ncp *NodeConnPipe) Pending() (n int) {
+	ncp.l.RLock()
+	for _, input := range ncp.inputs {
+		n += len(input)
+	}
+	ncp.l.RUnlock()
+	return
+}
+
 // ErrorEvent return error chan.
 func (ncp *NodeConnPipe) ErrorEvent() <-chan error {
 	return ncp.errCh
@@ -187,6 +198,13 @@ func (mp *msgPipe) pipe() {
 		for i := 0; i < mp.count; i++ {
 			msg := mp.batch[i]
 			msg.WithError(err) // NOTE: maybe err is nil
+			if ncp := msg.redirect; ncp != nil {
+				msg.redirect = nil
+				if err == nil {
+					// NOTE: the message belongs to the redirected pipe after pushed, only the wait group is touched by Done
+					ncp.Push(msg)
+				}
+			}
 			// if prom.On {
 			// 	cmd := msg.Request().CmdString()
 			// 	duration := msg.RemoteDur()
@@ -50,7 +50,7 @@ func (*mockRequest) Key() []byte {
 }
 func (*mockRequest) Put() {}
 
-// func (*mockRequest) Slowlog() *SlowlogEntry { return nil }
+func (*mockRequest) Slowlog() *SlowlogEntry { return nil }
 
 func TestPipe(t *testing.T) {
 	nc1 := &mockNodeConn{}
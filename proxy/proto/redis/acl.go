@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+
+	"mycache/proxy/proto"
+)
+
+var (
+	authNoPasswordBytes = []byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n")
+	noPermKeyBytes      = []byte("-NOPERM this user has no permissions to access one of the keys used as arguments\r\n")
+
+	// 不检查权限的连接命令
+	aclFreeCmds = map[string]struct{}{
+		"4\r\nPING":          {},
+		"4\r\nQUIT":          {},
+		"7\r\nCOMMAND":       {},
+		"5\r\nHELLO":         {},
+		"4\r\nAUTH":          {},
+		"6\r\nSELECT":        {},
+		"5\r\nMULTI":         {},
+		"4\r\nEXEC":          {},
+		"7\r\nDISCARD":       {},
+		"7\r\nUNWATCH":       {},
+		"11\r\nUNSUBSCRIBE":  {},
+		"12\r\nPUNSUBSCRIBE": {},
+	}
+	// 参数里没有key的命令，只检查命令的权限
+	aclKeylessCmds = map[string]struct{}{
+		"4\r\nSCAN":        {},
+		"4\r\nKEYS":        {},
+		"6\r\nSCRIPT":      {},
+		"7\r\nPUBLISH":     {},
+		"9\r\nSUBSCRIBE":   {},
+		"10\r\nPSUBSCRIBE": {},
+		"4\r\nINFO":        {},
+		"7\r\nSLOWLOG":     {},
+		"8\r\nREADONLY":    {},
+		"9\r\nREADWRITE":   {},
+	}
+	aclWriteCmdMap = map[string]struct{}{}
+	// 按子命令区分读写的命令，这些子命令需要写权限，其他子命令只需要读权限
+	aclWriteSubCmds = map[string]map[string]struct{}{
+		"7\r\nSLOWLOG": {"RESET": {}},
+		"6\r\nSCRIPT":  {"FLUSH": {}, "LOAD": {}, "KILL": {}},
+	}
+)
+
+func init() {
+	for _, cmd := range writeCmds {
+		aclWriteCmdMap[cmd] = struct{}{}
+	}
+	// NOTE: blocking commands pop the list
+	for cmd := range blockingCmds {
+		aclWriteCmdMap[cmd] = struct{}{}
+	}
+}
+
+// User is the ACL user of proxy, which is authenticated by AUTH username password.
+type User struct {
+	Name     string
+	Password string
+	Read     bool     // 允许读命令
+	Write    bool     // 允许写命令
+	Keys     []string // 允许访问的key的glob模式，为空不限制
+}
+
+// SetUsers set the ACL users of proxy.
+// NOTE: the default user is disabled if no password is set, so clients must AUTH as one of the users.
+func (pc *proxyConn) SetUsers(users map[string]*User) {
+	pc.users = users
+	if len(users) > 0 && pc.password == "" {
+		pc.authorized = false
+	}
+}
+
+// lookupUser check the password of user, the user is nil for the default user.
+func (pc *proxyConn) lookupUser(name, password []byte) (u *User, ok bool) {
+	if bytes.Equal(name, defaultUserBytes) {
+		return nil, pc.password != "" && string(password) == pc.password
+	}
+	if u, ok = pc.users[string(name)]; !ok || u.Password != string(password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// authUser handle AUTH [username] password.
+func (pc *proxyConn) authUser(req *Request) (err error) {
+	switch req.resp.arraySize {
+	case 2:
+		// NOTE: AUTH password authenticates the default user
+		if pc.password == "" && len(pc.users) == 0 {
+			return pc.bw.Write(authNoPasswordBytes)
+		}
+		if pc.password != "" && string(bulkPayload(req.resp.array[1].data)) == pc.password {
+			pc.authorized, pc.user = true, nil
+			return pc.bw.Write(justOkBytes)
+		}
+		pc.authorized = false
+		return pc.bw.Write(invalidPasswordBytes)
+	case 3:
+		u, ok := pc.lookupUser(bulkPayload(req.resp.array[1].data), bulkPayload(req.resp.array[2].data))
+		if !ok {
+			return pc.bw.Write(wrongPassBytes)
+		}
+		pc.authorized, pc.user = true, u
+		return pc.bw.Write(justOkBytes)
+	}
+	return pc.bw.Write([]byte("-ERR wrong number of arguments for 'auth' command\r\n"))
+}
+
+// checkPerm check all requests of message by the user, the NOPERM error is kept on the first request.
+// NOTE: the denied message is handled alone like special commands, see Decode and CmdCheck.
+func (pc *proxyConn) checkPerm(m *proto.Message) (denied bool) {
+	if pc.user == nil {
+		return
+	}
+	for _, r := range m.Requests() {
+		req, ok := r.(*Request)
+		if !ok {
+			continue
+		}
+		if noPerm := pc.user.check(req); noPerm != nil {
+			m.Request().(*Request).noPerm = noPerm
+			return true
+		}
+	}
+	return
+}
+
+// check returns the NOPERM error if the user can not run the request.
+func (u *User) check(req *Request) []byte {
+	if !req.IsSupport() {
+		return nil
+	}
+	cmd := string(req.resp.array[0].data)
+	if _, ok := aclFreeCmds[cmd]; ok {
+		return nil
+	}
+	if write := isACLWrite(req); (write && !u.Write) || (!write && !u.Read) {
+		return []byte(fmt.Sprintf("-NOPERM this user has no permissions to run the '%s' command\r\n", bytes.ToLower(req.Cmd())))
+	}
+	if _, ok := aclKeylessCmds[cmd]; ok || len(u.Keys) == 0 {
+		return nil
+	}
+	for _, key := range aclKeys(req) {
+		if !u.matchKey(key) {
+			return noPermKeyBytes
+		}
+	}
+	return nil
+}
+
+// isACLWrite check if the request needs the write permission, SLOWLOG and SCRIPT are classified by the subcommand.
+func isACLWrite(req *Request) bool {
+	cmd := string(req.resp.array[0].data)
+	if subs, ok := aclWriteSubCmds[cmd]; ok {
+		if req.resp.arraySize < 2 {
+			return false
+		}
+		_, ok = subs[string(bytes.ToUpper(bulkPayload(req.resp.array[1].data)))]
+		return ok
+	}
+	_, ok := aclWriteCmdMap[cmd]
+	return ok
+}
+
+func (u *User) matchKey(key []byte) bool {
+	for _, pattern := range u.Keys {
+		if globMatch([]byte(pattern), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclKeys returns all keys in arguments of request.
+func aclKeys(req *Request) [][]byte {
+	if bytes.Equal(req.resp.array[0].data, cmdWatchBytes) {
+		keys := make([][]byte, 0, req.resp.arraySize-1)
+		for _, arg := range req.resp.array[1:req.resp.arraySize] {
+			keys = append(keys, bulkPayload(arg.data))
+		}
+		return keys
+	}
+	if _, ok := multiKeyCmds[string(req.resp.array[0].data)]; ok {
+		return respKeys(req.resp)
+	}
+	if req.resp.arraySize > 1 {
+		return [][]byte{req.Key()}
+	}
+	return nil
+}
+
+// globMatch match the key by the glob pattern like redis, supports * ? [abc] [^a-z] and \ escape.
+func globMatch(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := bytes.IndexByte(pattern[1:], ']')
+			if end == -1 {
+				// NOTE: no closing bracket, match '[' literally
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			not := len(class) > 0 && class[0] == '^'
+			if not {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == not {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
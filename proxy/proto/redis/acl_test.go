@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	ts := []struct {
+		Pattern string
+		Key     string
+		Match   bool
+	}{
+		{Pattern: "*", Key: "", Match: true},
+		{Pattern: "app:*", Key: "app:1", Match: true},
+		{Pattern: "app:*", Key: "ap:1", Match: false},
+		{Pattern: "a?c", Key: "abc", Match: true},
+		{Pattern: "a?c", Key: "ac", Match: false},
+		{Pattern: "a*b*c", Key: "axxbyyc", Match: true},
+		{Pattern: "h[ae]llo", Key: "hello", Match: true},
+		{Pattern: "h[^e]llo", Key: "hello", Match: false},
+		{Pattern: "h[a-b]llo", Key: "hbllo", Match: true},
+		{Pattern: `a\*`, Key: "a*", Match: true},
+		{Pattern: `a\*`, Key: "ab", Match: false},
+		{Pattern: "a[b", Key: "a[b", Match: true},
+	}
+	for _, tt := range ts {
+		assert.Equal(t, tt.Match, globMatch([]byte(tt.Pattern), []byte(tt.Key)), "%s %s", tt.Pattern, tt.Key)
+	}
+}
+
+func TestACLUserPerm(t *testing.T) {
+	data := "GET app:1\r\nAUTH app bad\r\nAUTH app pw\r\nGET app:1\r\nSET app:1 v\r\nMGET app:1 other\r\nGET app:2\r\nAUTH default x\r\n"
+	mc := mockconn.CreateMockConn([]byte(data), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetUsers(map[string]*User{"app": {Name: "app", Password: "pw", Read: true, Keys: []string{"app:*"}}})
+	assert.False(t, pc.IsAuthorized())
+
+	ts := []struct {
+		Count   int
+		Special bool
+	}{
+		{Count: 1}, // NOTE: NOAUTH
+		{Count: 1, Special: true},
+		{Count: 1, Special: true},
+		{Count: 1},
+		{Count: 1, Special: true}, // NOTE: write is denied
+		{Count: 1, Special: true}, // NOTE: key other is denied
+		{Count: 1},
+		{Count: 1, Special: true}, // NOTE: default user is disabled
+	}
+	for i, tt := range ts {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, tt.Count, "%d", i)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.Equal(t, tt.Special, special, "%d", i)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(noAuthBytes)+string(wrongPassBytes)+"+OK\r\n"+
+		"-NOPERM this user has no permissions to run the 'set' command\r\n"+
+		string(noPermKeyBytes)+string(wrongPassBytes), mc.Wbuf.String())
+	assert.True(t, pc.IsAuthorized())
+}
+
+func TestACLAbortTx(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("AUTH app pw\r\nMULTI\r\nSET a 1\r\nEXEC\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "pass").(*proxyConn)
+	pc.SetUsers(map[string]*User{"app": {Name: "app", Password: "pw", Read: true}})
+	for i := 0; i < 4; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		_, err = pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n+OK\r\n-NOPERM this user has no permissions to run the 'set' command\r\n"+string(execAbortBytes), mc.Wbuf.String())
+}
+
+func TestACLUserSubCmdPerm(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("SLOWLOG GET\r\nSLOWLOG reset\r\nSCRIPT EXISTS a\r\nSCRIPT flush\r\nSCRIPT LOAD x\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	reader := &User{Name: "r", Read: true}
+	writer := &User{Name: "w", Write: true}
+	for i, write := range []bool{false, true, false, true, true} {
+		msgs, err := pc.Decode(proto.GetMsgs(1))
+		assert.NoError(t, err)
+		req := msgs[0].Request().(*Request)
+		assert.Equal(t, write, reader.check(req) != nil, "%d", i)
+		assert.Equal(t, !write, writer.check(req) != nil, "%d", i)
+	}
+}
@@ -0,0 +1,120 @@
+package redis
+
+import (
+	errs "errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"mycache/pkg/bufio"
+	"mycache/pkg/log"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+// errors
+var (
+	ErrAuthFailed   = errs.New("redis node auth failed")
+	ErrSelectFailed = errs.New("redis node select db failed")
+)
+
+// authFailedNodes 握手失败的node，每个node只记录一次日志，直到握手成功
+var authFailedNodes sync.Map
+
+// Auth is the TLS of dialing and the AUTH and SELECT sent on every new conn to backend.
+type Auth struct {
+	User     string // ACL用户名，为空时只用密码认证
+	Password string
+	DB       int
+	TLS      *libnet.TLS // 连接后端的TLS，nil不加密
+}
+
+// Dial dial the backend with TLS if set.
+func (a *Auth) Dial(addr string, dialTimeout, readTimeout, writeTimeout time.Duration) *libnet.Conn {
+	if a == nil {
+		return libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	}
+	return libnet.DialTLSWithTimeout(addr, a.TLS, dialTimeout, readTimeout, writeTimeout)
+}
+
+// Handshake send AUTH and SELECT db on the new conn, nothing is sent if auth is nil.
+func (a *Auth) Handshake(addr string, br *bufio.Reader, bw *bufio.Writer) (err error) {
+	if a == nil || (a.Password == "" && a.DB == 0) {
+		return
+	}
+	if a.Password != "" {
+		args := []string{"AUTH", a.Password}
+		if a.User != "" {
+			args = []string{"AUTH", a.User, a.Password}
+		}
+		if err = handshake(br, bw, ErrAuthFailed, args...); err != nil {
+			logHandshakeFailed(addr, err)
+			return
+		}
+	}
+	if a.DB != 0 {
+		if err = handshake(br, bw, ErrSelectFailed, "SELECT", strconv.Itoa(a.DB)); err != nil {
+			logHandshakeFailed(addr, err)
+			return
+		}
+	}
+	authFailedNodes.Delete(addr)
+	return
+}
+
+// db returns the db selected by handshake.
+func (a *Auth) db() int {
+	if a == nil {
+		return 0
+	}
+	return a.DB
+}
+
+func handshake(br *bufio.Reader, bw *bufio.Writer, failed error, args ...string) (err error) {
+	if err = writeCommand(bw, args...); err != nil {
+		return
+	}
+	reply := &resp{}
+	if err = readReply(br, reply); err != nil {
+		return
+	}
+	if reply.respType == respError {
+		return errors.Wrapf(failed, "reply:%s", reply.data)
+	}
+	return
+}
+
+func logHandshakeFailed(addr string, err error) {
+	if cause := errors.Cause(err); cause != ErrAuthFailed && cause != ErrSelectFailed {
+		return
+	}
+	if _, loaded := authFailedNodes.LoadOrStore(addr, struct{}{}); !loaded {
+		log.Errorf("redis node:%s handshake fail with error:%v", addr, err)
+	}
+}
+
+// writeCommand write the command as array of bulk strings and flush.
+func writeCommand(bw *bufio.Writer, args ...string) error {
+	_ = bw.Write([]byte(fmt.Sprintf("*%d\r\n", len(args))))
+	for _, arg := range args {
+		_ = bw.Write([]byte(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)))
+	}
+	return errors.WithStack(bw.Flush())
+}
+
+// readReply read one complete reply from br.
+func readReply(br *bufio.Reader, reply *resp) (err error) {
+	for {
+		if err = reply.decode(br); err == bufio.ErrBufferFull {
+			if err = br.Read(); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		return
+	}
+}
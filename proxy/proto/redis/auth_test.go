@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"mycache/pkg/bufio"
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthHandshakeNothing(t *testing.T) {
+	mc := mockconn.CreateMockConn(nil, 1).(*mockconn.MockConn)
+	conn := libnet.NewConn(mc, time.Second, time.Second)
+	br, bw := bufio.NewReader(conn, bufio.NewBuffer(64)), bufio.NewWriter(conn)
+	var auth *Auth
+	assert.NoError(t, auth.Handshake("127.0.0.1:6379", br, bw))
+	assert.NoError(t, (&Auth{}).Handshake("127.0.0.1:6379", br, bw))
+	assert.Equal(t, 0, mc.Wbuf.Len())
+}
+
+func TestAuthNodeConn(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "+OK\r\n", "+OK\r\n", "$1\r\nv\r\n")
+	nc := NewAuthNodeConn("test", addr, time.Second, time.Second, time.Second, &Auth{User: "u", Password: "p", DB: 3})
+	defer nc.Close()
+	assert.Equal(t, "AUTH u p", <-cmds)
+	assert.Equal(t, "SELECT 3", <-cmds)
+
+	req := newRequest("GET", "a")
+	req.resp.respType = respArray
+	req.db = 3 // NOTE: marked by proxy conn
+	msg := proto.NewMessage()
+	msg.WithRequest(req)
+	assert.NoError(t, nc.Write(msg))
+	assert.NoError(t, nc.Flush())
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, "GET a", <-cmds)
+	assert.Equal(t, "1\r\nv", string(req.reply.data))
+}
+
+func TestAuthNodeConnFailed(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "-WRONGPASS invalid username-password pair\r\n")
+	nc := NewAuthNodeConn("test", addr, time.Second, time.Second, time.Second, &Auth{Password: "bad"})
+	defer nc.Close()
+	assert.Equal(t, "AUTH bad", <-cmds)
+	_, ok := authFailedNodes.Load(addr)
+	assert.True(t, ok)
+
+	msg := proto.NewMessage()
+	msg.WithRequest(newRequest("GET", "a"))
+	err := nc.Write(msg)
+	assert.Equal(t, ErrAuthFailed, errors.Cause(err))
+	err = nc.Read(msg)
+	assert.Equal(t, ErrAuthFailed, errors.Cause(err))
+}
+
+func TestAuthSelectFailed(t *testing.T) {
+	addr, _ := _fakePubSubNode(t, "+OK\r\n", "-ERR DB index is out of range\r\n")
+	_, err := dialDedicated(addr, time.Second, time.Second, time.Second, &Auth{Password: "p", DB: 100})
+	assert.Equal(t, ErrSelectFailed, errors.Cause(err))
+}
+
+func TestAuthPinger(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "+OK\r\n", "+PONG\r\n", "+PONG\r\n")
+	p := NewAuthPinger(libnet.DialWithTimeout(addr, time.Second, time.Second, time.Second), &Auth{Password: "p"})
+	defer p.Close()
+	assert.NoError(t, p.Ping())
+	assert.NoError(t, p.Ping())
+	assert.Equal(t, "AUTH p", <-cmds)
+	assert.Equal(t, "PING", <-cmds)
+	assert.Equal(t, "PING", <-cmds)
+}
+
+func TestAuthPingerFailedAddr(t *testing.T) {
+	addr, _ := _fakePubSubNode(t, "-WRONGPASS invalid username-password pair\r\n")
+	_, port, _ := net.SplitHostPort(addr)
+	addr = "localhost:" + port
+	defer authFailedNodes.Delete(addr)
+	p := NewAuthPinger(libnet.DialWithTimeout(addr, time.Second, time.Second, time.Second), &Auth{Password: "p"})
+	defer p.Close()
+	assert.Equal(t, ErrAuthFailed, errors.Cause(p.Ping()))
+	_, ok := authFailedNodes.Load(addr)
+	assert.True(t, ok)
+}
@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// 阻塞命令的参数个数，负数表示至少
+	blockingCmds = map[string]int{
+		"5\r\nBLPOP":       -3,
+		"5\r\nBRPOP":       -3,
+		"10\r\nBRPOPLPUSH": 4,
+		"6\r\nBLMOVE":      6,
+	}
+
+	blockingNegativeBytes = []byte("-ERR timeout is negative\r\n")
+	blockingBadTimeout    = []byte("-ERR timeout is not a float or out of range\r\n")
+)
+
+// isBlocking check if the request is a blocking list command.
+func isBlocking(req *Request) bool {
+	_, ok := blockingCmds[string(req.resp.array[0].data)]
+	return ok
+}
+
+// SetBlocking set the dedicated conn pool for blocking commands.
+func (pc *proxyConn) SetBlocking(pool *DedicatedPool) {
+	pc.blocking = pool
+}
+
+// blockingCheck send the blocking command on a dedicated conn borrowed from pool, so that msgPipe is not stalled.
+// NOTE: the client waits for the reply just like connecting to redis directly.
+func (pc *proxyConn) blockingCheck(req *Request) (err error) {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	cmd := bytes.ToLower(req.Cmd())
+	arity := blockingCmds[string(req.resp.array[0].data)]
+	if (arity > 0 && req.resp.arraySize != arity) || (arity < 0 && req.resp.arraySize < -arity) {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", cmd)))
+	}
+	timeout, err := strconv.ParseFloat(string(bulkPayload(req.resp.array[req.resp.arraySize-1].data)), 64)
+	if err != nil {
+		return pc.bw.Write(blockingBadTimeout)
+	}
+	if timeout < 0 {
+		return pc.bw.Write(blockingNegativeBytes)
+	}
+	if pc.blocking == nil || pc.nodeLocate == nil {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR command '%s' is not supported by proxy\r\n", cmd)))
+	}
+	var addr string
+	for _, key := range respKeys(req.resp) {
+		node, ok := pc.nodeLocate(key)
+		if !ok {
+			pc.writeErr(ErrKeyNoNode)
+			return nil
+		}
+		if addr == "" {
+			addr = node
+		} else if node != addr {
+			return pc.bw.Write(crossNodeBytes)
+		}
+	}
+	dc, derr := pc.blocking.get(addr)
+	if derr != nil {
+		pc.writeErr(derr)
+		return
+	}
+	// NOTE: wait for the timeout of command besides the read timeout, 0 blocks forever
+	if timeout > 0 {
+		dc.conn.SetReadTimeout(time.Duration(timeout*float64(time.Second)) + pc.blocking.rto)
+	} else {
+		dc.conn.SetReadTimeout(0)
+	}
+	// NOTE: the client is watched while blocking, the dedicated conn is closed as soon as the client goes away
+	var gone int32
+	stop := pc.conn.WatchClose(func() {
+		atomic.StoreInt32(&gone, 1)
+		_ = dc.conn.Conn.Close() // NOTE: the closed flag of libnet.Conn is not goroutine safe
+	})
+	reply := &resp{}
+	derr = dc.do(pc.db, []*resp{req.resp}, reply)
+	stop()
+	if derr != nil || atomic.LoadInt32(&gone) == 1 {
+		pc.blocking.put(dc, true)
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR %s failed on node %s\r\n", cmd, addr)))
+	}
+	// NOTE: the reply refers to the buffer of conn, copy it before the conn is reused by others
+	out := &resp{}
+	out.copy(reply)
+	pc.blocking.put(dc, false)
+	return pc.encodeReply(out, shapeNone)
+}
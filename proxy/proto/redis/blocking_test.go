@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockingCheck(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "*2\r\n$1\r\nq\r\n$1\r\nx\r\n", "$-1\r\n")
+	mc := mockconn.CreateMockConn([]byte("BLPOP q 0.5\r\nBRPOPLPUSH q r 0\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pool := NewDedicatedPool(time.Second, time.Second, time.Second)
+	defer pool.Close()
+	pc.SetTx(func(key []byte) (string, bool) { return addr, true }, time.Second, time.Second, time.Second)
+	pc.SetBlocking(pool)
+
+	for i := 0; i < 2; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 1)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*2\r\n$1\r\nq\r\n$1\r\nx\r\n$-1\r\n", mc.Wbuf.String())
+	assert.Equal(t, "BLPOP q 0.5", <-cmds)
+	assert.Equal(t, "BRPOPLPUSH q r 0", <-cmds)
+	// NOTE: the conn is reused
+	assert.Len(t, pool.idle[addr], 1)
+	assert.Len(t, pool.active, 0)
+}
+
+func TestBlockingCheckBadArgs(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("BLPOP q\r\nBLPOP q x\r\nBRPOP q -1\r\nBLMOVE a b LEFT RIGHT 0\r\nBLPOP q 0\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pool := NewDedicatedPool(time.Second, time.Second, time.Second)
+	pool.SetLimit(1, 1)
+	pool.actives["127.0.0.1:1"] = 1 // NOTE: the node is full
+	pc.SetTx(func(key []byte) (string, bool) {
+		if string(key) == "b" {
+			return "127.0.0.1:2", true
+		}
+		return "127.0.0.1:1", true
+	}, time.Second, time.Second, time.Second)
+	pc.SetBlocking(pool)
+	for i := 0; i < 5; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-ERR wrong number of arguments for 'blpop' command\r\n"+
+		string(blockingBadTimeout)+
+		string(blockingNegativeBytes)+
+		string(crossNodeBytes)+
+		"-"+ErrDedicatedPoolFull.Error()+"\r\n", mc.Wbuf.String())
+	assert.NoError(t, pool.Close())
+	_, err := pool.get("127.0.0.1:1")
+	assert.Equal(t, ErrDedicatedPoolClosed, err)
+}
+
+func TestBlockingCheckReuseAfterReadTimeout(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "$-1\r\n", "$1\r\nx\r\n")
+	mc := mockconn.CreateMockConn([]byte("BLPOP q 0.01\r\nBRPOPLPUSH q r 0\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pool := NewDedicatedPool(time.Second, 50*time.Millisecond, time.Second)
+	defer pool.Close()
+	pc.SetTx(func(key []byte) (string, bool) { return addr, true }, time.Second, time.Second, time.Second)
+	pc.SetBlocking(pool)
+
+	for i := 0; i < 2; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+		// NOTE: the deadline of the last read on the pooled conn is passed
+		time.Sleep(200 * time.Millisecond)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "$-1\r\n$1\r\nx\r\n", mc.Wbuf.String())
+	assert.Equal(t, "BLPOP q 0.01", <-cmds)
+	assert.Equal(t, "BRPOPLPUSH q r 0", <-cmds)
+	assert.Len(t, pool.idle[addr], 1)
+}
+
+func TestBlockingCheckClientGone(t *testing.T) {
+	// NOTE: the node never replies, the conn of node is closed as soon as the client goes away
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer nl.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := nl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	sock, err := l.Accept()
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("BLPOP q 0\r\n"))
+	assert.NoError(t, err)
+
+	pc := NewProxyConn(libnet.NewConn(sock, time.Second, time.Second), "").(*proxyConn)
+	defer pc.conn.Close()
+	pool := NewDedicatedPool(time.Second, time.Second, time.Second)
+	defer pool.Close()
+	pc.SetTx(func(key []byte) (string, bool) { return nl.Addr().String(), true }, time.Second, time.Second, time.Second)
+	pc.SetBlocking(pool)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, client.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("conn of node is not closed")
+	}
+	<-done
+	assert.Len(t, pool.active, 0)
+	assert.Len(t, pool.idle, 0)
+}
@@ -0,0 +1,382 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	errs "errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/hashkit"
+	"mycache/pkg/log"
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	forwarderStateOpening = int32(0)
+	forwarderStateClosed  = int32(1)
+)
+
+// errors
+var (
+	ErrClusterClosed     = errs.New("redis cluster forwarder already closed")
+	ErrClusterNoSeed     = errs.New("redis cluster all seed nodes fail to fetch")
+	ErrClusterSlotNoNode = errs.New("redis cluster slot no hit node")
+)
+
+// topology 当前生效的集群拓扑和每个主节点的连接管道
+type topology struct {
+	ns    *nodeSlots
+	pipes map[string]*proto.NodeConnPipe
+}
+
+// cluster is the redis cluster forwarder which routes the key by slot.
+type cluster struct {
+	name    string
+	listen  string
+	conns   int32
+	hashTag []byte
+
+	dto, rto, wto time.Duration
+	auth          *redis.Auth // 后端连接的TLS，建立连接时发送AUTH和SELECT
+
+	blocking *redis.DedicatedPool // 阻塞命令独占的后端连接池
+
+	lock   sync.Mutex // 保护seeds和拓扑的刷新
+	seeds  []string
+	topo   atomic.Value // *topology
+	action chan struct{}
+
+	rlock     sync.Mutex                     // 保护redirects
+	redirects map[string]*proto.NodeConnPipe // 重定向到拓扑以外的node的连接管道，按需创建
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	state  int32
+}
+
+// NewForwarder new redis cluster forwarder, it fetches the cluster topology from seed servers.
+func NewForwarder(name, listen string, servers []string, conns int32, dto, rto, wto time.Duration, hashTag []byte, auth *redis.Auth) proto.Forwarder {
+	c := &cluster{
+		name:    name,
+		listen:  listen,
+		conns:   conns,
+		hashTag: hashTag,
+		dto:     dto,
+		rto:     rto,
+		wto:     wto,
+		auth:    auth,
+		seeds:   servers,
+		action:  make(chan struct{}, 1),
+
+		redirects: make(map[string]*proto.NodeConnPipe),
+
+		blocking: redis.NewDedicatedPool(dto, rto, wto),
+	}
+	c.blocking.SetAuth(auth)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if err := c.fetch(); err != nil {
+		panic(err)
+	}
+	go c.fetchproc()
+	return c
+}
+
+// Forward impl proto.Forwarder, route every message by the crc16 slot of key.
+func (c *cluster) Forward(msgs []*proto.Message) error {
+	if closed := atomic.LoadInt32(&c.state); closed == forwarderStateClosed {
+		return ErrClusterClosed
+	}
+	var err error
+	for _, m := range msgs {
+		if m.IsBatch() {
+			for _, subm := range m.Batch() {
+				// NOTE: only fail the sub message, others are still sent for partial failure
+				if ferr := c.forward(subm); ferr != nil {
+					subm.WithError(ferr)
+					err = ferr
+				}
+			}
+		} else if proto.IsBroadcast(m) {
+			c.broadcast(m)
+		} else if c.scan(m) {
+			continue
+		} else if !c.sameNode(m.Request()) {
+			m.WithError(proto.ErrCrossNode)
+		} else if ferr := c.forward(m); ferr != nil {
+			m.WithError(ferr)
+			return ferr
+		}
+	}
+	return err
+}
+
+func (c *cluster) forward(m *proto.Message) error {
+	topo := c.topo.Load().(*topology)
+	addr := topo.ns.slots[c.slot(m.Request().Key())]
+	ncp, ok := topo.pipes[addr]
+	if !ok {
+		c.trigger()
+		return errors.WithStack(ErrClusterSlotNoNode)
+	}
+	m.MarkAddr(addr)
+	m.MarkStartPipe()
+	ncp.Push(m)
+	return nil
+}
+
+// PubSubAddr impl proto.PubSubForwarder, the channel is located by slot just like key.
+func (c *cluster) PubSubAddr(channel []byte) (addr string, ok bool) {
+	return c.KeyAddr(channel)
+}
+
+// PubSubShared impl proto.PubSubForwarder, the messages are broadcast to all nodes by redis cluster.
+func (c *cluster) PubSubShared() bool {
+	return true
+}
+
+// KeyAddr impl proto.KeyLocator.
+func (c *cluster) KeyAddr(key []byte) (addr string, ok bool) {
+	topo := c.topo.Load().(*topology)
+	addr = topo.ns.slots[c.slot(key)]
+	_, ok = topo.pipes[addr]
+	return
+}
+
+// BlockingPool returns the dedicated conn pool for blocking commands.
+func (c *cluster) BlockingPool() *redis.DedicatedPool {
+	return c.blocking
+}
+
+// NodeInfos impl proto.NodeInfoForwarder, returns the masters of current topology.
+func (c *cluster) NodeInfos() []*proto.NodeInfo {
+	topo, ok := c.topo.Load().(*topology)
+	if !ok {
+		return nil
+	}
+	infos := make([]*proto.NodeInfo, 0, len(topo.ns.masters))
+	for _, addr := range topo.ns.masters {
+		info := &proto.NodeInfo{Addr: addr, Alias: addr}
+		if ncp, ok := topo.pipes[addr]; ok {
+			info.Pending = ncp.Pending()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// sameNode check if all keys of the multi-key request are served by the same master.
+func (c *cluster) sameNode(req proto.Request) bool {
+	mk, ok := req.(proto.MultiKeyRequest)
+	if !ok {
+		return true
+	}
+	keys := mk.Keys()
+	if len(keys) < 2 {
+		return true
+	}
+	topo := c.topo.Load().(*topology)
+	first := topo.ns.slots[c.slot(keys[0])]
+	for _, key := range keys[1:] {
+		if topo.ns.slots[c.slot(key)] != first {
+			return false
+		}
+	}
+	return true
+}
+
+// broadcast send the message to every master node.
+func (c *cluster) broadcast(m *proto.Message) {
+	topo := c.topo.Load().(*topology)
+	for i, subm := range proto.Broadcast(m, len(topo.ns.masters)) {
+		addr := topo.ns.masters[i]
+		subm.MarkAddr(addr)
+		subm.MarkStartPipe()
+		topo.pipes[addr].Push(subm)
+	}
+}
+
+// scan send the cursor message to the master of index, masters are sorted so the order is stable.
+func (c *cluster) scan(m *proto.Message) (isCursor bool) {
+	topo := c.topo.Load().(*topology)
+	idx, isCursor, err := proto.CursorNode(m, len(topo.ns.masters))
+	if !isCursor {
+		return
+	}
+	if err != nil {
+		m.WithError(err)
+		return
+	}
+	addr := topo.ns.masters[idx]
+	m.MarkAddr(addr)
+	m.MarkStartPipe()
+	topo.pipes[addr].Push(m)
+	return
+}
+
+// slot returns the slot of key, only hash the hash tag part if exists.
+func (c *cluster) slot(key []byte) int {
+	if len(c.hashTag) == 2 {
+		if bidx := bytes.IndexByte(key, c.hashTag[0]); bidx != -1 {
+			if eidx := bytes.IndexByte(key[bidx+1:], c.hashTag[1]); eidx > 0 {
+				key = key[bidx+1 : bidx+1+eidx]
+			}
+		}
+	}
+	return int(hashkit.Crc16(key)) & slotsMask
+}
+
+// Update impl proto.Forwarder, re-seed by new servers and refresh the topology.
+func (c *cluster) Update(servers []string) error {
+	if closed := atomic.LoadInt32(&c.state); closed == forwarderStateClosed {
+		return ErrClusterClosed
+	}
+	c.lock.Lock()
+	c.seeds = servers
+	c.lock.Unlock()
+	return c.fetch()
+}
+
+// Close impl proto.Forwarder.
+func (c *cluster) Close() error {
+	if atomic.CompareAndSwapInt32(&c.state, forwarderStateOpening, forwarderStateClosed) {
+		c.cancel()
+		c.lock.Lock()
+		if topo, ok := c.topo.Load().(*topology); ok {
+			for _, ncp := range topo.pipes {
+				go ncp.Close()
+			}
+		}
+		c.lock.Unlock()
+		c.rlock.Lock()
+		for _, ncp := range c.redirects {
+			go ncp.Close()
+		}
+		c.rlock.Unlock()
+		_ = c.blocking.Close()
+	}
+	return nil
+}
+
+// redirectPipe returns the pipe of the node which the request is redirected to, the pipe of master in topology is used
+// and the others are created on demand, so that the redirects share the conns of node.
+func (c *cluster) redirectPipe(addr string) (*proto.NodeConnPipe, error) {
+	if ncp, ok := c.topo.Load().(*topology).pipes[addr]; ok {
+		return ncp, nil
+	}
+	c.rlock.Lock()
+	defer c.rlock.Unlock()
+	if atomic.LoadInt32(&c.state) == forwarderStateClosed {
+		return nil, ErrClusterClosed
+	}
+	ncp, ok := c.redirects[addr]
+	if !ok {
+		ncp = proto.NewNodeConnPipe(c.conns, func() proto.NodeConn {
+			return newNodeConn(c, addr)
+		})
+		c.redirects[addr] = ncp
+	}
+	return ncp, nil
+}
+
+// trigger a async topology refresh.
+func (c *cluster) trigger() {
+	select {
+	case c.action <- struct{}{}:
+	default:
+	}
+}
+
+func (c *cluster) fetchproc() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.action:
+		}
+		if err := c.fetch(); err != nil {
+			log.Errorf("redis cluster:%s fail to refresh topology with error:%v", c.name, err)
+		}
+	}
+}
+
+// fetch the topology from seeds and known nodes one by one until succeed.
+func (c *cluster) fetch() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if atomic.LoadInt32(&c.state) == forwarderStateClosed {
+		return ErrClusterClosed
+	}
+	addrs := make([]string, 0, len(c.seeds))
+	addrs = append(addrs, c.seeds...)
+	old, _ := c.topo.Load().(*topology)
+	if old != nil {
+		addrs = append(addrs, old.ns.masters...)
+	}
+	for _, addr := range addrs {
+		f := newFetcher(c.auth.Dial(addr, c.dto, c.rto, c.wto))
+		err := c.auth.Handshake(addr, f.br, f.bw)
+		var ns *nodeSlots
+		if err == nil {
+			ns, err = f.fetch()
+		}
+		_ = f.Close()
+		if err != nil {
+			log.Warnf("redis cluster:%s fail to fetch topology from node:%s with error:%v", c.name, addr, err)
+			continue
+		}
+		c.apply(old, ns)
+		return nil
+	}
+	return errors.Wrapf(ErrClusterNoSeed, "cluster:%s", c.name)
+}
+
+// apply the new topology, the pipes of unchanged master are reused.
+func (c *cluster) apply(old *topology, ns *nodeSlots) {
+	topo := &topology{ns: ns, pipes: make(map[string]*proto.NodeConnPipe, len(ns.masters))}
+	for _, addr := range ns.masters {
+		if old != nil {
+			if ncp, ok := old.pipes[addr]; ok {
+				topo.pipes[addr] = ncp
+				continue
+			}
+		}
+		// NOTE: the pipe of redirected node is taken over by topology
+		c.rlock.Lock()
+		ncp, ok := c.redirects[addr]
+		delete(c.redirects, addr)
+		c.rlock.Unlock()
+		if ok {
+			topo.pipes[addr] = ncp
+			go c.watch(ncp)
+			continue
+		}
+		toAddr := addr // NOTE: avoid closure
+		ncp = proto.NewNodeConnPipe(c.conns, func() proto.NodeConn {
+			return newNodeConn(c, toAddr)
+		})
+		topo.pipes[addr] = ncp
+		go c.watch(ncp)
+	}
+	c.topo.Store(topo)
+	if old == nil {
+		return
+	}
+	for addr, ncp := range old.pipes {
+		if _, ok := topo.pipes[addr]; !ok {
+			log.Infof("redis cluster:%s connection to node:%s is not used anymore, just close it", c.name, addr)
+			go ncp.Close()
+		}
+	}
+}
+
+// watch the network error of node conn and refresh the topology.
+func (c *cluster) watch(ncp *proto.NodeConnPipe) {
+	for range ncp.ErrorEvent() {
+		c.trigger()
+	}
+}
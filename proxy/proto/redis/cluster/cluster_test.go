@@ -0,0 +1,371 @@
+package cluster
+
+import (
+	stdbufio "bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNode is a fake redis cluster node which replies by handle func.
+type fakeNode struct {
+	l      net.Listener
+	addr   string
+	handle atomic.Value // func(args []string) string
+	conns  int32
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNode{l: l, addr: l.Addr().String()}
+	go n.serve()
+	return n
+}
+
+func (n *fakeNode) setHandle(h func(args []string) string) {
+	n.handle.Store(h)
+}
+
+func (n *fakeNode) serve() {
+	for {
+		conn, err := n.l.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&n.conns, 1)
+		go func() {
+			defer conn.Close()
+			r := stdbufio.NewReader(conn)
+			for {
+				args, err := readArgs(r)
+				if err != nil {
+					return
+				}
+				h := n.handle.Load().(func(args []string) string)
+				if _, err = conn.Write([]byte(h(args))); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (n *fakeNode) Close() {
+	n.l.Close()
+}
+
+func readArgs(r *stdbufio.Reader) (args []string, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return
+	}
+	for i := 0; i < count; i++ {
+		if line, err = r.ReadString('\n'); err != nil {
+			return
+		}
+		var size int
+		if size, err = strconv.Atoi(strings.TrimSpace(line[1:])); err != nil {
+			return
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return
+		}
+		args = append(args, strings.ToUpper(string(buf[:size])))
+	}
+	return
+}
+
+func _bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func _nodes(lines ...string) string {
+	return _bulk(strings.Join(lines, "\n") + "\n")
+}
+
+func _forward(t *testing.T, fer proto.Forwarder, cmd string) *redis.Request {
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(1)
+	msgs[0].WithWaitGroup(wg)
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte(cmd), 1), time.Second, time.Second)
+	msgs, err := redis.NewProxyConn(conn, "").Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.NoError(t, fer.Forward(msgs))
+	wg.Wait()
+	assert.NoError(t, msgs[0].Err())
+	return msgs[0].Request().(*redis.Request)
+}
+
+const getFooCmd = "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n" // NOTE: slot of foo is 12182
+
+func TestClusterSlot(t *testing.T) {
+	c := &cluster{hashTag: []byte("{}")}
+	assert.Equal(t, 12182, c.slot([]byte("foo")))
+	assert.Equal(t, c.slot([]byte("user1000")), c.slot([]byte("{user1000}.following")))
+	assert.Equal(t, c.slot([]byte("{user1000}.followers")), c.slot([]byte("{user1000}.following")))
+	// NOTE: empty hash tag means hash the whole key
+	assert.NotEqual(t, c.slot([]byte("{}.following")), c.slot([]byte("{}.followers")))
+}
+
+func TestClusterForwardOk(t *testing.T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	nodes := _nodes(
+		"e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca "+n1.addr+"@1 myself,master - 0 0 1 connected 0-8191",
+		"67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 "+n2.addr+"@2 master - 0 0 2 connected 8192-16383",
+	)
+	n1.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return nodes
+		}
+		return _bulk("n1")
+	})
+	n2.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return nodes
+		}
+		return _bulk("n2")
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+
+	req := _forward(t, fer, getFooCmd)
+	assert.Equal(t, "2\r\nn2", string(req.Reply().Data()))
+	req = _forward(t, fer, "*2\r\n$3\r\nGET\r\n$3\r\nbar\r\n") // NOTE: slot of bar is 5061
+	assert.Equal(t, "2\r\nn1", string(req.Reply().Data()))
+}
+
+func TestClusterRedirect(t *testing.T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	var (
+		moved  = int32(1)
+		fetchs int32
+		asking int32
+	)
+	n1.setHandle(func(args []string) string {
+		switch args[0] {
+		case "CLUSTER":
+			atomic.AddInt32(&fetchs, 1)
+			return _nodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca " + n1.addr + "@1 myself,master - 0 0 1 connected 0-16383")
+		case "GET":
+			if atomic.LoadInt32(&moved) == 1 {
+				return "-MOVED 12182 " + n2.addr + "\r\n"
+			}
+			return "-ASK 12182 " + n2.addr + "\r\n"
+		}
+		return "-ERR unknown\r\n"
+	})
+	n2.setHandle(func(args []string) string {
+		switch args[0] {
+		case "ASKING":
+			atomic.AddInt32(&asking, 1)
+			return "+OK\r\n"
+		case "GET":
+			return _bulk("n2")
+		}
+		return "-ERR unknown\r\n"
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetchs))
+
+	req := _forward(t, fer, getFooCmd)
+	assert.Equal(t, "2\r\nn2", string(req.Reply().Data()))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&asking))
+	// NOTE: MOVED triggers refreshing the topology
+	for i := 0; i < 100 && atomic.LoadInt32(&fetchs) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetchs))
+
+	atomic.StoreInt32(&moved, 0)
+	req = _forward(t, fer, getFooCmd)
+	assert.Equal(t, "2\r\nn2", string(req.Reply().Data()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&asking))
+}
+
+func TestClusterTooManyRedirects(t *testing.T) {
+	n1 := newFakeNode(t)
+	defer n1.Close()
+	n1.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return _nodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca " + n1.addr + "@1 myself,master - 0 0 1 connected 0-16383")
+		}
+		return "-ASK 12182 " + n1.addr + "\r\n"
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+	req := _forward(t, fer, getFooCmd)
+	assert.Equal(t, byte(respError), req.Reply().Type())
+	assert.Equal(t, ErrRedirectTooMany.Error(), string(req.Reply().Data()))
+}
+
+func TestClusterRedirectPooled(t *testing.T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	n1.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return _nodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca " + n1.addr + "@1 myself,master - 0 0 1 connected 0-16383")
+		}
+		return "-ASK 12182 " + n2.addr + "\r\n"
+	})
+	n2.setHandle(func(args []string) string {
+		if args[0] == "ASKING" {
+			return "+OK\r\n"
+		}
+		return _bulk("n2")
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+	for i := 0; i < 5; i++ {
+		req := _forward(t, fer, getFooCmd)
+		assert.Equal(t, "2\r\nn2", string(req.Reply().Data()))
+	}
+	// NOTE: the redirects are sent on the pooled conns of node instead of dialing each time
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n2.conns))
+}
+
+func TestClusterRedirectTx(t *testing.T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	var (
+		moved = int32(1)
+		lock  sync.Mutex
+		cmds  []string
+	)
+	n1.setHandle(func(args []string) string {
+		switch args[0] {
+		case "CLUSTER":
+			return _nodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca " + n1.addr + "@1 myself,master - 0 0 1 connected 0-16383")
+		case "MULTI":
+			return "+OK\r\n"
+		case "SET":
+			if atomic.LoadInt32(&moved) == 1 {
+				return "-MOVED 12182 " + n2.addr + "\r\n"
+			}
+			return "-ASK 12182 " + n2.addr + "\r\n"
+		case "EXEC":
+			return "-EXECABORT Transaction discarded because of previous errors.\r\n"
+		}
+		return "-ERR unknown\r\n"
+	})
+	n2.setHandle(func(args []string) string {
+		lock.Lock()
+		cmds = append(cmds, args[0])
+		lock.Unlock()
+		switch args[0] {
+		case "MULTI":
+			return "+OK\r\n"
+		case "SET":
+			return "+QUEUED\r\n"
+		case "EXEC":
+			return "*1\r\n+OK\r\n"
+		}
+		return "-ERR unknown\r\n"
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+
+	exec := func() *redis.Request {
+		conn := libnet.NewConn(mockconn.CreateMockConn([]byte("MULTI\r\nSET foo 1\r\nEXEC\r\n"), 1), time.Second, time.Second)
+		pc := redis.NewProxyConn(conn, "")
+		var m *proto.Message
+		for i := 0; i < 3; i++ {
+			msgs, err := pc.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 1)
+			m = msgs[0]
+			_, err = pc.CmdCheck(m)
+			assert.NoError(t, err)
+		}
+		wg := &sync.WaitGroup{}
+		m.WithWaitGroup(wg)
+		assert.NoError(t, fer.Forward([]*proto.Message{m}))
+		wg.Wait()
+		assert.NoError(t, m.Err())
+		return m.Request().(*redis.Request)
+	}
+	// NOTE: the whole transaction is replayed on the node of MOVED
+	req := exec()
+	assert.Equal(t, byte('*'), req.Reply().Type())
+	lock.Lock()
+	assert.Equal(t, []string{"MULTI", "SET", "EXEC"}, cmds)
+	cmds = nil
+	lock.Unlock()
+
+	// NOTE: ASK in transaction is replied to client as is
+	atomic.StoreInt32(&moved, 0)
+	req = exec()
+	assert.Equal(t, byte(respError), req.Reply().Type())
+	assert.Equal(t, "ASK 12182 "+n2.addr, string(req.Reply().Data()))
+	lock.Lock()
+	assert.Empty(t, cmds)
+	lock.Unlock()
+}
+
+func TestClusterUpdate(t *testing.T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	n1.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return _nodes("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca " + n1.addr + "@1 myself,master - 0 0 1 connected 0-16383")
+		}
+		return _bulk("n1")
+	})
+	n2.setHandle(func(args []string) string {
+		if args[0] == "CLUSTER" {
+			return _nodes("67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 " + n2.addr + "@2 myself,master - 0 0 2 connected 0-16383")
+		}
+		return _bulk("n2")
+	})
+	fer := NewForwarder("test", "127.0.0.1:26379", []string{n1.addr}, 1, time.Second, time.Second, time.Second, []byte("{}"), nil)
+	defer fer.Close()
+	req := _forward(t, fer, getFooCmd)
+	assert.Equal(t, "2\r\nn1", string(req.Reply().Data()))
+
+	assert.NoError(t, fer.Update([]string{n2.addr}))
+	req = _forward(t, fer, getFooCmd)
+	assert.Equal(t, "2\r\nn2", string(req.Reply().Data()))
+
+	assert.NoError(t, fer.Close())
+	assert.Equal(t, ErrClusterClosed, fer.Update([]string{n1.addr}))
+	assert.Equal(t, ErrClusterClosed, fer.Forward(nil))
+}
+
+func TestNewForwarderNoSeed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	assert.Panics(t, func() {
+		NewForwarder("test", "127.0.0.1:26379", []string{addr}, 1, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, []byte("{}"), nil)
+	})
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"bytes"
+	errs "errors"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	respError = '-'
+	respBulk  = '$'
+
+	fetchBufferSize = 64 * 1024
+)
+
+var (
+	cmdClusterNodesBytes = []byte("*2\r\n$7\r\nCLUSTER\r\n$5\r\nNODES\r\n")
+	crlfBytes            = []byte("\r\n")
+)
+
+// errors
+var (
+	ErrFetcherBadReply = errs.New("fetcher got bad reply of CLUSTER NODES")
+)
+
+// fetcher 从种子节点拉取集群拓扑
+type fetcher struct {
+	conn *libnet.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+}
+
+func newFetcher(conn *libnet.Conn) *fetcher {
+	return &fetcher{
+		conn: conn,
+		br:   bufio.NewReader(conn, bufio.NewBuffer(fetchBufferSize)),
+		bw:   bufio.NewWriter(conn),
+	}
+}
+
+// fetch send CLUSTER NODES and parse the reply.
+func (f *fetcher) fetch() (ns *nodeSlots, err error) {
+	_ = f.bw.Write(cmdClusterNodesBytes)
+	if err = f.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	reply := &redis.RESP{}
+	if err = readReply(f.br, reply); err != nil {
+		return
+	}
+	switch reply.Type() {
+	case respBulk:
+	case respError:
+		err = errors.Wrapf(ErrFetcherBadReply, "error:%s", reply.Data())
+		return
+	default:
+		err = errors.WithStack(ErrFetcherBadReply)
+		return
+	}
+	data := reply.Data()
+	// NOTE: bulk data is like: <length>\r\n<payload>
+	if idx := bytes.Index(data, crlfBytes); idx != -1 {
+		data = data[idx+2:]
+	}
+	return parseSlots(data)
+}
+
+func (f *fetcher) Close() error {
+	return f.conn.Close()
+}
+
+// readReply read one complete reply from br.
+func readReply(br *bufio.Reader, reply *redis.RESP) (err error) {
+	for {
+		if err = reply.Decode(br); err == bufio.ErrBufferFull {
+			if err = br.Read(); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		return
+	}
+}
@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"bytes"
+	errs "errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	slotsCount = 16384
+	slotsMask  = slotsCount - 1
+
+	roleMaster = "master"
+	roleSlave  = "slave"
+)
+
+// errors
+var (
+	ErrBadClusterNodes = errs.New("bad CLUSTER NODES reply")
+)
+
+// node is one line of CLUSTER NODES reply.
+// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ... <slot>
+type node struct {
+	id      string
+	addr    string
+	flags   []string
+	role    string
+	slaveOf string
+	slots   []int
+}
+
+// nodeSlots 集群拓扑：主节点及slot到主节点地址的映射
+type nodeSlots struct {
+	nodes   map[string]*node // addr->node
+	masters []string         // 按地址排序的主节点地址，SCAN游标依赖稳定的顺序
+	slots   []string         // slot->master addr
+}
+
+// parseSlots parse the CLUSTER NODES reply payload.
+func parseSlots(data []byte) (*nodeSlots, error) {
+	ns := &nodeSlots{
+		nodes: make(map[string]*node),
+		slots: make([]string, slotsCount),
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		n, err := parseNode(string(line))
+		if err != nil {
+			return nil, err
+		}
+		if n.addr == "" {
+			continue // NOTE: noaddr node
+		}
+		ns.nodes[n.addr] = n
+		if n.role != roleMaster {
+			continue
+		}
+		ns.masters = append(ns.masters, n.addr)
+		for _, slot := range n.slots {
+			ns.slots[slot] = n.addr
+		}
+	}
+	if len(ns.masters) == 0 {
+		return nil, errors.Wrap(ErrBadClusterNodes, "no master node")
+	}
+	sort.Strings(ns.masters)
+	return ns, nil
+}
+
+func parseNode(line string) (n *node, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		err = errors.Wrapf(ErrBadClusterNodes, "line:%s", line)
+		return
+	}
+	n = &node{id: fields[0]}
+	addr := fields[1]
+	if idx := strings.IndexAny(addr, "@,"); idx != -1 {
+		addr = addr[:idx]
+	}
+	if !strings.HasPrefix(addr, ":") {
+		n.addr = addr
+	}
+	n.flags = strings.Split(fields[2], ",")
+	for _, flag := range n.flags {
+		switch flag {
+		case roleMaster:
+			n.role = roleMaster
+		case roleSlave:
+			n.role = roleSlave
+		}
+	}
+	if fields[3] != "-" {
+		n.slaveOf = fields[3]
+	}
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") {
+			continue // NOTE: importing or migrating slot
+		}
+		var begin, end int
+		if idx := strings.IndexByte(field, '-'); idx != -1 {
+			if begin, err = strconv.Atoi(field[:idx]); err == nil {
+				end, err = strconv.Atoi(field[idx+1:])
+			}
+		} else {
+			begin, err = strconv.Atoi(field)
+			end = begin
+		}
+		if err != nil || begin < 0 || end >= slotsCount || begin > end {
+			err = errors.Wrapf(ErrBadClusterNodes, "slot:%s", field)
+			return
+		}
+		for i := begin; i <= end; i++ {
+			n.slots = append(n.slots, i)
+		}
+	}
+	return
+}
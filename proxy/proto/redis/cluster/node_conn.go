@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"bytes"
+	errs "errors"
+
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxRedirects = 5
+)
+
+var (
+	movedBytes = []byte("MOVED ")
+	askBytes   = []byte("ASK ")
+)
+
+// errors
+var (
+	ErrRedirectBadFormat = errs.New("redis cluster redirect reply bad format")
+	ErrRedirectTooMany   = errs.New("redis cluster too many redirects")
+)
+
+// nodeConn wraps redis node conn and follows the MOVED/ASK redirect of reply.
+type nodeConn struct {
+	c  *cluster
+	nc proto.NodeConn
+}
+
+func newNodeConn(c *cluster, addr string) proto.NodeConn {
+	return &nodeConn{
+		c:  c,
+		nc: redis.NewAuthNodeConn(c.name, addr, c.dto, c.rto, c.wto, c.auth),
+	}
+}
+
+func (nc *nodeConn) Addr() string {
+	return nc.nc.Addr()
+}
+
+func (nc *nodeConn) Write(m *proto.Message) error {
+	return nc.nc.Write(m)
+}
+
+func (nc *nodeConn) Flush() error {
+	return nc.nc.Flush()
+}
+
+func (nc *nodeConn) Close() error {
+	return nc.nc.Close()
+}
+
+func (nc *nodeConn) Read(m *proto.Message) (err error) {
+	if err = nc.nc.Read(m); err != nil {
+		return
+	}
+	req, ok := m.Request().(*redis.Request)
+	if !ok {
+		return errors.WithStack(redis.ErrBadAssert)
+	}
+	addr, ask, isRedirect, rerr := parseRedirect(req.Reply())
+	if !isRedirect || rerr != nil {
+		// NOTE: bad redirect reply just return to client
+		return
+	}
+	if !ask {
+		nc.c.trigger() // NOTE: MOVED means slots changed
+	} else if req.InTx() {
+		// NOTE: ASKING only affects the next command, the transaction can't follow ASK
+		return
+	}
+	if req.Redirect(ask) > maxRedirects {
+		req.Reply().WithError(ErrRedirectTooMany)
+		return
+	}
+	ncp, rerr := nc.c.redirectPipe(addr)
+	if rerr != nil {
+		// NOTE: keep the node conn which is still healthy, just fail this message
+		req.Reply().WithError(errors.Cause(rerr))
+		return
+	}
+	// NOTE: the request is sent again by the pipe of node, the whole transaction is sent for EXEC
+	m.Redirect(ncp)
+	return
+}
+
+// parseRedirect parse the reply like: -MOVED 3999 127.0.0.1:6381 | -ASK 3999 127.0.0.1:6381
+func parseRedirect(reply *redis.RESP) (addr string, ask, isRedirect bool, err error) {
+	if reply.Type() != respError {
+		return
+	}
+	data := reply.Data()
+	switch {
+	case bytes.HasPrefix(data, movedBytes):
+	case bytes.HasPrefix(data, askBytes):
+		ask = true
+	default:
+		return
+	}
+	isRedirect = true
+	fields := bytes.Fields(data)
+	if len(fields) != 3 {
+		err = errors.Wrapf(ErrRedirectBadFormat, "reply:%s", data)
+		return
+	}
+	addr = string(fields[2])
+	return
+}
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const clusterNodesData = `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002,host2 master - 0 1426238316232 2 connected 5461-10922
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383 [16383->-e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca]
+6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:30005@31005 slave 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 0 1426238316232 5 connected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 127.0.0.1:30006@31006 slave 292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 0 1426238317741 6 connected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460
+bad0000000000000000000000000000000000000 :0@0 master,noaddr - 0 0 7 disconnected
+`
+
+func TestParseSlotsOk(t *testing.T) {
+	ns, err := parseSlots([]byte(clusterNodesData))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:30001", "127.0.0.1:30002", "127.0.0.1:30003"}, ns.masters)
+	assert.Len(t, ns.nodes, 6)
+	assert.Equal(t, "127.0.0.1:30001", ns.slots[0])
+	assert.Equal(t, "127.0.0.1:30001", ns.slots[5460])
+	assert.Equal(t, "127.0.0.1:30002", ns.slots[5461])
+	assert.Equal(t, "127.0.0.1:30002", ns.slots[10922])
+	assert.Equal(t, "127.0.0.1:30003", ns.slots[16383])
+
+	slave := ns.nodes["127.0.0.1:30005"]
+	assert.Equal(t, roleSlave, slave.role)
+	assert.Equal(t, "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1", slave.slaveOf)
+	assert.Len(t, ns.nodes["127.0.0.1:30003"].slots, 16384-10923)
+}
+
+func TestParseSlotsErr(t *testing.T) {
+	ts := []struct {
+		Name string
+		Data string
+	}{
+		{Name: "empty", Data: ""},
+		{Name: "fields", Data: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 master -\n"},
+		{Name: "badSlot", Data: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 master - 0 0 1 connected 0-16384\n"},
+		{Name: "reverseSlot", Data: "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 master - 0 0 1 connected 10-1\n"},
+		{Name: "noMaster", Data: "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := parseSlots([]byte(tt.Data))
+			assert.Equal(t, ErrBadClusterNodes, errors.Cause(err))
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"time"
+
+	"mycache/pkg/conv"
+	libnet "mycache/pkg/net"
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+	"mycache/proxy/proto/redis"
+)
+
+var (
+	cmdClusterBytes = []byte("7\r\nCLUSTER")
+	subNodesBytes   = []byte("NODES")
+	subSlotsBytes   = []byte("SLOTS")
+)
+
+// proxyConn wraps redis proxy conn, answers CLUSTER NODES/SLOTS as the proxy is the only node owns all slots.
+type proxyConn struct {
+	pc proto.ProxyConn
+
+	nodesBytes []byte
+	slotsBytes []byte
+}
+
+// NewProxyConn creates new redis cluster Encoder and Decoder.
+func NewProxyConn(conn *libnet.Conn, fer proto.Forwarder, password string) proto.ProxyConn {
+	p := &proxyConn{
+		pc: redis.NewProxyConn(conn, password),
+	}
+	p.pc.(*redis.ProxyConn).SetMode("cluster")
+	var listen string
+	if c, ok := fer.(*cluster); ok {
+		listen = c.listen
+	}
+	p.nodesBytes, p.slotsBytes = fakeTopology(announceAddr(listen, conn))
+	return p
+}
+
+// announceAddr returns the address which clients can connect to.
+func announceAddr(listen string, conn *libnet.Conn) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err == nil && host != "" && !net.ParseIP(host).IsUnspecified() {
+		return listen
+	}
+	// NOTE: listen on 0.0.0.0 or no listen addr, use the local addr of client conn
+	if conn.Conn != nil && conn.LocalAddr() != nil {
+		if lhost, lport, lerr := net.SplitHostPort(conn.LocalAddr().String()); lerr == nil {
+			if port == "" {
+				port = lport
+			}
+			return net.JoinHostPort(lhost, port)
+		}
+	}
+	return listen
+}
+
+// fakeTopology build the reply of CLUSTER NODES and CLUSTER SLOTS.
+func fakeTopology(addr string) (nodes, slots []byte) {
+	host, port, _ := net.SplitHostPort(addr)
+	id := fmt.Sprintf("%x", sha1.Sum([]byte(addr)))
+	line := fmt.Sprintf("%s %s@%s myself,master - 0 0 0 connected 0-%d\n", id, addr, port, slotsCount-1)
+	nodes = []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(line), line))
+	slots = []byte(fmt.Sprintf("*1\r\n*3\r\n:0\r\n:%d\r\n*3\r\n$%d\r\n%s\r\n:%s\r\n$%d\r\n%s\r\n", slotsCount-1, len(host), host, port, len(id), id))
+	return
+}
+
+// SetMGetPartialNil set whether MGET replies nil for the keys of failed nodes.
+func (p *proxyConn) SetMGetPartialNil(partialNil bool) {
+	p.pc.(*redis.ProxyConn).SetMGetPartialNil(partialNil)
+}
+
+// SetKeys set whether KEYS is enabled and the max count of keys in reply.
+func (p *proxyConn) SetKeys(enabled bool, maxReply int) {
+	p.pc.(*redis.ProxyConn).SetKeys(enabled, maxReply)
+}
+
+// SetPubSub set the locator of pubsub channel and the timeouts of the dedicated backend conn.
+func (p *proxyConn) SetPubSub(locate redis.PubSubLocator, shared bool, dialTimeout, writeTimeout time.Duration) {
+	p.pc.(*redis.ProxyConn).SetPubSub(locate, shared, dialTimeout, writeTimeout)
+}
+
+// SetTx set the locator of key and the timeouts of the dedicated backend conn for WATCH.
+func (p *proxyConn) SetTx(locate redis.NodeLocator, dialTimeout, readTimeout, writeTimeout time.Duration) {
+	p.pc.(*redis.ProxyConn).SetTx(locate, dialTimeout, readTimeout, writeTimeout)
+}
+
+// SetBlocking set the dedicated conn pool for blocking commands.
+func (p *proxyConn) SetBlocking(pool *redis.DedicatedPool) {
+	p.pc.(*redis.ProxyConn).SetBlocking(pool)
+}
+
+// SetNodeAuth set the AUTH and SELECT sent on the dedicated backend conns.
+func (p *proxyConn) SetNodeAuth(auth *redis.Auth) {
+	p.pc.(*redis.ProxyConn).SetNodeAuth(auth)
+}
+
+// SetUsers set the ACL users of proxy.
+func (p *proxyConn) SetUsers(users map[string]*redis.User) {
+	p.pc.(*redis.ProxyConn).SetUsers(users)
+}
+
+// SetInfo set the provider of INFO.
+func (p *proxyConn) SetInfo(info redis.InfoFunc) {
+	p.pc.(*redis.ProxyConn).SetInfo(info)
+}
+
+// SetSlowlog set the slowlog of cluster.
+func (p *proxyConn) SetSlowlog(slowlogs redis.Slowlogs) {
+	p.pc.(*redis.ProxyConn).SetSlowlog(slowlogs)
+}
+
+// Close close the dedicated backend conns of subscribe mode and WATCH.
+func (p *proxyConn) Close() error {
+	return p.pc.(*redis.ProxyConn).Close()
+}
+
+func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
+	msgs, err := p.pc.Decode(msgs)
+	for _, m := range msgs {
+		m.Type = types.CacheTypeRedisCluster
+	}
+	return msgs, err
+}
+
+func (p *proxyConn) Encode(m *proto.Message) error {
+	return p.pc.Encode(m)
+}
+
+func (p *proxyConn) Flush() error {
+	return p.pc.Flush()
+}
+
+func (p *proxyConn) IsAuthorized() bool {
+	return p.pc.IsAuthorized()
+}
+
+// CmdCheck handle CLUSTER NODES and CLUSTER SLOTS, others are handled by redis proxy conn.
+func (p *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
+	req, ok := m.Request().(*redis.Request)
+	if !ok || !p.pc.IsAuthorized() || p.pc.(*redis.ProxyConn).InPubSub() {
+		return p.pc.CmdCheck(m)
+	}
+	args := req.RESP().Array()
+	if len(args) != 2 || !bytes.Equal(args[0].Data(), cmdClusterBytes) {
+		return p.pc.CmdCheck(m)
+	}
+	sub := args[1].Data()
+	if idx := bytes.Index(sub, crlfBytes); idx != -1 {
+		sub = sub[idx+2:]
+	}
+	sub = append([]byte(nil), sub...)
+	conv.UpdateToUpper(sub)
+	bw := p.pc.(*redis.ProxyConn).Bw()
+	switch {
+	case bytes.Equal(sub, subNodesBytes):
+		return true, bw.Write(p.nodesBytes)
+	case bytes.Equal(sub, subSlotsBytes):
+		return true, bw.Write(p.slotsBytes)
+	}
+	return p.pc.CmdCheck(m)
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnClusterCmd(t *testing.T) {
+	ts := []struct {
+		Name    string
+		Data    string
+		Special bool
+		Expect  string
+	}{
+		{
+			Name:    "nodes",
+			Data:    "*2\r\n$7\r\ncluster\r\n$5\r\nnodes\r\n",
+			Special: true,
+			Expect:  "$103\r\n18b3e246e2407f2939f53e17811872922052b5fc 127.0.0.1:26379@26379 myself,master - 0 0 0 connected 0-16383\n\r\n",
+		},
+		{
+			Name:    "slots",
+			Data:    "*2\r\n$7\r\nCLUSTER\r\n$5\r\nSLOTS\r\n",
+			Special: true,
+			Expect:  "*1\r\n*3\r\n:0\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:26379\r\n$40\r\n18b3e246e2407f2939f53e17811872922052b5fc\r\n",
+		},
+		{
+			Name:    "info",
+			Data:    "*2\r\n$7\r\nCLUSTER\r\n$4\r\nINFO\r\n",
+			Special: false,
+			Expect:  "-ERR unknown command `CLUSTER`, with args beginning with:\r\n",
+		},
+		{
+			Name:    "get",
+			Data:    "*2\r\n$3\r\nGET\r\n$1\r\na\r\n",
+			Special: false,
+			Expect:  "",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			mc := mockconn.CreateMockConn([]byte(tt.Data), 1).(*mockconn.MockConn)
+			c := &cluster{listen: "127.0.0.1:26379"}
+			pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), c, "")
+			msgs, err := pc.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			if !assert.Len(t, msgs, 1) {
+				return
+			}
+			assert.Equal(t, types.CacheTypeRedisCluster, msgs[0].Type)
+			special, err := pc.CmdCheck(msgs[0])
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Special, special)
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, mc.Wbuf.String())
+		})
+	}
+}
+
+func TestAnnounceAddr(t *testing.T) {
+	conn := libnet.NewConn(nil, time.Second, time.Second)
+	assert.Equal(t, "10.0.0.1:26379", announceAddr("10.0.0.1:26379", conn))
+	// NOTE: mock conn local addr is "127.0.0.1:12345"
+	mconn := libnet.NewConn(mockconn.CreateMockConn(nil, 1), time.Second, time.Second)
+	assert.Equal(t, "127.0.0.1:26379", announceAddr("0.0.0.0:26379", mconn))
+	assert.Equal(t, "127.0.0.1:26379", announceAddr(":26379", mconn))
+	assert.Equal(t, "127.0.0.1:12345", announceAddr("", mconn))
+}
@@ -0,0 +1,204 @@
+package redis
+
+import (
+	errs "errors"
+	"sync"
+	"time"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dedicatedBufferSize  = 4096
+	defaultDedicatedIdle = 2
+)
+
+// NodeLocator returns the backend address of key.
+type NodeLocator func(key []byte) (addr string, ok bool)
+
+// dedicatedConn 客户端独占的后端连接，请求在handler协程里同步收发
+type dedicatedConn struct {
+	addr string
+	conn *libnet.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+	db   int // 当前SELECT的db
+}
+
+func dialDedicated(addr string, dialTimeout, readTimeout, writeTimeout time.Duration, auth *Auth) (*dedicatedConn, error) {
+	conn := auth.Dial(addr, dialTimeout, readTimeout, writeTimeout)
+	if conn.Conn == nil {
+		return nil, errors.Errorf("ERR fail to connect node %s", addr)
+	}
+	dc := &dedicatedConn{
+		addr: addr,
+		conn: conn,
+		br:   bufio.NewReader(conn, bufio.NewBuffer(dedicatedBufferSize)),
+		bw:   bufio.NewWriter(conn),
+	}
+	if err := auth.Handshake(addr, dc.br, dc.bw); err != nil {
+		_ = dc.Close()
+		return nil, err
+	}
+	dc.db = auth.db()
+	return dc, nil
+}
+
+// do send all requests at once on the db, only the reply of the last request is kept.
+func (dc *dedicatedConn) do(db int, reqs []*resp, reply *resp) (err error) {
+	if db != dc.db {
+		// NOTE: the reply of SELECT is dropped too, db is checked by proxy conn
+		reqs = append([]*resp{selectResp(db)}, reqs...)
+		dc.db = db
+	}
+	for _, req := range reqs {
+		if err = req.encode(dc.bw); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err = dc.bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range reqs {
+		r := reply
+		if i < len(reqs)-1 {
+			r = &resp{} // NOTE: replies before the last are dropped
+		}
+		if err = readReply(dc.br, r); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (dc *dedicatedConn) Close() error {
+	return dc.conn.Close()
+}
+
+// errors
+var (
+	ErrDedicatedPoolClosed = errs.New("ERR dedicated conn pool closed")
+	ErrDedicatedPoolFull   = errs.New("ERR too many blocking commands on node")
+)
+
+// DedicatedPool 按node按需创建的独占连接池，阻塞命令借用一个连接直到收到回复
+type DedicatedPool struct {
+	lock     sync.Mutex
+	idle     map[string][]*dedicatedConn
+	active   map[*dedicatedConn]struct{}
+	actives  map[string]int
+	maxIdle  int // 每个node保留的空闲连接数
+	maxConns int // 每个node最多借出的连接数，0不限制
+	closed   bool
+	auth     *Auth
+
+	dto, rto, wto time.Duration
+}
+
+// NewDedicatedPool new a dedicated conn pool, conns are dialed on demand.
+func NewDedicatedPool(dialTimeout, readTimeout, writeTimeout time.Duration) *DedicatedPool {
+	return &DedicatedPool{
+		idle:    map[string][]*dedicatedConn{},
+		active:  map[*dedicatedConn]struct{}{},
+		actives: map[string]int{},
+		maxIdle: defaultDedicatedIdle,
+		dto:     dialTimeout,
+		rto:     readTimeout,
+		wto:     writeTimeout,
+	}
+}
+
+// SetAuth set the AUTH and SELECT sent on every new conn.
+func (p *DedicatedPool) SetAuth(auth *Auth) {
+	p.lock.Lock()
+	p.auth = auth
+	p.lock.Unlock()
+}
+
+// SetLimit set the max idle conns and the max borrowed conns of every node.
+func (p *DedicatedPool) SetLimit(maxIdle, maxConns int) {
+	p.lock.Lock()
+	p.maxIdle, p.maxConns = maxIdle, maxConns
+	p.lock.Unlock()
+}
+
+func (p *DedicatedPool) get(addr string) (dc *dedicatedConn, err error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, ErrDedicatedPoolClosed
+	}
+	if p.maxConns > 0 && p.actives[addr] >= p.maxConns {
+		p.lock.Unlock()
+		return nil, ErrDedicatedPoolFull
+	}
+	p.actives[addr]++
+	if idles := p.idle[addr]; len(idles) > 0 {
+		dc = idles[len(idles)-1]
+		p.idle[addr] = idles[:len(idles)-1]
+	}
+	auth := p.auth
+	p.lock.Unlock()
+	if dc == nil {
+		if dc, err = dialDedicated(addr, p.dto, p.rto, p.wto, auth); err != nil {
+			p.lock.Lock()
+			p.actives[addr]--
+			p.lock.Unlock()
+			return
+		}
+	}
+	p.lock.Lock()
+	if p.closed {
+		// NOTE: closed while dialing
+		p.actives[addr]--
+		p.lock.Unlock()
+		_ = dc.Close()
+		return nil, ErrDedicatedPoolClosed
+	}
+	p.active[dc] = struct{}{}
+	p.lock.Unlock()
+	return
+}
+
+// put give back the conn, the broken conn is closed.
+func (p *DedicatedPool) put(dc *dedicatedConn, broken bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.active[dc]; !ok {
+		// NOTE: already closed by pool
+		return
+	}
+	delete(p.active, dc)
+	p.actives[dc.addr]--
+	if broken || p.closed || len(p.idle[dc.addr]) >= p.maxIdle {
+		_ = dc.Close()
+		return
+	}
+	dc.conn.SetReadTimeout(p.rto)
+	p.idle[dc.addr] = append(p.idle[dc.addr], dc)
+}
+
+// Close close all conns, the blocking commands which are waiting get errors.
+func (p *DedicatedPool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for _, idles := range p.idle {
+		for _, dc := range idles {
+			_ = dc.Close()
+		}
+	}
+	for dc := range p.active {
+		// NOTE: close the raw conn, the closed flag of libnet.Conn is not goroutine safe
+		_ = dc.conn.Conn.Close()
+	}
+	p.idle = map[string][]*dedicatedConn{}
+	p.active = map[*dedicatedConn]struct{}{}
+	return nil
+}
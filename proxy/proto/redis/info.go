@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+var (
+	cmdInfoBytes = []byte("4\r\nINFO")
+)
+
+// InfoFunc returns the text of INFO for the lower case sections, all default sections if sections is empty.
+type InfoFunc func(sections []string) string
+
+// SetInfo set the provider of INFO, INFO is not supported if it is nil.
+func (pc *proxyConn) SetInfo(info InfoFunc) {
+	pc.info = info
+}
+
+// infoCheck handle INFO [section ...] by proxy, the reply is a bulk string like redis.
+func (pc *proxyConn) infoCheck(req *Request) (err error) {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	if pc.info == nil {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR unknown command `%s`, with args beginning with:\r\n", req.CmdString())))
+	}
+	sections := make([]string, 0, req.resp.arraySize-1)
+	for _, arg := range req.resp.array[1:req.resp.arraySize] {
+		sections = append(sections, strings.ToLower(string(bulkPayload(arg.data))))
+	}
+	text := pc.info(sections)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(text), text)
+	return pc.bw.Write(buf.Bytes())
+}
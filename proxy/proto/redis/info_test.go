@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfoCmd(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("INFO\r\nINFO\r\nINFO Server CLIENTS\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			pc.SetInfo(func(sections []string) string { return strings.Join(sections, ",") })
+		}
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-ERR unknown command `INFO`, with args beginning with:\r\n$0\r\n\r\n$14\r\nserver,clients\r\n", mc.Wbuf.String())
+}
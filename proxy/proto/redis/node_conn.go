@@ -38,6 +38,8 @@ type nodeConn struct {
 	conn    *libnet.Conn
 	bw      *bufio.Writer
 	br      *bufio.Reader
+	err     error // 握手失败的错误
+	db      int   // 当前SELECT的db
 
 	state int32
 }
@@ -45,8 +47,20 @@ type nodeConn struct {
 // NewNodeConn create the node conn from proxy to redis
 //创建proxy到node的连接，主机名，ipc地址，各种超时控制时段
 func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
-	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
-	return newNodeConn(cluster, addr, conn)
+	return NewAuthNodeConn(cluster, addr, dialTimeout, readTimeout, writeTimeout, nil)
+}
+
+// NewAuthNodeConn create the node conn with TLS and send AUTH and SELECT on it.
+// NOTE: the node conn fails all messages with the handshake error, msgPipe will dial a new one.
+func NewAuthNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration, auth *Auth) (nc proto.NodeConn) {
+	conn := auth.Dial(addr, dialTimeout, readTimeout, writeTimeout)
+	nc = newNodeConn(cluster, addr, conn)
+	if conn.Conn != nil {
+		rnc := nc.(*nodeConn)
+		rnc.err = auth.Handshake(addr, rnc.br, rnc.bw)
+		rnc.db = auth.db()
+	}
+	return
 }
 
 func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
@@ -72,6 +86,9 @@ func (nc *nodeConn) Write(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrNodeConnClosed)
 		return
 	}
+	if nc.err != nil {
+		return nc.err
+	}
 	req, ok := m.Request().(*Request)
 	if !ok {
 		err = errors.WithStack(ErrBadAssert)
@@ -80,6 +97,21 @@ func (nc *nodeConn) Write(m *proto.Message) (err error) {
 	if !req.IsSupport() || req.IsCtl() {
 		return
 	}
+	// NOTE: the conn is shared by clients, switch to the db of client first
+	if req.selected = req.db != nc.db; req.selected {
+		_ = selectResp(req.db).encode(nc.bw)
+		nc.db = req.db
+	}
+	if req.asking {
+		_ = askingResp.encode(nc.bw)
+	}
+	if len(req.tx) > 0 {
+		// NOTE: MULTI ... EXEC are written at once on this conn
+		_ = multiResp.encode(nc.bw)
+		for _, cmd := range req.tx {
+			_ = cmd.encode(nc.bw)
+		}
+	}
 	if err = req.resp.encode(nc.bw); err != nil {
 		err = errors.WithStack(err)
 	}
@@ -98,6 +130,9 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrNodeConnClosed)
 		return
 	}
+	if nc.err != nil {
+		return nc.err
+	}
 	req, ok := m.Request().(*Request)
 	if !ok {
 		err = errors.WithStack(ErrBadAssert)
@@ -106,8 +141,47 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	if !req.IsSupport() || req.IsCtl() {
 		return
 	}
+	var selectReply *resp
+	if req.selected {
+		selectReply = &resp{}
+		if err = nc.readReply(selectReply); err != nil {
+			return
+		}
+	}
+	if req.asking {
+		if err = nc.readReply(&resp{}); err != nil {
+			return
+		}
+	}
+	// NOTE: drop the replies of MULTI and queued commands, EXEC replies all of them
+	var redirect *resp
+	for i := 0; i <= len(req.tx) && len(req.tx) > 0; i++ {
+		reply := &resp{}
+		if err = nc.readReply(reply); err != nil {
+			return
+		}
+		if redirect == nil && isRedirect(reply) {
+			redirect = reply
+		}
+	}
+	if err = nc.readReply(req.reply); err != nil {
+		return
+	}
+	if redirect != nil && req.reply.respType == respError {
+		// NOTE: the redirect of queued command is replied instead of EXECABORT, so that the whole transaction is redirected
+		req.reply.copy(redirect)
+	}
+	if selectReply != nil && selectReply.respType == respError {
+		// NOTE: the db is unknown now, SELECT again before the next request
+		nc.db = -1
+		req.reply.copy(selectReply)
+	}
+	return
+}
+
+func (nc *nodeConn) readReply(reply *resp) (err error) {
 	for {
-		if err = req.reply.decode(nc.br); err == bufio.ErrBufferFull {
+		if err = reply.decode(nc.br); err == bufio.ErrBufferFull {
 			if err = nc.br.Read(); err != nil {
 				err = errors.WithStack(err)
 				return
@@ -34,7 +34,7 @@ func (*mockCmd) Key() []byte {
 func (*mockCmd) Put() {
 }
 
-// func (*mockCmd) Slowlog() *proto.SlowlogEntry { return nil }
+func (*mockCmd) Slowlog() *proto.SlowlogEntry { return nil }
 
 func TestNodeConnNewNodeConn(t *testing.T) {
 	//为backend redis服务器建立和proxy服务器的socket端到端的连接通道
@@ -30,6 +30,7 @@ var (
 //node健康检测器，发起请求ping-->pong 模式
 type pinger struct {
 	conn *libnet.Conn
+	auth *Auth
 
 	br *bufio.Reader
 	bw *bufio.Writer
@@ -39,8 +40,14 @@ type pinger struct {
 
 // NewPinger new pinger.
 func NewPinger(conn *libnet.Conn) proto.Pinger {
+	return NewAuthPinger(conn, nil)
+}
+
+// NewAuthPinger new pinger which sends AUTH and SELECT before the first PING.
+func NewAuthPinger(conn *libnet.Conn, auth *Auth) proto.Pinger {
 	return &pinger{
 		conn:  conn,
+		auth:  auth,
 		br:    bufio.NewReader(conn, bufio.NewBuffer(pingBufferSize)),
 		bw:    bufio.NewWriter(conn),
 		state: opened,
@@ -52,6 +59,13 @@ func (p *pinger) Ping() (err error) {
 		err = errors.WithStack(ErrPingClosed)
 		return
 	}
+	if p.auth != nil && p.conn.Conn != nil {
+		// NOTE: the failed nodes are logged by the address of config, not the resolved one
+		if err = p.auth.Handshake(p.conn.Addr(), p.br, p.bw); err != nil {
+			return
+		}
+		p.auth = nil // NOTE: only once on the conn
+	}
 	_ = p.bw.Write(pingBytes)
 	if err = p.bw.Flush(); err != nil {
 		err = errors.WithStack(err)
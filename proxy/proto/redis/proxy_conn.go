@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"mycache/pkg/bufio"
 	"mycache/pkg/conv"
 	libnet "mycache/pkg/net"
+	"mycache/pkg/prom"
 	"mycache/pkg/types"
 	"mycache/proxy/proto"
+	"mycache/version"
 
 	"github.com/pkg/errors"
 )
@@ -21,6 +27,19 @@ var (
 	justOkBytes          = []byte("+OK\r\n")
 	invalidPasswordBytes = []byte("-ERR invalid password\r\n")
 	noAuthBytes          = []byte("-NOAUTH Authentication required.\r\n")
+	noProtoBytes         = []byte("-NOPROTO sorry, this protocol version is not supported.\r\n")
+	badProtoverBytes     = []byte("-ERR Protocol version is not an integer or out of range\r\n")
+	wrongPassBytes       = []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	keysDisabledBytes    = []byte("-ERR KEYS is disabled by proxy, use SCAN instead\r\n")
+	helloNoAuthBytes     = []byte("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n")
+
+	helloAuthBytes    = []byte("AUTH")
+	helloSetnameBytes = []byte("SETNAME")
+	defaultUserBytes  = []byte("default")
+
+	nilReply = &resp{respType: respBulk} // NOTE: $-1
+
+	failedShardsCount int64 // 多key命令部分失败的分片计数
 	//notSupportDataBytes = []byte("Error: command not support")
 )
 
@@ -40,8 +59,45 @@ type proxyConn struct {
 
 	resp *resp //br里缓冲区数据反序列化结构对象（流-->struct）
 
-	authorized bool   //proxy对客户端连接的认证
-	password   string //密码
+	authorized bool             //proxy对客户端连接的认证
+	password   string           //密码
+	users      map[string]*User //ACL用户
+	user       *User            //AUTH的ACL用户，nil是default用户
+
+	protover int    //客户端通过HELLO选择的协议版本，2或3
+	mode     string //HELLO回复里的mode，standalone或cluster
+
+	mgetPartialNil bool //MGET部分分片失败时，失败的key回复nil而不是整个命令报错
+	keysEnabled    bool //是否允许KEYS
+	keysMaxReply   int  //KEYS最多回复的key数量，0不限制
+
+	conn     *libnet.Conn
+	lock     sync.Mutex    //订阅模式下保护bw，后端推送的消息异步写回
+	sub      *pubsub       //订阅模式下独占的后端连接
+	locate   PubSubLocator //定位频道所在的后端node
+
+	pubsubShared bool //一个node能收到所有频道的消息：配置了pubsub_node或者redis cluster
+
+	multi      bool           //MULTI之后，EXEC/DISCARD之前
+	tx         []*resp        //MULTI后缓存的命令
+	txAborted  bool           //缓存命令时出错，EXEC回复EXECABORT
+	watch      *dedicatedConn //WATCH独占的后端连接，EXEC/DISCARD/UNWATCH后关闭
+	nodeLocate NodeLocator    //定位key所在的后端node
+	blocking   *DedicatedPool //阻塞命令独占的后端连接池
+	auth       *Auth          //独占的后端连接建立时发送的AUTH和SELECT
+	db         int            //客户端SELECT的db，默认是配置的redis_db
+	databases  int            //客户端可以SELECT的db数量
+	info       InfoFunc       //proxy回复INFO
+	slowlogs   Slowlogs       //proxy回复SLOWLOG
+	readonly   bool           //READONLY后读命令可以转发到从库，READWRITE恢复
+
+	dto, rto, wto time.Duration
+}
+
+// SetNodeAuth set the AUTH and SELECT sent on the dedicated backend conns.
+func (pc *proxyConn) SetNodeAuth(auth *Auth) {
+	pc.auth = auth
+	pc.db = auth.db()
 }
 
 // NewProxyConn creates new redis Encoder and Decoder.
@@ -52,6 +108,10 @@ func NewProxyConn(conn *libnet.Conn, password string) proto.ProxyConn {
 		completed: true, //连接代理已就绪
 		password:  password,
 		resp:      &resp{}, //返回的协议数据
+		protover:  protoRESP2,
+		mode:      "standalone",
+		databases: defaultDatabases,
+		conn:      conn,
 	}
 	if password != "" {
 		r.authorized = false
@@ -73,10 +133,13 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 		//读完标记为false
 		pc.completed = false
 	}
+	// NOTE: every command is checked by CmdCheck in subscribe mode
+	inSub := pc.InPubSub()
 	//初始化msgs结构数据
 	//流数据读进pc.br缓冲区后，解码到msgs结构对象里
 	for i := range msgs {
 		msgs[i].Type = types.CacheTypeRedis
+		mark := pc.br.Mark()
 		// decode
 		if err = pc.decode(msgs[i]); err == bufio.ErrBufferFull {
 			pc.completed = true
@@ -84,11 +147,29 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 		} else if err != nil {
 			return nil, err
 		}
+		pc.markDB(msgs[i])
+		pc.markReplica(msgs[i])
+		// NOTE: special command like HELLO changes the state of conn, it must be handled alone as the first message.
+		// so does every command in transaction, which is queued by CmdCheck, the command denied by ACL and in subscribe mode.
+		if pc.checkPerm(msgs[i]) || isSpecialMsg(msgs[i]) || pc.multi || inSub {
+			if i > 0 {
+				pc.br.AdvanceTo(mark)
+				msgs[i].Reset()
+				return msgs[:i], nil
+			}
+			msgs[i].MarkStart()
+			return msgs[:1], nil
+		}
 		msgs[i].MarkStart()
 	}
 	return msgs, nil
 }
 
+func isSpecialMsg(m *proto.Message) bool {
+	req, ok := m.Request().(*Request)
+	return ok && req.IsSpecial()
+}
+
 // 解码pc.br缓冲区的数据到message里，传入的是msg空对象
 func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	// for migrate sync PING process
@@ -118,6 +199,12 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	//把用户的终端输入的命令字符名转成大写格式（set-->SET）
 	conv.UpdateToUpper(pc.resp.array[0].data)
 	cmd := pc.resp.array[0].data // NOTE: when array, first is command
+	if pc.multi {
+		// NOTE: commands in transaction are queued as is
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		return
+	}
 
 	// 匹配redis支持的各种命令
 	if bytes.Equal(cmd, cmdMSetBytes) {
@@ -160,7 +247,7 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
 			nre2.copy(pc.resp.array[i])
 		}
-	} else if bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) {
+	} else if bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) || bytes.Equal(cmd, cmdUnlinkBytes) || bytes.Equal(cmd, cmdTouchBytes) {
 		for i := 1; i < pc.resp.arraySize; i++ {
 			r := nextReq(msg)
 			r.mType = mergeTypeCount
@@ -168,7 +255,7 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 			r.resp.respType = respArray
 			r.resp.data = append(r.resp.data, arrayLenTwo...)
 			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n
+			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n | $6\r\nUNLINK\r\n | $5\r\nTOUCH\r\n
 			nre1.copy(pc.resp.array[0])
 			// array resp: key
 			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
@@ -179,6 +266,13 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 		r := nextReq(msg)
 		//复制pc.resp数据到新的请求r里
 		r.resp.copy(pc.resp)
+		if bytes.Equal(cmd, cmdScanBytes) {
+			r.parseScanCursor()
+		} else if bytes.Equal(cmd, cmdKeysBytes) {
+			r.mType = mergeTypeKeys
+		} else if r.IsBroadcast() {
+			r.mType = mergeTypeBroadcast
+		}
 	}
 	return
 }
@@ -194,15 +288,21 @@ func nextReq(m *proto.Message) *Request {
 	}
 	r := req.(*Request)
 	r.mType = mergeTypeNo
+	r.redirects, r.asking = 0, false
 	return r
 }
 
 func (pc *proxyConn) Encode(m *proto.Message) (err error) {
-	if err = m.Err(); err != nil {
-		se := errors.Cause(err).Error()
-		pc.bw.Write(respErrorBytes)
-		pc.bw.Write([]byte(se))
-		pc.bw.Write(crlfBytes) //crlfBytes 字节流结尾
+	// NOTE: batch with failed sub messages is merged by per key, see mergeXXX
+	if err = m.Err(); err != nil && !hasSubErr(m) {
+		if req, ok := m.Request().(*Request); ok && len(req.tx) > 0 && errors.Cause(err) == proto.ErrCrossNode {
+			return pc.bw.Write(execAbortCrossNodeBytes)
+		}
+		pc.writeErr(err)
+		if cause := errors.Cause(err); cause == proto.ErrCrossNode || cause == proto.ErrBadCursor {
+			// NOTE: bad request of client, keep the conn
+			err = nil
+		}
 		return
 	}
 	req, ok := m.Request().(*Request)
@@ -216,8 +316,14 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 		err = pc.mergeJoin(m)
 	case mergeTypeCount:
 		err = pc.mergeCount(m)
+	case mergeTypeBroadcast:
+		err = pc.mergeBroadcast(m)
+	case mergeTypeScan:
+		err = pc.mergeScan(req)
+	case mergeTypeKeys:
+		err = pc.mergeKeys(m)
 	default:
-		err = req.reply.encode(pc.bw)
+		err = pc.encodeReply(req.reply, shapeOf(req.resp))
 	}
 	if err != nil {
 		err = errors.WithStack(err)
@@ -225,7 +331,81 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+func (pc *proxyConn) writeErr(err error) {
+	se := errors.Cause(err).Error()
+	pc.bw.Write(respErrorBytes)
+	pc.bw.Write([]byte(se))
+	pc.bw.Write(crlfBytes) //crlfBytes 字节流结尾
+}
+
+// hasSubErr check if the error of batch message comes from sub messages.
+func hasSubErr(m *proto.Message) bool {
+	for _, sub := range m.Subs() {
+		if sub.Err() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// failedShards returns the distinct shards of failed sub messages, the error cause is used when the sub message has no shard.
+func failedShards(subs []*proto.Message) (shards []string, firstErr error) {
+	for _, sub := range subs {
+		err := sub.Err()
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		shard := sub.Addr()
+		if shard == "" {
+			shard = errors.Cause(err).Error()
+		}
+		var dup bool
+		for _, s := range shards {
+			if s == shard {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			shards = append(shards, shard)
+		}
+	}
+	return
+}
+
+// countFailedShards count the failed shards of MSET/MGET/DEL/EXISTS.
+func countFailedShards(shards []string) {
+	if len(shards) == 0 {
+		return
+	}
+	atomic.AddInt64(&failedShardsCount, int64(len(shards)))
+	if prom.On {
+		prom.FailedShardsAdd(len(shards))
+	}
+}
+
+// FailedShards returns the total count of failed shards in MSET/MGET/DEL/EXISTS which is partially succeed.
+func FailedShards() int64 {
+	return atomic.LoadInt64(&failedShardsCount)
+}
+
+// encodeReply encode the reply by the protocol version selected by client.
+func (pc *proxyConn) encodeReply(reply *resp, shape replyShape) error {
+	if pc.protover == protoRESP3 {
+		return reply.encode3(pc.bw, shape)
+	}
+	return reply.encode(pc.bw)
+}
+
 func (pc *proxyConn) mergeOK(m *proto.Message) (err error) {
+	shards, _ := failedShards(m.Subs())
+	countFailedShards(shards)
+	if len(shards) > 0 {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR MSET failed on shards: %s\r\n", strings.Join(shards, ", "))))
+	}
 	//接收resp响应
 	_ = pc.bw.Write(respStringBytes)
 	//合并多个resq命令，在bw缓冲区尾部以okBytes
@@ -234,8 +414,14 @@ func (pc *proxyConn) mergeOK(m *proto.Message) (err error) {
 }
 
 func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
-	var sum = 0
-	for _, mreq := range m.Requests() {
+	subs := m.Subs()
+	shards, ferr := failedShards(subs)
+	countFailedShards(shards)
+	var sum, succeed = 0, 0
+	for i, mreq := range m.Requests() {
+		if len(subs) > i && subs[i].Err() != nil {
+			continue
+		}
 		req, ok := mreq.(*Request)
 		if !ok {
 			return ErrBadAssert
@@ -245,6 +431,12 @@ func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
 			return ErrBadCount
 		}
 		sum += int(ival)
+		succeed++
+	}
+	if succeed == 0 && len(shards) > 0 {
+		// NOTE: all shards failed, there is no count to report
+		pc.writeErr(ferr)
+		return
 	}
 	_ = pc.bw.Write(respIntBytes)
 	_ = pc.bw.Write([]byte(strconv.Itoa(sum)))
@@ -254,29 +446,133 @@ func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
 
 func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 	reqs := m.Requests()
-	_ = pc.bw.Write(respArrayBytes)
 	if len(reqs) == 0 {
+		if pc.protover == protoRESP3 {
+			return pc.bw.Write(respNullBytes)
+		}
+		_ = pc.bw.Write(respArrayBytes)
 		err = pc.bw.Write(nullBytes)
 		return
 	}
+	subs := m.Subs()
+	shards, ferr := failedShards(subs)
+	countFailedShards(shards)
+	if len(shards) > 0 && !pc.mgetPartialNil {
+		pc.writeErr(ferr)
+		return
+	}
+	_ = pc.bw.Write(respArrayBytes)
 	_ = pc.bw.Write([]byte(strconv.Itoa(len(reqs))))
 	if err = pc.bw.Write(crlfBytes); err != nil {
 		return
 	}
-	for _, mreq := range reqs {
+	for i, mreq := range reqs {
+		if len(subs) > i && subs[i].Err() != nil {
+			// NOTE: the key of failed shard is replied as nil
+			if err = pc.encodeReply(nilReply, shapeNone); err != nil {
+				return
+			}
+			continue
+		}
 		req, ok := mreq.(*Request)
 		if !ok {
 			return ErrBadAssert
 		}
-		if err = req.reply.encode(pc.bw); err != nil {
+		if err = pc.encodeReply(req.reply, shapeNone); err != nil {
 			return
 		}
 	}
 	return
 }
 
+// mergeBroadcast merge the replies of all nodes, the array reply like SCRIPT EXISTS is merged by AND.
+func (pc *proxyConn) mergeBroadcast(m *proto.Message) (err error) {
+	if shards, _ := failedShards(m.Subs()); len(shards) > 0 {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR %s failed on shards: %s\r\n", m.Request().CmdString(), strings.Join(shards, ", "))))
+	}
+	var first *resp
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType == respError {
+			return pc.encodeReply(req.reply, shapeNone)
+		}
+		if first == nil {
+			first = req.reply
+		} else if first.respType == respArray {
+			for i := 0; i < first.arraySize && i < req.reply.arraySize; i++ {
+				if !bytes.Equal(req.reply.array[i].data, first.array[i].data) {
+					first.array[i].data = append(first.array[i].data[:0], '0')
+				}
+			}
+		}
+	}
+	if first == nil {
+		return ErrBadRequest
+	}
+	return pc.encodeReply(first, shapeNone)
+}
+
+// mergeScan replace the backend cursor of SCAN reply with proxy cursor.
+func (pc *proxyConn) mergeScan(req *Request) (err error) {
+	reply := req.reply
+	if reply.respType != respArray || reply.arraySize != 2 {
+		return pc.encodeReply(reply, shapeNone)
+	}
+	bc, err := strconv.ParseUint(string(bulkPayload(reply.array[0].data)), 10, 64)
+	if err != nil {
+		return ErrBadRequest
+	}
+	next, err := req.nextScanCursor(bc)
+	if err != nil {
+		pc.writeErr(err)
+		return nil
+	}
+	cursor := strconv.FormatUint(next, 10)
+	_ = pc.bw.Write([]byte(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(cursor), cursor)))
+	return pc.encodeReply(reply.array[1], shapeNone)
+}
+
+// mergeKeys join the keys of all nodes, the reply is refused when there are too many keys.
+func (pc *proxyConn) mergeKeys(m *proto.Message) (err error) {
+	if shards, _ := failedShards(m.Subs()); len(shards) > 0 {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR KEYS failed on shards: %s\r\n", strings.Join(shards, ", "))))
+	}
+	var total int
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType != respArray {
+			return pc.encodeReply(req.reply, shapeNone)
+		}
+		total += req.reply.arraySize
+	}
+	if pc.keysMaxReply > 0 && total > pc.keysMaxReply {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR KEYS reply has more than %d keys, use SCAN instead\r\n", pc.keysMaxReply)))
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(total)))
+	_ = pc.bw.Write(crlfBytes)
+	for _, mreq := range m.Requests() {
+		reply := mreq.(*Request).reply
+		for i := 0; i < reply.arraySize; i++ {
+			if err = pc.encodeReply(reply.array[i], shapeNone); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 func (pc *proxyConn) Flush() (err error) {
-	return pc.bw.Flush()
+	pc.lock.Lock()
+	err = pc.bw.Flush()
+	pc.lock.Unlock()
+	return
 }
 
 func (pc *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
@@ -287,6 +583,28 @@ func (pc *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
 		return isSpecialCmd, ErrBadAssert
 	}
 
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	//ACL用户没有权限
+	if req.noPerm != nil {
+		if pc.multi {
+			pc.txAborted = true
+		}
+		return true, pc.bw.Write(req.noPerm)
+	}
+	//事务
+	if req.resp.arraySize > 0 && pc.sub == nil && (pc.multi || isTxCmd(req)) {
+		return pc.txCheck(req)
+	}
+	//订阅模式
+	if req.resp.arraySize > 0 && (pc.sub != nil || isSubscribe(req) || isUnsubscribe(req)) {
+		return pc.pubsubCheck(req)
+	}
+	//阻塞命令
+	if req.resp.arraySize > 0 && isBlocking(req) {
+		return true, pc.blockingCheck(req)
+	}
+
 	//不支持的命令
 	if !req.IsSupport() {
 		err = pc.Bw().Write([]byte(fmt.Sprintf("-ERR unknown command `%s`, with args beginning with:\r\n", req.CmdString())))
@@ -303,20 +621,26 @@ func (pc *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
 		return
 	}
 
+	// KEYS 开启后和普通命令一样转发，由forwarder广播到所有node
+	if bytes.Equal(req.resp.array[0].data, cmdKeysBytes) {
+		if !pc.authorized {
+			err = pc.Bw().Write(noAuthBytes)
+			return
+		}
+		if !pc.keysEnabled {
+			isSpecialCmd = true
+			err = pc.Bw().Write(keysDisabledBytes)
+		}
+		return
+	}
+
 	//特殊命令，auth，ping，quit，command
 	if req.IsSpecial() {
 		isSpecialCmd = true
 		reqData := req.resp.array[0].data
 		//字节流判断 消息组里第一个命令是否是auth命令
 		if bytes.Equal(reqData, cmdAuthBytes) {
-			if bytes.Equal(req.Key(), []byte(pc.password)) {
-				pc.authorized = true
-				err = pc.Bw().Write(justOkBytes) //返回客户端conn
-			} else {
-				pc.authorized = false
-				err = pc.Bw().Write(invalidPasswordBytes)
-			}
-
+			err = pc.authUser(req)
 		} else if bytes.Equal(reqData, cmdPingBytes) {
 			if status := pc.authorized; status {
 				err = pc.Bw().Write(pongDataBytes)
@@ -327,6 +651,16 @@ func (pc *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
 			err = pc.Bw().Write(justOkBytes)
 		} else if bytes.Equal(reqData, cmdCommandBytes) {
 			err = pc.Bw().Write([]byte(":-1\r\n"))
+		} else if bytes.Equal(reqData, cmdHelloBytes) {
+			err = pc.hello(req)
+		} else if bytes.Equal(reqData, cmdSelectBytes) {
+			err = pc.selectDB(req)
+		} else if bytes.Equal(reqData, cmdInfoBytes) {
+			err = pc.infoCheck(req)
+		} else if bytes.Equal(reqData, cmdSlowlogBytes) {
+			err = pc.slowlogCheck(req)
+		} else if bytes.Equal(reqData, cmdReadonlyBytes) || bytes.Equal(reqData, cmdReadwriteBytes) {
+			err = pc.readonlyCheck(req)
 		}
 	} else {
 		//常规命令时，校验认证
@@ -338,6 +672,97 @@ func (pc *proxyConn) CmdCheck(m *proto.Message) (isSpecialCmd bool, err error) {
 	return
 }
 
+// hello handle HELLO [protover [AUTH username password] [SETNAME clientname]].
+func (pc *proxyConn) hello(req *Request) (err error) {
+	args := req.resp.array[1:req.resp.arraySize]
+	protover := pc.protover
+	if len(args) > 0 {
+		ver, ok := parseProtover(args[0].data)
+		if !ok {
+			return pc.bw.Write(badProtoverBytes)
+		}
+		if ver != protoRESP2 && ver != protoRESP3 {
+			return pc.bw.Write(noProtoBytes)
+		}
+		protover = ver
+		args = args[1:]
+	}
+	authed, user := pc.authorized, pc.user
+	for len(args) > 0 {
+		opt := bulkPayload(args[0].data)
+		switch {
+		case bytes.EqualFold(opt, helloAuthBytes) && len(args) >= 3:
+			u, ok := pc.lookupUser(bulkPayload(args[1].data), bulkPayload(args[2].data))
+			if !ok && (pc.password != "" || len(pc.users) > 0) {
+				return pc.bw.Write(wrongPassBytes)
+			}
+			authed, user = true, u
+			args = args[3:]
+		case bytes.EqualFold(opt, helloSetnameBytes) && len(args) >= 2:
+			// NOTE: client name is meaningless for proxy, just ignore it
+			args = args[2:]
+		default:
+			return pc.bw.Write([]byte(fmt.Sprintf("-ERR Syntax error in HELLO option '%s'\r\n", opt)))
+		}
+	}
+	if !authed {
+		return pc.bw.Write(helloNoAuthBytes)
+	}
+	pc.authorized, pc.user = true, user
+	pc.protover = protover
+	return pc.encodeReply(pc.helloReply(), shapeMap)
+}
+
+// helloReply build the server info of HELLO.
+func (pc *proxyConn) helloReply() *resp {
+	r := &resp{}
+	r.respType = respArray
+	r.data = append(r.data, "14"...)
+	bulk := func(val string) {
+		nre := r.next()
+		nre.respType = respBulk
+		nre.data = append(nre.data, fmt.Sprintf("%d\r\n%s", len(val), val)...)
+	}
+	integer := func(val int) {
+		nre := r.next()
+		nre.respType = respInt
+		nre.data = append(nre.data, strconv.Itoa(val)...)
+	}
+	bulk("server")
+	bulk("mycache")
+	bulk("version")
+	bulk(version.Str())
+	bulk("proto")
+	integer(pc.protover)
+	bulk("id")
+	integer(0)
+	bulk("mode")
+	bulk(pc.mode)
+	bulk("role")
+	bulk("master")
+	bulk("modules")
+	modules := r.next()
+	modules.respType = respArray
+	modules.data = append(modules.data, '0')
+	return r
+}
+
+// SetMGetPartialNil set whether MGET replies nil for the keys of failed shards.
+func (pc *proxyConn) SetMGetPartialNil(partialNil bool) {
+	pc.mgetPartialNil = partialNil
+}
+
+// SetKeys set whether KEYS is enabled and the max count of keys in reply.
+func (pc *proxyConn) SetKeys(enabled bool, maxReply int) {
+	pc.keysEnabled = enabled
+	pc.keysMaxReply = maxReply
+}
+
+// SetMode set the mode replied by HELLO.
+func (pc *proxyConn) SetMode(mode string) {
+	pc.mode = mode
+}
+
 func (pc *proxyConn) SetAuthorized(status bool) {
 	pc.authorized = status
 }
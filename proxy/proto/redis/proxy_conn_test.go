@@ -1,10 +1,15 @@
 package redis
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"mycache/pkg/bufio"
 	"mycache/pkg/mockconn"
 	libnet "mycache/pkg/net"
 	"mycache/proxy/proto"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -326,6 +331,49 @@ func TestEncodeMergeOk(t *testing.T) {
 	}
 }
 
+func TestEncodePartialFailure(t *testing.T) {
+	ts := []struct {
+		Name       string
+		MType      mergeType
+		PartialNil bool
+		Failed     []bool
+		Expect     string
+	}{
+		{Name: "mgetNil", MType: mergeTypeJoin, PartialNil: true, Failed: []bool{false, true, false}, Expect: "*3\r\n:1\r\n$-1\r\n:3\r\n"},
+		{Name: "mgetErr", MType: mergeTypeJoin, Failed: []bool{false, true, false}, Expect: "-ERR shard down\r\n"},
+		{Name: "count", MType: mergeTypeCount, Failed: []bool{false, true, false}, Expect: ":4\r\n"},
+		{Name: "countAllFailed", MType: mergeTypeCount, Failed: []bool{true, true, true}, Expect: "-ERR shard down\r\n"},
+		{Name: "mset", MType: mergeTypeOK, Failed: []bool{true, false, true}, Expect: "-ERR MSET failed on shards: 127.0.0.1:6379, 127.0.0.1:6381\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for i := range tt.Failed {
+				req := getReq()
+				req.mType = tt.MType
+				req.reply = &resp{respType: respInt, data: []byte(strconv.Itoa(i + 1))}
+				msg.WithRequest(req)
+			}
+			before := FailedShards()
+			var failed int64
+			for i, sub := range msg.Batch() {
+				sub.MarkAddr(fmt.Sprintf("127.0.0.1:%d", 6379+i))
+				if tt.Failed[i] {
+					sub.WithError(errors.New("ERR shard down"))
+					failed++
+				}
+			}
+			conn, buf := mockconn.CreateMockDownStremConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "").(*proxyConn)
+			pc.SetMGetPartialNil(tt.PartialNil)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+			assert.Equal(t, before+failed, FailedShards())
+		})
+	}
+}
+
 func TestEncodeWithError(t *testing.T) {
 	msg := proto.NewMessage()
 	req := getReq()
@@ -380,3 +428,252 @@ func TestEncodeWithPing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "+PONG\r\n", string(data[:size]))
 }
+
+func TestDecodeSpecialAlone(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("*1\r\n$3\r\nGET\r\n*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, "")
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 1)
+	assert.Equal(t, "GET", nmsgs[0].Request().CmdString())
+
+	nmsgs, err = pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 1)
+	assert.Equal(t, "HELLO", nmsgs[0].Request().CmdString())
+
+	nmsgs, err = pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 1)
+	assert.Equal(t, "a", string(nmsgs[0].Request().Key()))
+}
+
+func TestCmdCheckHello(t *testing.T) {
+	helloMap := "%7\r\n$6\r\nserver\r\n$7\r\nmycache\r\n$7\r\nversion\r\n$5\r\n0.0.1\r\n$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:0\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+	ts := []struct {
+		Name       string
+		Password   string
+		Data       string
+		Expect     string
+		Authorized bool
+	}{
+		{Name: "resp2", Data: "HELLO 2\r\n", Authorized: true,
+			Expect: "*14\r\n$6\r\nserver\r\n$7\r\nmycache\r\n$7\r\nversion\r\n$5\r\n0.0.1\r\n$5\r\nproto\r\n:2\r\n$2\r\nid\r\n:0\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"},
+		{Name: "resp3", Data: "hello 3 setname cli\r\n", Authorized: true, Expect: helloMap},
+		{Name: "auth", Password: "pw", Data: "HELLO 3 AUTH default pw\r\n", Authorized: true, Expect: helloMap},
+		{Name: "wrongPass", Password: "pw", Data: "HELLO 3 AUTH default bad\r\n", Expect: string(wrongPassBytes)},
+		{Name: "wrongUser", Password: "pw", Data: "HELLO 3 AUTH foo pw\r\n", Expect: string(wrongPassBytes)},
+		{Name: "noAuth", Password: "pw", Data: "HELLO 3\r\n", Expect: string(helloNoAuthBytes)},
+		{Name: "noProto", Data: "HELLO 4\r\n", Authorized: true, Expect: string(noProtoBytes)},
+		{Name: "badProto", Data: "HELLO x\r\n", Authorized: true, Expect: string(badProtoverBytes)},
+		{Name: "syntax", Data: "HELLO 3 AUTH default\r\n", Authorized: true, Expect: "-ERR Syntax error in HELLO option 'AUTH'\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			mc := mockconn.CreateMockConn([]byte(tt.Data), 1).(*mockconn.MockConn)
+			pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), tt.Password)
+			msgs, err := pc.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			special, err := pc.CmdCheck(msgs[0])
+			assert.NoError(t, err)
+			assert.True(t, special)
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, mc.Wbuf.String())
+			assert.Equal(t, tt.Authorized, pc.IsAuthorized())
+		})
+	}
+}
+
+func TestEncodeRESP3(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Req    string
+		Reply  string
+		Expect string
+	}{
+		{Name: "map", Req: "HGETALL h\r\n", Reply: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", Expect: "%1\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{Name: "set", Req: "SMEMBERS s\r\n", Reply: "*1\r\n$1\r\na\r\n", Expect: "~1\r\n$1\r\na\r\n"},
+		{Name: "double", Req: "ZSCORE z a\r\n", Reply: "$3\r\n1.5\r\n", Expect: ",1.5\r\n"},
+		{Name: "doubleNull", Req: "ZSCORE z a\r\n", Reply: "$-1\r\n", Expect: "_\r\n"},
+		{Name: "nullBulk", Req: "GET a\r\n", Reply: "$-1\r\n", Expect: "_\r\n"},
+		{Name: "emptyBulk", Req: "GET a\r\n", Reply: "$0\r\n\r\n", Expect: "$0\r\n\r\n"},
+		{Name: "nullInArray", Req: "HMGET h a b\r\n", Reply: "*2\r\n$1\r\na\r\n$-1\r\n", Expect: "*2\r\n$1\r\na\r\n_\r\n"},
+		{Name: "scores", Req: "ZRANGE z 0 -1 withscores\r\n", Reply: "*2\r\n$1\r\na\r\n$1\r\n1\r\n", Expect: "*1\r\n*2\r\n$1\r\na\r\n,1\r\n"},
+		{Name: "noScores", Req: "ZRANGE z 0 -1\r\n", Reply: "*1\r\n$1\r\na\r\n", Expect: "*1\r\n$1\r\na\r\n"},
+		{Name: "int", Req: "INCR a\r\n", Reply: ":1\r\n", Expect: ":1\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			mc := mockconn.CreateMockConn([]byte(tt.Req), 1).(*mockconn.MockConn)
+			pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+			pc.protover = protoRESP3
+			msgs, err := pc.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			req := msgs[0].Request().(*Request)
+			br := bufio.NewReader(bytes.NewBuffer([]byte(tt.Reply)), bufio.Get(1024))
+			assert.NoError(t, br.Read())
+			assert.NoError(t, req.reply.decode(br))
+			assert.NoError(t, pc.Encode(msgs[0]))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, mc.Wbuf.String())
+		})
+	}
+}
+
+func TestDecodeUnlinkTouchSplit(t *testing.T) {
+	for _, cmd := range []string{"UNLINK", "TOUCH"} {
+		nmsgs := _decodeMessage(t, strings.ToLower(cmd)+" a b c\r\n")
+		if !assert.Len(t, nmsgs, 1) {
+			continue
+		}
+		reqs := nmsgs[0].Requests()
+		assert.Len(t, reqs, 3)
+		for i, r := range reqs {
+			req := r.(*Request)
+			assert.Equal(t, mergeTypeCount, req.mType)
+			assert.Equal(t, cmd, req.CmdString())
+			assert.Equal(t, string(rune('a'+i)), string(req.Key()))
+		}
+	}
+}
+
+func TestEncodeCrossNode(t *testing.T) {
+	msg := proto.NewMessage()
+	msg.WithRequest(getReq())
+	msg.WithError(proto.ErrCrossNode)
+	conn, buf := mockconn.CreateMockDownStremConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "")
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same node\r\n", buf.String())
+}
+
+func TestEncodeMergeBroadcast(t *testing.T) {
+	ts := []struct {
+		Name    string
+		Replies []*resp
+		Failed  bool
+		Expect  string
+	}{
+		{
+			Name:    "load",
+			Replies: []*resp{{respType: respBulk, data: []byte("3\r\nsha")}, {respType: respBulk, data: []byte("3\r\nsha")}},
+			Expect:  "$3\r\nsha\r\n",
+		},
+		{
+			Name: "exists",
+			Replies: []*resp{
+				{respType: respArray, data: []byte("2"), arraySize: 2, array: []*resp{{respType: respInt, data: []byte("1")}, {respType: respInt, data: []byte("1")}}},
+				{respType: respArray, data: []byte("2"), arraySize: 2, array: []*resp{{respType: respInt, data: []byte("0")}, {respType: respInt, data: []byte("1")}}},
+			},
+			Expect: "*2\r\n:0\r\n:1\r\n",
+		},
+		{
+			Name:    "replyErr",
+			Replies: []*resp{{respType: respString, data: []byte("OK")}, {respType: respError, data: []byte("ERR oops")}},
+			Expect:  "-ERR oops\r\n",
+		},
+		{
+			Name:    "failed",
+			Replies: []*resp{{respType: respString, data: []byte("OK")}, {respType: respString, data: []byte("OK")}},
+			Failed:  true,
+			Expect:  "-ERR SCRIPT failed on shards: 127.0.0.1:6380\r\n",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for _, rpl := range tt.Replies {
+				req := getReq()
+				req.mType = mergeTypeBroadcast
+				req.resp = &resp{respType: respArray, data: []byte("1"), arraySize: 1, array: []*resp{{respType: respBulk, data: []byte("6\r\nSCRIPT")}}}
+				req.reply = rpl
+				msg.WithRequest(req)
+			}
+			subs := msg.Batch()
+			subs[1].MarkAddr("127.0.0.1:6380")
+			if tt.Failed {
+				subs[1].WithError(errors.New("ERR down"))
+			}
+			before := FailedShards()
+			conn, buf := mockconn.CreateMockDownStremConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "")
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+			// NOTE: only the failed shards of MSET/MGET/DEL/EXISTS are counted
+			assert.Equal(t, before, FailedShards())
+		})
+	}
+}
+
+func TestEncodeScanAndKeys(t *testing.T) {
+	keysReply := func(keys ...string) *resp {
+		r := &resp{respType: respArray, data: []byte(strconv.Itoa(len(keys)))}
+		for _, key := range keys {
+			nre := r.next()
+			nre.respType = respBulk
+			nre.data = []byte(fmt.Sprintf("%d\r\n%s", len(key), key))
+		}
+		return r
+	}
+
+	msg := proto.NewMessage()
+	req := getReq()
+	req.mType = mergeTypeScan
+	req.scanIdx, req.scanNodes = 0, 2
+	req.reply = &resp{respType: respArray, data: []byte("2"), arraySize: 2, array: []*resp{{respType: respBulk, data: []byte("1\r\n0")}, keysReply("a")}}
+	msg.WithRequest(req)
+	conn, buf := mockconn.CreateMockDownStremConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "").(*proxyConn)
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*2\r\n$1\r\n1\r\n*1\r\n$1\r\na\r\n", buf.String())
+
+	// NOTE: the backend cursor overflows with the node index
+	buf.Reset()
+	req.reply.array[0].data = []byte("20\r\n18446744073709551615")
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-"+ErrScanCursorOverflow.Error()+"\r\n", buf.String())
+
+	for _, limit := range []int{0, 2} {
+		msg = proto.NewMessage()
+		for _, keys := range [][]string{{"a", "b"}, {"c"}} {
+			req := getReq()
+			req.mType = mergeTypeKeys
+			req.reply = keysReply(keys...)
+			msg.WithRequest(req)
+		}
+		msg.Batch()
+		conn, buf := mockconn.CreateMockDownStremConn()
+		pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "").(*proxyConn)
+		pc.SetKeys(true, limit)
+		assert.NoError(t, pc.Encode(msg))
+		assert.NoError(t, pc.Flush())
+		if limit == 0 {
+			assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", buf.String())
+		} else {
+			assert.Equal(t, "-ERR KEYS reply has more than 2 keys, use SCAN instead\r\n", buf.String())
+		}
+	}
+}
+
+func TestCmdCheckKeys(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		mc := mockconn.CreateMockConn([]byte("KEYS *\r\n"), 1).(*mockconn.MockConn)
+		pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+		pc.SetKeys(enabled, 0)
+		msgs, err := pc.Decode(proto.GetMsgs(1))
+		assert.NoError(t, err)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.Equal(t, !enabled, special)
+		assert.NoError(t, pc.Flush())
+		if enabled {
+			assert.Empty(t, mc.Wbuf.String())
+		} else {
+			assert.Equal(t, string(keysDisabledBytes), mc.Wbuf.String())
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"fmt"
+	"time"
+
+	"mycache/pkg/bufio"
+	"mycache/pkg/log"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	cmdSubscribeBytes    = []byte("9\r\nSUBSCRIBE")
+	cmdPSubscribeBytes   = []byte("10\r\nPSUBSCRIBE")
+	cmdUnsubscribeBytes  = []byte("11\r\nUNSUBSCRIBE")
+	cmdPUnsubscribeBytes = []byte("12\r\nPUNSUBSCRIBE")
+	cmdPublishBytes      = []byte("7\r\nPUBLISH")
+
+	replyUnsubscribeBytes  = []byte("11\r\nunsubscribe")
+	replyPUnsubscribeBytes = []byte("12\r\npunsubscribe")
+
+	psubscribeNotSharedBytes = []byte("-ERR PSUBSCRIBE is only supported with pubsub_node or redis cluster\r\n")
+
+	// 订阅模式下允许的命令
+	pubsubAllowCmds = map[string]struct{}{
+		"9\r\nSUBSCRIBE":     {},
+		"10\r\nPSUBSCRIBE":   {},
+		"11\r\nUNSUBSCRIBE":  {},
+		"12\r\nPUNSUBSCRIBE": {},
+		"4\r\nPING":          {},
+		"4\r\nQUIT":          {},
+	}
+)
+
+// errors
+var (
+	ErrPubSubNoNode = errs.New("pubsub channel no hit node")
+)
+
+const pubsubBufferSize = 4096
+
+// PubSubLocator returns the backend address of channel.
+type PubSubLocator func(channel []byte) (addr string, ok bool)
+
+// pubsub 订阅模式下客户端独占的后端连接，后端推送的消息异步写回客户端
+type pubsub struct {
+	pc     *proxyConn
+	addr   string // 订阅的频道所在的node
+	conn   *libnet.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	closed bool // NOTE: protected by pc.lock
+}
+
+// isSubscribe check if the request switches the conn into subscribe mode.
+func isSubscribe(req *Request) bool {
+	cmd := req.resp.array[0].data
+	return bytes.Equal(cmd, cmdSubscribeBytes) || bytes.Equal(cmd, cmdPSubscribeBytes)
+}
+
+func isUnsubscribe(req *Request) bool {
+	cmd := req.resp.array[0].data
+	return bytes.Equal(cmd, cmdUnsubscribeBytes) || bytes.Equal(cmd, cmdPUnsubscribeBytes)
+}
+
+// SetPubSub set the locator of pubsub channel and the timeouts of the dedicated backend conn.
+// The subscriber can subscribe any channel and pattern if shared, which means one node receives the messages of all channels.
+func (pc *proxyConn) SetPubSub(locate PubSubLocator, shared bool, dialTimeout, writeTimeout time.Duration) {
+	pc.locate = locate
+	pc.pubsubShared = shared
+	pc.dto, pc.wto = dialTimeout, writeTimeout
+}
+
+// InPubSub check if the conn is in subscribe mode.
+func (pc *proxyConn) InPubSub() bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	return pc.sub != nil
+}
+
+// Close close the dedicated backend conns of subscribe mode and WATCH.
+func (pc *proxyConn) Close() error {
+	pc.lock.Lock()
+	pc.closeSub()
+	pc.resetTx()
+	pc.lock.Unlock()
+	return nil
+}
+
+// pubsubCheck handle the commands in or into subscribe mode, NOTE: pc.lock must be held.
+func (pc *proxyConn) pubsubCheck(req *Request) (isSpecialCmd bool, err error) {
+	if pc.sub == nil {
+		switch {
+		case isSubscribe(req):
+			return true, pc.subscribe(req)
+		case isUnsubscribe(req):
+			return true, pc.unsubscribeNone(req)
+		}
+		return
+	}
+	isSpecialCmd = true
+	if _, ok := pubsubAllowCmds[string(req.resp.array[0].data)]; !ok {
+		err = pc.bw.Write([]byte(fmt.Sprintf("-ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n", bytes.ToLower(req.Cmd()))))
+		return
+	}
+	if bytes.Equal(req.resp.array[0].data, cmdQuitBytes) {
+		pc.closeSub()
+		err = pc.bw.Write(justOkBytes)
+		return
+	}
+	if isSubscribe(req) {
+		if _, ok := pc.subscribeAddr(req, pc.sub.addr); !ok {
+			return
+		}
+	}
+	pc.forwardSub(req)
+	return
+}
+
+// subscribe dial the node of the first channel and switch into subscribe mode.
+func (pc *proxyConn) subscribe(req *Request) (err error) {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	if req.resp.arraySize < 2 {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", bytes.ToLower(req.Cmd()))))
+	}
+	addr, ok := pc.subscribeAddr(req, "")
+	if !ok {
+		return
+	}
+	// NOTE: no read timeout, the subscriber waits for messages forever
+	conn := pc.auth.Dial(addr, pc.dto, 0, pc.wto)
+	if conn.Conn == nil {
+		pc.writeErr(errors.Errorf("ERR fail to connect pubsub node %s", addr))
+		return
+	}
+	sub := &pubsub{
+		pc:   pc,
+		addr: addr,
+		conn: conn,
+		br:   bufio.NewReader(conn, bufio.NewBuffer(pubsubBufferSize)),
+		bw:   bufio.NewWriter(conn),
+	}
+	if herr := pc.auth.Handshake(addr, sub.br, sub.bw); herr != nil {
+		_ = conn.Close()
+		pc.writeErr(herr)
+		return
+	}
+	pc.sub = sub
+	go pc.sub.loop()
+	pc.forwardSub(req)
+	return
+}
+
+// subscribeAddr locate the node of channels, which must be the pinned node if it's not empty, the error is replied if not ok.
+// NOTE: the messages of the channels on other nodes never reach the dedicated conn, so they are refused unless shared,
+// and so is the pattern which may match the channels of any node.
+func (pc *proxyConn) subscribeAddr(req *Request, pinned string) (addr string, ok bool) {
+	if bytes.Equal(req.resp.array[0].data, cmdPSubscribeBytes) && !pc.pubsubShared {
+		_ = pc.bw.Write(psubscribeNotSharedBytes)
+		return
+	}
+	if pc.pubsubShared && pinned != "" {
+		return pinned, true
+	}
+	addr = pinned
+	for _, ch := range req.resp.array[1:req.resp.arraySize] {
+		var caddr string
+		if pc.locate != nil {
+			caddr, ok = pc.locate(bulkPayload(ch.data))
+		}
+		if !ok {
+			pc.writeErr(ErrPubSubNoNode)
+			return "", false
+		}
+		if addr == "" {
+			addr = caddr
+		}
+		if pc.pubsubShared {
+			return
+		}
+		if caddr != addr {
+			_ = pc.bw.Write([]byte(fmt.Sprintf("-ERR channel '%s' is on other node than the subscribed channels, set pubsub_node to subscribe them\r\n", bulkPayload(ch.data))))
+			return "", false
+		}
+	}
+	return addr, true
+}
+
+// unsubscribeNone reply the UNSUBSCRIBE out of subscribe mode, just like redis does.
+func (pc *proxyConn) unsubscribeNone(req *Request) (err error) {
+	kind := bytes.ToLower(req.Cmd())
+	if req.resp.arraySize < 2 {
+		return pc.bw.Write([]byte(fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$-1\r\n:0\r\n", len(kind), kind)))
+	}
+	for _, ch := range req.resp.array[1:req.resp.arraySize] {
+		payload := bulkPayload(ch.data)
+		err = pc.bw.Write([]byte(fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:0\r\n", len(kind), kind, len(payload), payload)))
+	}
+	return
+}
+
+// forwardSub send the request to the dedicated backend conn.
+func (pc *proxyConn) forwardSub(req *Request) {
+	sub := pc.sub
+	_ = req.resp.encode(sub.bw)
+	if err := sub.bw.Flush(); err != nil {
+		log.Warnf("pubsub fail to write node:%s with error:%v", sub.conn.RemoteAddr(), err)
+		pc.closeSub()
+		pc.closeClient()
+	}
+}
+
+// closeSub quit the subscribe mode, NOTE: pc.lock must be held.
+func (pc *proxyConn) closeSub() {
+	if pc.sub == nil {
+		return
+	}
+	pc.sub.closed = true
+	// NOTE: close the raw conn, the closed flag of libnet.Conn is not goroutine safe
+	_ = pc.sub.conn.Conn.Close()
+	pc.sub = nil
+}
+
+// closeClient close the client conn, the client should reconnect and subscribe again.
+func (pc *proxyConn) closeClient() {
+	if pc.conn != nil && pc.conn.Conn != nil {
+		_ = pc.conn.Conn.Close()
+	}
+}
+
+// loop read the pushed messages from backend and write them back to client.
+func (ps *pubsub) loop() {
+	pc := ps.pc
+	for {
+		reply := &resp{}
+		err := ps.read(reply)
+		pc.lock.Lock()
+		if ps.closed {
+			pc.lock.Unlock()
+			return
+		}
+		if err != nil {
+			log.Warnf("pubsub fail to read node:%s with error:%v", ps.conn.RemoteAddr(), err)
+			pc.closeSub()
+			pc.closeClient()
+			pc.lock.Unlock()
+			return
+		}
+		if pc.protover == protoRESP3 && reply.respType == respArray {
+			reply.respType = respPush
+		}
+		_ = reply.encode(pc.bw)
+		werr := pc.bw.Flush()
+		if isLastUnsubscribe(reply) || werr != nil {
+			// NOTE: no subscription any more, back to normal mode
+			pc.closeSub()
+		}
+		pc.lock.Unlock()
+	}
+}
+
+func (ps *pubsub) read(reply *resp) (err error) {
+	for {
+		if err = reply.decode(ps.br); err == bufio.ErrBufferFull {
+			if err = ps.br.Read(); err != nil {
+				return
+			}
+			continue
+		}
+		return
+	}
+}
+
+// isLastUnsubscribe check if the reply is like: unsubscribe channel 0
+func isLastUnsubscribe(reply *resp) bool {
+	if reply.arraySize != 3 {
+		return false
+	}
+	kind := reply.array[0].data
+	if !bytes.Equal(kind, replyUnsubscribeBytes) && !bytes.Equal(kind, replyPUnsubscribeBytes) {
+		return false
+	}
+	count := reply.array[2]
+	return count.respType == respInt && bytes.Equal(count.data, []byte("0"))
+}
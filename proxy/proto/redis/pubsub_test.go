@@ -0,0 +1,250 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakePubSubNode 模拟订阅的后端node，收到命令后按顺序回复replies
+func _fakePubSubNode(t *testing.T, replies ...string) (addr string, cmds chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	cmds = make(chan string, len(replies))
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for _, reply := range replies {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			// NOTE: the array of bulk is like: *2 $9 SUBSCRIBE $2 ch
+			var args []string
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			for i := 0; i < n*2; i++ {
+				arg, _ := br.ReadString('\n')
+				if i%2 == 1 {
+					args = append(args, strings.TrimSpace(arg))
+				}
+			}
+			cmds <- strings.Join(args, " ")
+			conn.Write([]byte(reply))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}()
+	return l.Addr().String(), cmds
+}
+
+func _written(pc *proxyConn, mc *mockconn.MockConn) string {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	return mc.Wbuf.String()
+}
+
+func TestPubSubSubscribeAndBack(t *testing.T) {
+	subReply := "*3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n"
+	msgReply := "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n"
+	unsubReply := "*3\r\n$11\r\nunsubscribe\r\n$2\r\nch\r\n:0\r\n"
+	addr, cmds := _fakePubSubNode(t, subReply+msgReply, unsubReply)
+
+	mc := mockconn.CreateMockConn([]byte("SUBSCRIBE ch\r\nGET a\r\nUNSUBSCRIBE ch\r\nGET a\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(func(channel []byte) (string, bool) {
+		assert.Equal(t, "ch", string(channel))
+		return addr, true
+	}, false, time.Second, time.Second)
+
+	// SUBSCRIBE
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.True(t, pc.InPubSub())
+	assert.Equal(t, "SUBSCRIBE ch", <-cmds)
+	assert.Eventually(t, func() bool { return _written(pc, mc) == subReply+msgReply }, time.Second, 10*time.Millisecond)
+
+	// GET is refused in subscribe mode
+	msgs, err = pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Contains(t, _written(pc, mc), "-ERR Can't execute 'get'")
+
+	// UNSUBSCRIBE the last channel, back to normal mode
+	msgs, err = pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.Equal(t, "UNSUBSCRIBE ch", <-cmds)
+	assert.Eventually(t, func() bool { return !pc.InPubSub() }, time.Second, 10*time.Millisecond)
+	assert.True(t, strings.HasSuffix(_written(pc, mc), unsubReply))
+
+	msgs, err = pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.False(t, special)
+	assert.NoError(t, pc.Close())
+}
+
+func TestPubSubUnsubscribeNone(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("UNSUBSCRIBE a b\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:0\r\n*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n", mc.Wbuf.String())
+	assert.False(t, pc.InPubSub())
+}
+
+func TestPubSubNoNode(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("SUBSCRIBE ch\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Contains(t, mc.Wbuf.String(), ErrPubSubNoNode.Error())
+	assert.False(t, pc.InPubSub())
+}
+
+func TestPubSubChannelsOnOtherNode(t *testing.T) {
+	subReply := "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n"
+	addr, cmds := _fakePubSubNode(t, subReply)
+	// NOTE: the channel b is on other node
+	locate := func(channel []byte) (string, bool) {
+		if string(channel) == "b" {
+			return "127.0.0.1:1", true
+		}
+		return addr, true
+	}
+
+	// the channels of one SUBSCRIBE are on different nodes
+	mc := mockconn.CreateMockConn([]byte("SUBSCRIBE a b\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(locate, false, time.Second, time.Second)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Contains(t, mc.Wbuf.String(), "-ERR channel 'b' is on other node")
+	assert.False(t, pc.InPubSub())
+
+	// the channel on other node is subscribed in subscribe mode
+	mc = mockconn.CreateMockConn([]byte("SUBSCRIBE a\r\nSUBSCRIBE b\r\n"), 1).(*mockconn.MockConn)
+	pc = NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(locate, false, time.Second, time.Second)
+	msgs, err = pc.Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.True(t, pc.InPubSub())
+	assert.Equal(t, "SUBSCRIBE a", <-cmds)
+	assert.Eventually(t, func() bool { return _written(pc, mc) == subReply }, time.Second, 10*time.Millisecond)
+
+	msgs, err = pc.Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	special, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Contains(t, _written(pc, mc), "-ERR channel 'b' is on other node")
+	assert.True(t, pc.InPubSub())
+	assert.NoError(t, pc.Close())
+}
+
+func TestPubSubPatternNotShared(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("PSUBSCRIBE a*\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(func(channel []byte) (string, bool) {
+		return "127.0.0.1:1", true
+	}, false, time.Second, time.Second)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(psubscribeNotSharedBytes), mc.Wbuf.String())
+	assert.False(t, pc.InPubSub())
+}
+
+func TestPubSubPatternShared(t *testing.T) {
+	subReply := "*3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n"
+	addr, cmds := _fakePubSubNode(t, subReply)
+	mc := mockconn.CreateMockConn([]byte("PSUBSCRIBE a*\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(func(channel []byte) (string, bool) {
+		return addr, true
+	}, true, time.Second, time.Second)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.True(t, pc.InPubSub())
+	assert.Equal(t, "PSUBSCRIBE a*", <-cmds)
+	assert.Eventually(t, func() bool { return _written(pc, mc) == subReply }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, pc.Close())
+}
+
+func TestPubSubPipelineRefused(t *testing.T) {
+	subReply := "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n"
+	addr, cmds := _fakePubSubNode(t, subReply)
+	mc := mockconn.CreateMockConn([]byte("SUBSCRIBE a\r\nGET x\r\nGET y\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetPubSub(func(channel []byte) (string, bool) {
+		return addr, true
+	}, false, time.Second, time.Second)
+
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	special, err := pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.True(t, special)
+	assert.Equal(t, "SUBSCRIBE a", <-cmds)
+	assert.Eventually(t, func() bool { return _written(pc, mc) == subReply }, time.Second, 10*time.Millisecond)
+
+	// NOTE: every pipelined command is refused alone
+	for i := 0; i < 2; i++ {
+		msgs, err = pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 1)
+		special, err = pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, 2, strings.Count(_written(pc, mc), "-ERR Can't execute 'get'"))
+	assert.NoError(t, pc.Close())
+}
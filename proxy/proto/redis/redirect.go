@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"bytes"
+)
+
+var (
+	movedBytes = []byte("MOVED ")
+	askBytes   = []byte("ASK ")
+
+	askingResp = &resp{respType: respArray, data: []byte("1"), array: []*resp{{respType: respBulk, data: []byte("6\r\nASKING")}}, arraySize: 1}
+)
+
+// isRedirect check if the reply is MOVED or ASK of redis cluster.
+func isRedirect(reply *resp) bool {
+	return reply.respType == respError && (bytes.HasPrefix(reply.data, movedBytes) || bytes.HasPrefix(reply.data, askBytes))
+}
+
+// Redirect mark the request redirected by MOVED or ASK of redis cluster, ASKING is sent before it if ask.
+// It returns the times of redirects.
+func (r *Request) Redirect(ask bool) int {
+	r.asking = ask
+	r.redirects++
+	return r.redirects
+}
+
+// InTx returns true if the request is EXEC with the queued commands of transaction.
+func (r *Request) InTx() bool {
+	return len(r.tx) > 0
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"bytes"
+
+	"mycache/proxy/proto"
+)
+
+var (
+	cmdReadonlyBytes  = []byte("8\r\nREADONLY")
+	cmdReadwriteBytes = []byte("9\r\nREADWRITE")
+
+	// replicaExcludeCmds the read commands always sent to master, the cursor is only valid on the same node.
+	replicaExcludeCmds = map[string]struct{}{
+		"4\r\nSCAN":  {},
+		"5\r\nHSCAN": {},
+		"5\r\nSSCAN": {},
+		"5\r\nZSCAN": {},
+	}
+	reqReplicaCmdMap = map[string]struct{}{}
+)
+
+func init() {
+	for _, cmd := range readCmds {
+		if _, ok := replicaExcludeCmds[cmd]; !ok {
+			reqReplicaCmdMap[cmd] = struct{}{}
+		}
+	}
+}
+
+// ReadReplica impl the proto.ReplicaRequest.
+func (r *Request) ReadReplica() bool {
+	return r.replica
+}
+
+// readonlyCheck handle READONLY and READWRITE, the reads of READONLY client can be served by replicas.
+func (pc *proxyConn) readonlyCheck(req *Request) error {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	pc.readonly = bytes.Equal(req.resp.array[0].data, cmdReadonlyBytes)
+	return pc.bw.Write(justOkBytes)
+}
+
+// markReplica mark the read requests of message if the client is READONLY.
+func (pc *proxyConn) markReplica(m *proto.Message) {
+	for _, r := range m.Requests() {
+		if req, ok := r.(*Request); ok {
+			req.replica = false
+			if pc.readonly && req.resp.arraySize > 0 {
+				_, req.replica = reqReplicaCmdMap[string(req.resp.array[0].data)]
+			}
+		}
+	}
+}
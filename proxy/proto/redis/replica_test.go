@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadonlyMarkReplica(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("GET a\r\nREADONLY\r\nGET a\r\nMGET a b\r\nSET a 1\r\nSCAN 0\r\nREADWRITE\r\nGET a\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	decode := func() *proto.Message {
+		msgs, err := pc.Decode(proto.GetMsgs(1))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 1)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		if special {
+			return nil
+		}
+		return msgs[0]
+	}
+	replica := func(m *proto.Message) (rs []bool) {
+		for _, req := range m.Requests() {
+			rs = append(rs, req.(proto.ReplicaRequest).ReadReplica())
+		}
+		return
+	}
+
+	assert.Equal(t, []bool{false}, replica(decode()))
+	assert.Nil(t, decode())
+	assert.True(t, pc.readonly)
+	assert.Equal(t, []bool{true}, replica(decode()))
+	assert.Equal(t, []bool{true, true}, replica(decode()))
+	assert.Equal(t, []bool{false}, replica(decode()))
+	// NOTE: the cursor is only valid on master
+	assert.Equal(t, []bool{false}, replica(decode()))
+	assert.Nil(t, decode())
+	assert.False(t, pc.readonly)
+	assert.Equal(t, []bool{false}, replica(decode()))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n+OK\r\n", mc.Wbuf.String())
+}
+
+func TestReadonlyNoAuth(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("READONLY\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "pwd").(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	_, err = pc.CmdCheck(msgs[0])
+	assert.NoError(t, err)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(noAuthBytes), mc.Wbuf.String())
+	assert.False(t, pc.readonly)
+}
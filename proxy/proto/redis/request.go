@@ -7,7 +7,14 @@ package redis
 import (
 	"bytes"
 	errs "errors"
+	"fmt"
+	"math"
+	"strconv"
 	"sync"
+
+	"mycache/pkg/conv"
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
 )
 
 var (
@@ -18,6 +25,10 @@ var (
 	arrayLenThree = []byte("3")
 
 	cmdEvalBytes    = []byte("4\r\nEVAL")
+	cmdEvalShaBytes = []byte("7\r\nEVALSHA")
+	cmdScriptBytes  = []byte("6\r\nSCRIPT")
+	cmdScanBytes    = []byte("4\r\nSCAN")
+	cmdKeysBytes    = []byte("4\r\nKEYS")
 	cmdQuitBytes    = []byte("4\r\nQUIT")
 	cmdPingBytes    = []byte("4\r\nPING")
 	cmdMSetBytes    = []byte("4\r\nMSET")
@@ -25,8 +36,12 @@ var (
 	cmdGetBytes     = []byte("3\r\nGET")
 	cmdDelBytes     = []byte("3\r\nDEL")
 	cmdExistsBytes  = []byte("6\r\nEXISTS")
+	cmdUnlinkBytes  = []byte("6\r\nUNLINK")
+	cmdTouchBytes   = []byte("5\r\nTOUCH")
+	cmdBitOpBytes   = []byte("5\r\nBITOP")
 	cmdAuthBytes    = []byte("4\r\nAUTH")
 	cmdCommandBytes = []byte("7\r\nCOMMAND")
+	cmdHelloBytes   = []byte("5\r\nHELLO")
 
 	reqSupportCmdMap = map[string]struct{}{}
 	reqControlCmdMap = map[string]struct{}{}
@@ -53,6 +68,8 @@ var (
 	ErrBadAssert  = errs.New("bad assert for redis") //类型断言错误
 	ErrBadCount   = errs.New("bad count number")     //数量错误
 	ErrBadRequest = errs.New("bad request")          //请求失败
+
+	ErrScanCursorOverflow = errs.New("ERR backend cursor is too large to be encoded by proxy")
 )
 
 // mergeType is used to decript the merge operation.
@@ -64,6 +81,18 @@ const (
 	mergeTypeCount
 	mergeTypeOK
 	mergeTypeJoin
+	mergeTypeBroadcast
+	mergeTypeScan
+	mergeTypeKeys
+)
+
+// scanNodeBits 代理游标的低位保存node序号，高位保存后端游标
+const (
+	scanNodeBits = 10
+	scanNodeMask = 1<<scanNodeBits - 1
+
+	// ScanMaxNodes the max count of nodes which SCAN can iterate, the node index must fit in the cursor.
+	ScanMaxNodes = scanNodeMask + 1
 )
 
 // Request is the type of a complete redis command
@@ -71,6 +100,20 @@ type Request struct {
 	resp  *resp     //请求体resp协议项
 	reply *resp     //响应体resp协议项
 	mType mergeType //消息合并？？
+
+	scanIdx   int // SCAN游标里的node序号
+	scanNodes int // SCAN时的node总数
+
+	tx []*resp // EXEC时MULTI后缓存的命令，和MULTI/EXEC一起发送
+
+	db       int  // 客户端SELECT的db
+	selected bool // 写入前先发送了SELECT，读回复时先丢掉SELECT的回复
+	replica  bool // READONLY的客户端的读命令，可以转发到从库
+
+	redirects int  // redis cluster重定向的次数
+	asking    bool // 写入前先发送ASKING，读回复时先丢掉ASKING的回复
+
+	noPerm []byte // ACL用户没有权限时回复的NOPERM错误
 }
 
 var reqPool = &sync.Pool{
@@ -92,15 +135,16 @@ func newReq() *Request {
 	return r
 }
 
-// // Slowlog impl the Slowlogger interface
-// func (r *Request) Slowlog() *proto.SlowlogEntry {
-// 	slog := proto.NewSlowlogEntry(types.CacheTypeRedis)
-// 	if r.resp.arraySize == 0 {
-// 		slog.Cmd = []string{string(proto.CollapseBody(r.resp.data))}
-// 	}
-// 	slog.Cmd = collapseArray(r.resp.Array())
-// 	return slog
-// }
+// Slowlog impl the proto.Request interface.
+func (r *Request) Slowlog() *proto.SlowlogEntry {
+	slog := proto.NewSlowlogEntry(types.CacheTypeRedis)
+	if r.resp.arraySize == 0 {
+		slog.Cmd = []string{string(proto.CollapseBody(r.resp.data))}
+		return slog
+	}
+	slog.Cmd = collapseArray(r.resp.array[:r.resp.arraySize])
+	return slog
+}
 
 // CmdString get the cmd
 //获取cmd的字符串形式
@@ -128,19 +172,31 @@ func (r *Request) Key() []byte {
 	if r.resp.arraySize < 1 {
 		return emptyBytes
 	}
+	// NOTE: EXEC is routed by the first key of transaction
+	if len(r.tx) > 0 {
+		if keys := txKeys(r.tx); len(keys) > 0 {
+			return keys[0]
+		}
+		return emptyBytes
+	}
 	if r.resp.arraySize == 1 {
 		return r.resp.array[0].data
 	}
 
 	k := r.resp.array[1]
+	// NOTE: BITOP operation destkey key [key ...]
+	if r.resp.arraySize > 2 && bytes.Equal(r.resp.array[0].data, cmdBitOpBytes) {
+		k = r.resp.array[2]
+	}
 	// SUPPORT EVAL command
-	const evalArgsMinCount int = 4
 	//判断是否是 执行脚本命令
-	if r.resp.arraySize >= evalArgsMinCount {
-		if bytes.Equal(r.resp.array[0].data, cmdEvalBytes) {
-			// find the 4th key with index 3
-			k = r.resp.array[3]
+	if r.isScript() {
+		keys := r.Keys()
+		if len(keys) == 0 {
+			// NOTE: numkeys 0, the forwarder routes it by the script route key
+			return emptyBytes
 		}
+		return keys[0]
 	}
 
 	var pos int
@@ -150,12 +206,193 @@ func (r *Request) Key() []byte {
 	return k.data[pos:]
 }
 
+// keyPos 多key命令里key的位置，last为负数时从末尾倒数
+type keyPos struct {
+	first, last, step int
+	numkeys           int // NOTE: index of numkeys argument, keys follow it
+}
+
+var multiKeyCmds = map[string]keyPos{
+	"4\r\nMSET":         {first: 1, last: -1, step: 2}, // NOTE: not split in transaction
+	"4\r\nMGET":         {first: 1, last: -1, step: 1},
+	"3\r\nDEL":          {first: 1, last: -1, step: 1},
+	"6\r\nEXISTS":       {first: 1, last: -1, step: 1},
+	"6\r\nUNLINK":       {first: 1, last: -1, step: 1},
+	"5\r\nTOUCH":        {first: 1, last: -1, step: 1},
+	"6\r\nMSETNX":       {first: 1, last: -1, step: 2},
+	"5\r\nSDIFF":        {first: 1, last: -1, step: 1},
+	"6\r\nSINTER":       {first: 1, last: -1, step: 1},
+	"6\r\nSUNION":       {first: 1, last: -1, step: 1},
+	"10\r\nSDIFFSTORE":  {first: 1, last: -1, step: 1},
+	"11\r\nSINTERSTORE": {first: 1, last: -1, step: 1},
+	"11\r\nSUNIONSTORE": {first: 1, last: -1, step: 1},
+	"5\r\nSMOVE":        {first: 1, last: 2, step: 1},
+	"6\r\nRENAME":       {first: 1, last: 2, step: 1},
+	"8\r\nRENAMENX":     {first: 1, last: 2, step: 1},
+	"9\r\nRPOPLPUSH":    {first: 1, last: 2, step: 1},
+	"5\r\nBLPOP":        {first: 1, last: -2, step: 1},
+	"5\r\nBRPOP":        {first: 1, last: -2, step: 1},
+	"10\r\nBRPOPLPUSH":  {first: 1, last: 2, step: 1},
+	"6\r\nBLMOVE":       {first: 1, last: 2, step: 1},
+	"5\r\nBITOP":        {first: 2, last: -1, step: 1},
+	"7\r\nPFCOUNT":      {first: 1, last: -1, step: 1},
+	"7\r\nPFMERGE":      {first: 1, last: -1, step: 1},
+	"11\r\nZINTERSTORE": {first: 1, numkeys: 2},
+	"11\r\nZUNIONSTORE": {first: 1, numkeys: 2},
+	"4\r\nEVAL":         {numkeys: 2},
+	"7\r\nEVALSHA":      {numkeys: 2},
+}
+
+var broadcastScriptCmds = map[string]struct{}{
+	"LOAD":   {},
+	"EXISTS": {},
+	"FLUSH":  {},
+}
+
+func (r *Request) isScript() bool {
+	cmd := r.resp.array[0].data
+	return bytes.Equal(cmd, cmdEvalBytes) || bytes.Equal(cmd, cmdEvalShaBytes)
+}
+
+// IsBroadcast impl the proto.BroadcastRequest, KEYS and SCRIPT LOAD|EXISTS|FLUSH must be sent to all nodes.
+func (r *Request) IsBroadcast() bool {
+	if r.resp.arraySize == 2 && bytes.Equal(r.resp.array[0].data, cmdKeysBytes) {
+		return true
+	}
+	if r.resp.arraySize < 2 || !bytes.Equal(r.resp.array[0].data, cmdScriptBytes) {
+		return false
+	}
+	sub := bytes.ToUpper(bulkPayload(r.resp.array[1].data))
+	_, ok := broadcastScriptCmds[string(sub)]
+	return ok
+}
+
+// IsPublish impl the proto.PublishRequest.
+func (r *Request) IsPublish() bool {
+	return r.resp.arraySize == 3 && bytes.Equal(r.resp.array[0].data, cmdPublishBytes)
+}
+
+// IsCursor impl the proto.CursorRequest.
+func (r *Request) IsCursor() bool {
+	return r.mType == mergeTypeScan
+}
+
+// NodeIndex impl the proto.CursorRequest.
+func (r *Request) NodeIndex() int {
+	return r.scanIdx
+}
+
+// WithNodes impl the proto.CursorRequest.
+func (r *Request) WithNodes(nodes int) {
+	r.scanNodes = nodes
+}
+
+// parseScanCursor split the proxy cursor into node index and backend cursor, the cursor is rewrote as backend cursor.
+func (r *Request) parseScanCursor() {
+	r.mType = mergeTypeScan
+	r.scanIdx = -1
+	if r.resp.arraySize < 2 {
+		return
+	}
+	cursor, err := strconv.ParseUint(string(bulkPayload(r.resp.array[1].data)), 10, 64)
+	if err != nil {
+		return
+	}
+	r.scanIdx = int(cursor & scanNodeMask)
+	bc := strconv.FormatUint(cursor>>scanNodeBits, 10)
+	r.resp.array[1].data = append(r.resp.array[1].data[:0], strconv.Itoa(len(bc))...)
+	r.resp.array[1].data = append(r.resp.array[1].data, crlfBytes...)
+	r.resp.array[1].data = append(r.resp.array[1].data, bc...)
+}
+
+// nextScanCursor encode the backend cursor of reply as proxy cursor, it moves to next node when the backend cursor is 0.
+// NOTE: the backend cursor which has no room for the node index is refused, it never wraps.
+func (r *Request) nextScanCursor(bc uint64) (uint64, error) {
+	if bc > math.MaxUint64>>scanNodeBits {
+		return 0, ErrScanCursorOverflow
+	}
+	if bc != 0 {
+		return bc<<scanNodeBits | uint64(r.scanIdx), nil
+	}
+	if r.scanIdx+1 < r.scanNodes {
+		return uint64(r.scanIdx + 1), nil
+	}
+	return 0, nil
+}
+
+// CopyTo impl the proto.BroadcastRequest.
+func (r *Request) CopyTo(dst proto.Request) proto.Request {
+	req, ok := dst.(*Request)
+	if !ok {
+		req = getReq()
+	}
+	req.resp.copy(r.resp)
+	req.reply.reset()
+	req.mType = r.mType
+	req.db = r.db
+	req.replica = r.replica
+	return req
+}
+
+// Keys impl the proto.MultiKeyRequest and get all keys of multi-key command or transaction.
+func (r *Request) Keys() [][]byte {
+	if len(r.tx) > 0 {
+		return txKeys(r.tx)
+	}
+	return respKeys(r.resp)
+}
+
+func respKeys(r *resp) [][]byte {
+	if r.arraySize < 2 {
+		return nil
+	}
+	pos, ok := multiKeyCmds[string(r.array[0].data)]
+	if !ok {
+		return nil
+	}
+	args := r.array[:r.arraySize]
+	var keys [][]byte
+	if pos.numkeys > 0 {
+		// NOTE: [destkey] numkeys key [key ...] [WEIGHTS ...|arg ...]
+		if pos.first > 0 {
+			keys = append(keys, bulkPayload(args[pos.first].data))
+		}
+		if len(args) <= pos.numkeys {
+			return keys
+		}
+		n, err := conv.Btoi(bulkPayload(args[pos.numkeys].data))
+		if err != nil || n < 0 || pos.numkeys+int(n) >= len(args) {
+			return keys
+		}
+		for i := pos.numkeys + 1; i <= pos.numkeys+int(n); i++ {
+			keys = append(keys, bulkPayload(args[i].data))
+		}
+		return keys
+	}
+	last := pos.last
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	for i := pos.first; i <= last; i += pos.step {
+		keys = append(keys, bulkPayload(args[i].data))
+	}
+	return keys
+}
+
 // Put the resource back to pool
 // 重置request数据结构放回req对象零时池里
 func (r *Request) Put() {
 	r.resp.reset()
 	r.reply.reset()
 	r.mType = mergeTypeNo
+	r.scanIdx, r.scanNodes = 0, 0
+	r.tx = nil
+	r.db, r.selected, r.replica = 0, false, false
+	r.redirects, r.asking = 0, false
+	r.noPerm = nil
 	reqPool.Put(r)
 }
 
@@ -212,27 +449,28 @@ func (r *Request) IsSpecial() bool {
 
 const maxArray = 32
 
-// func collapseArray(rs []*resp) (collapsed []string) {
-// 	if len(rs) < maxArray {
-// 		collapsed = make([]string, len(rs), len(rs))
-// 		for i, r := range rs {
-// 			collapsed[i] = string(proto.CollapseBody(r.data))
-// 		}
-// 		return
-// 	}
-// 	collapsed = make([]string, maxArray, maxArray)
-// 	for i := 0; i < 15; i++ {
-// 		collapsed[i] = string(proto.CollapseBody(rs[i].data))
-// 	}
-// 	tail := rs[len(rs)-16:]
-// 	for i := 0; i < 16; i++ {
-// 		collapsed[i+16] = string(proto.CollapseBody(tail[i].data))
-// 	}
-
-// 	collapsedCount := len(rs) - 31
-// 	collapsed[15] = fmt.Sprintf("...collapsed %d...", collapsedCount)
-// 	return
-// }
+// collapseArray keep the head and tail arguments of long command, the middle is collapsed.
+func collapseArray(rs []*resp) (collapsed []string) {
+	if len(rs) <= maxArray {
+		collapsed = make([]string, len(rs), len(rs))
+		for i, r := range rs {
+			collapsed[i] = string(proto.CollapseBody(bulkPayload(r.data)))
+		}
+		return
+	}
+	collapsed = make([]string, maxArray, maxArray)
+	for i := 0; i < 15; i++ {
+		collapsed[i] = string(proto.CollapseBody(bulkPayload(rs[i].data)))
+	}
+	tail := rs[len(rs)-16:]
+	for i := 0; i < 16; i++ {
+		collapsed[i+16] = string(proto.CollapseBody(bulkPayload(tail[i].data)))
+	}
+
+	collapsedCount := len(rs) - 31
+	collapsed[15] = fmt.Sprintf("...collapsed %d...", collapsedCount)
+	return
+}
 
 var (
 	readCmds = []string{
@@ -282,8 +520,7 @@ var (
 		"4\r\nLLEN",
 		"6\r\nLRANGE",
 		"7\r\nPFCOUNT",
-		"4\r\nAUTH",   //支持auth
-		"6\r\nSELECT", //支持选库
+		"4\r\nSCAN",
 	}
 	writeCmds = []string{
 		"3\r\nDEL",
@@ -339,32 +576,28 @@ var (
 		"5\r\nPFADD",
 		"7\r\nPFMERGE",
 		"4\r\nEVAL",
+		"7\r\nEVALSHA",
+		"6\r\nSCRIPT",
 		"11\r\nSUNIONSTORE",
 		"11\r\nZUNIONSTORE",
-	}
-	notSupportCmds = []string{
+		"6\r\nUNLINK",
+		"5\r\nTOUCH",
 		"6\r\nMSETNX",
 		"10\r\nSDIFFSTORE",
 		"11\r\nSINTERSTORE",
-		"5\r\nBLPOP",
-		"5\r\nBRPOP",
-		"10\r\nBRPOPLPUSH",
-		"4\r\nKEYS",
+		"6\r\nRENAME",
+		"8\r\nRENAMENX",
+		"5\r\nBITOP",
+		"7\r\nPUBLISH",
+	}
+	notSupportCmds = []string{
 		"7\r\nMIGRATE",
 		"4\r\nMOVE",
 		"6\r\nOBJECT",
 		"9\r\nRANDOMKEY",
-		"6\r\nRENAME",
-		"8\r\nRENAMENX",
-		"4\r\nSCAN",
 		"4\r\nWAIT",
-		"5\r\nBITOP",
-		"7\r\nEVALSHA",
 		"4\r\nECHO",
-		"4\r\nINFO",
 		"5\r\nPROXY",
-		"7\r\nSLOWLOG",
-		"6\r\nSELECT",
 		"4\r\nTIME",
 		"6\r\nCONFIG",
 		"8\r\nCOMMANDS",
@@ -374,7 +607,27 @@ var (
 		"4\r\nPING",
 		"4\r\nQUIT",
 		"7\r\nCOMMAND",
+		"5\r\nHELLO",
+		"4\r\nKEYS", // NOTE: only forwarded when KEYS is enabled
+		"9\r\nSUBSCRIBE",
+		"10\r\nPSUBSCRIBE",
+		"11\r\nUNSUBSCRIBE",
+		"12\r\nPUNSUBSCRIBE",
+		"5\r\nMULTI",
+		"4\r\nEXEC",
+		"7\r\nDISCARD",
+		"5\r\nWATCH",
+		"7\r\nUNWATCH",
+		"5\r\nBLPOP", // NOTE: blocking commands use dedicated conns
+		"5\r\nBRPOP",
+		"10\r\nBRPOPLPUSH",
+		"6\r\nBLMOVE",
+		"6\r\nSELECT", // NOTE: the db is kept by proxy conn
+		"4\r\nAUTH",   // NOTE: authenticated by proxy, not forwarded
+		"4\r\nINFO",   // NOTE: answered by proxy with its own stats
+		"7\r\nSLOWLOG", // NOTE: answered by proxy with its own slowlog
+		"8\r\nREADONLY", // NOTE: the reads of READONLY client are served by replicas
+		"9\r\nREADWRITE",
 		//"8\r\nPIPELINE", //支持piple
-		// "4\r\nAUTH", 不拦截auth
 	}
 )
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"mycache/pkg/bufio"
+	"mycache/pkg/conv"
 	"mycache/pkg/mockconn"
 	libnet "mycache/pkg/net"
 	"testing"
@@ -40,3 +41,105 @@ func BenchmarkCmdTypeCheck(b *testing.B) {
 		req.IsSupport()
 	}
 }
+
+func TestRequestKeys(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Data   string
+		Key    string
+		Expect []string
+	}{
+		{Name: "msetnx", Data: "MSETNX a 1 b 2\r\n", Key: "a", Expect: []string{"a", "b"}},
+		{Name: "sinterstore", Data: "SINTERSTORE d a b\r\n", Key: "d", Expect: []string{"d", "a", "b"}},
+		{Name: "smove", Data: "SMOVE a b member\r\n", Key: "a", Expect: []string{"a", "b"}},
+		{Name: "bitop", Data: "BITOP AND d a b\r\n", Key: "d", Expect: []string{"d", "a", "b"}},
+		{Name: "zunionstore", Data: "ZUNIONSTORE d 2 a b WEIGHTS 1 2\r\n", Key: "d", Expect: []string{"d", "a", "b"}},
+		{Name: "zunionstoreBadNumkeys", Data: "ZUNIONSTORE d 3 a b\r\n", Key: "d", Expect: []string{"d"}},
+		{Name: "get", Data: "GET a\r\n", Key: "a", Expect: nil},
+		{Name: "eval", Data: "EVAL script 2 a b arg\r\n", Key: "a", Expect: []string{"a", "b"}},
+		{Name: "evalsha", Data: "EVALSHA sha 1 a arg\r\n", Key: "a", Expect: []string{"a"}},
+		{Name: "evalNoKeys", Data: "EVAL script 0 arg\r\n", Key: "", Expect: nil},
+		{Name: "evalBadNumkeys", Data: "EVAL script 3 a\r\n", Key: "", Expect: nil},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := libnet.NewConn(mockconn.CreateMockConn([]byte(tt.Data), 1), time.Second, time.Second)
+			br := bufio.NewReader(conn, bufio.Get(1024))
+			br.Read()
+			req := getReq()
+			assert.NoError(t, req.resp.decode(br))
+			conv.UpdateToUpper(req.resp.array[0].data)
+			assert.True(t, req.IsSupport())
+			assert.Equal(t, tt.Key, string(req.Key()))
+			var keys []string
+			for _, key := range req.Keys() {
+				keys = append(keys, string(key))
+			}
+			assert.Equal(t, tt.Expect, keys)
+		})
+	}
+}
+
+func TestRequestIsBroadcast(t *testing.T) {
+	ts := []struct {
+		Data   string
+		Expect bool
+	}{
+		{Data: "SCRIPT LOAD return\r\n", Expect: true},
+		{Data: "script exists a b\r\n", Expect: true},
+		{Data: "SCRIPT flush\r\n", Expect: true},
+		{Data: "SCRIPT KILL\r\n", Expect: false},
+		{Data: "GET a\r\n", Expect: false},
+	}
+	for _, tt := range ts {
+		conn := libnet.NewConn(mockconn.CreateMockConn([]byte(tt.Data), 1), time.Second, time.Second)
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		conv.UpdateToUpper(req.resp.array[0].data)
+		assert.Equal(t, tt.Expect, req.IsBroadcast(), tt.Data)
+	}
+}
+
+func TestRequestScanCursor(t *testing.T) {
+	ts := []struct {
+		Cursor  string
+		Idx     int
+		Backend string
+	}{
+		{Cursor: "0", Idx: 0, Backend: "1\r\n0"},
+		{Cursor: "2", Idx: 2, Backend: "1\r\n0"},
+		{Cursor: "17409", Idx: 1, Backend: "2\r\n17"}, // NOTE: 17<<10|1
+		{Cursor: "bad", Idx: -1, Backend: "3\r\nbad"},
+	}
+	for _, tt := range ts {
+		conn := libnet.NewConn(mockconn.CreateMockConn([]byte("SCAN "+tt.Cursor+" MATCH a* COUNT 10\r\n"), 1), time.Second, time.Second)
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		req.parseScanCursor()
+		assert.True(t, req.IsCursor())
+		assert.Equal(t, tt.Idx, req.NodeIndex())
+		assert.Equal(t, tt.Backend, string(req.resp.array[1].data))
+		assert.Equal(t, 6, req.resp.arraySize)
+	}
+
+	req := getReq()
+	req.scanIdx = 1
+	req.WithNodes(3)
+	next := func(bc uint64) uint64 {
+		cursor, err := req.nextScanCursor(bc)
+		assert.NoError(t, err)
+		return cursor
+	}
+	assert.Equal(t, uint64(17<<10|1), next(17))
+	assert.Equal(t, uint64(2), next(0))
+	req.scanIdx = 2
+	assert.Equal(t, uint64(0), next(0))
+	assert.Equal(t, uint64(1<<54-1)<<10|2, next(1<<54-1))
+	// NOTE: the cursor above 2^54 overflows
+	_, err := req.nextScanCursor(1 << 54)
+	assert.Equal(t, ErrScanCursorOverflow, err)
+}
@@ -24,6 +24,16 @@ const (
 	respInt     respType = ':' //resp数值数据
 	respBulk    respType = '$' //resp多行字符串数据(包含回车换行的字符串)
 	respArray   respType = '*' //RESP数组数据
+
+	// RESP3 types
+	respMap      respType = '%' //RESP3字典，元素个数为键值对数的两倍
+	respSet      respType = '~' //RESP3集合
+	respDouble   respType = ',' //RESP3浮点数
+	respBool     respType = '#' //RESP3布尔值 t|f
+	respNull     respType = '_' //RESP3空值
+	respBigNum   respType = '(' //RESP3大整数
+	respVerbatim respType = '=' //RESP3带格式的多行字符串 txt:xxx
+	respPush     respType = '>' //RESP3推送数据
 )
 
 //定义resp的基础数据的字节流
@@ -34,6 +44,15 @@ var (
 	respBulkBytes   = []byte("$")
 	respArrayBytes  = []byte("*")
 
+	respMapBytes      = []byte("%")
+	respSetBytes      = []byte("~")
+	respDoubleBytes   = []byte(",")
+	respBoolBytes     = []byte("#")
+	respBigNumBytes   = []byte("(")
+	respVerbatimBytes = []byte("=")
+	respPushBytes     = []byte(">")
+	respNullBytes     = []byte("_\r\n")
+
 	nullDataBytes = []byte("-1")
 )
 
@@ -55,6 +74,13 @@ func (r *RESP) Array() []*RESP {
 	return r.array[:r.arraySize]
 }
 
+// WithError reset resp as error with the message of err.
+func (r *RESP) WithError(err error) {
+	r.reset()
+	r.respType = respError
+	r.data = append(r.data, err.Error()...)
+}
+
 // Decode decode by Reader.
 func (r *RESP) Decode(br *bufio.Reader) (err error) {
 	return r.decode(br)
@@ -117,13 +143,16 @@ func (r *resp) decode(br *bufio.Reader) (err error) {
 	respType := line[0] //首字节数值为数据类型标识位，42|51|36这些
 	r.respType = respType
 	switch respType { //按resp的编码协议去拆解这些二进制-ASCII数值数据
-	case respString, respInt, respError:
+	case respString, respInt, respError, respDouble, respBool, respBigNum:
 		//如果是简单的字符数据或数值数据，则就地解码成对象
 		r.data = append(r.data, line[1:len(line)-2]...)
-	case respBulk: //如果是复杂的数据类型 则使用对应的解码方法去反序列化数据
+	case respNull:
+	case respBulk, respVerbatim: //如果是复杂的数据类型 则使用对应的解码方法去反序列化数据
 		err = r.decodeBulk(line, br)
-	case respArray:
-		err = r.decodeArray(line, br)
+	case respArray, respSet, respPush:
+		err = r.decodeArray(line, br, 1)
+	case respMap:
+		err = r.decodeArray(line, br, 2)
 	default:
 		err = r.decodeInline(line)
 	}
@@ -176,7 +205,8 @@ func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
 	return
 }
 
-func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
+// decodeArray decode the aggregate types, every element of map is a key-value pair so the factor is 2.
+func (r *resp) decodeArray(line []byte, br *bufio.Reader, factor int) (err error) {
 	ls := len(line)
 	arrayLengthBytes := line[1 : ls-2] //截取数据位（去除 头标识位和尾回车换行位）
 	arrayLength, err := conv.Btoi(arrayLengthBytes)
@@ -189,7 +219,7 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 	}
 	r.data = append(r.data, arrayLengthBytes...)
 	mark := br.Mark()
-	for i := 0; i < int(arrayLength); i++ {
+	for i := 0; i < int(arrayLength)*factor; i++ {
 		nre := r.next()
 		if err = nre.decode(br); err != nil {
 			br.AdvanceTo(mark)
@@ -201,11 +231,13 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 }
 func (r *resp) encode(w *bufio.Writer) (err error) {
 	switch r.respType {
-	case respInt, respString, respError:
+	case respInt, respString, respError, respDouble, respBool, respBigNum:
 		err = r.encodePlain(w)
-	case respBulk:
+	case respNull:
+		err = w.Write(respNullBytes)
+	case respBulk, respVerbatim:
 		err = r.encodeBulk(w)
-	case respArray:
+	case respArray, respMap, respSet, respPush:
 		err = r.encodeArray(w)
 	}
 	return
@@ -219,6 +251,12 @@ func (r *resp) encodePlain(w *bufio.Writer) (err error) {
 		_ = w.Write(respErrorBytes)
 	case respString:
 		_ = w.Write(respStringBytes)
+	case respDouble:
+		_ = w.Write(respDoubleBytes)
+	case respBool:
+		_ = w.Write(respBoolBytes)
+	case respBigNum:
+		_ = w.Write(respBigNumBytes)
 	}
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
@@ -228,7 +266,11 @@ func (r *resp) encodePlain(w *bufio.Writer) (err error) {
 }
 
 func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
-	_ = w.Write(respBulkBytes)
+	if r.respType == respVerbatim {
+		_ = w.Write(respVerbatimBytes)
+	} else {
+		_ = w.Write(respBulkBytes)
+	}
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
@@ -239,7 +281,16 @@ func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
 }
 
 func (r *resp) encodeArray(w *bufio.Writer) (err error) {
-	_ = w.Write(respArrayBytes)
+	switch r.respType {
+	case respMap:
+		_ = w.Write(respMapBytes)
+	case respSet:
+		_ = w.Write(respSetBytes)
+	case respPush:
+		_ = w.Write(respPushBytes)
+	default:
+		_ = w.Write(respArrayBytes)
+	}
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
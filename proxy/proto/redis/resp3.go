@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+
+	"mycache/pkg/bufio"
+	"mycache/pkg/conv"
+)
+
+// protocol versions which can be selected by HELLO.
+const (
+	protoRESP2 = 2
+	protoRESP3 = 3
+)
+
+// replyShape 后端总是以RESP2回复，shape描述命令回复在RESP3下的形态
+type replyShape = uint8
+
+// reply shapes
+const (
+	shapeNone replyShape = iota
+	shapeMap
+	shapeSet
+	shapeDouble
+	shapeScorePairs // NOTE: ZRANGE ... WITHSCORES, every member is followed by its score
+)
+
+var (
+	withScoresBytes = []byte("WITHSCORES")
+
+	resp3Shapes = map[string]replyShape{
+		"7\r\nHGETALL":       shapeMap,
+		"8\r\nSMEMBERS":      shapeSet,
+		"5\r\nSDIFF":         shapeSet,
+		"6\r\nSINTER":        shapeSet,
+		"6\r\nSUNION":        shapeSet,
+		"6\r\nZSCORE":        shapeDouble,
+		"7\r\nZINCRBY":       shapeDouble,
+		"11\r\nINCRBYFLOAT":  shapeDouble,
+		"12\r\nHINCRBYFLOAT": shapeDouble,
+	}
+	resp3ScoreCmds = map[string]struct{}{
+		"6\r\nZRANGE":            {},
+		"9\r\nZREVRANGE":         {},
+		"13\r\nZRANGEBYSCORE":    {},
+		"16\r\nZREVRANGEBYSCORE": {},
+	}
+)
+
+// shapeOf returns the RESP3 reply shape of the request.
+func shapeOf(r *resp) replyShape {
+	if r.arraySize < 1 {
+		return shapeNone
+	}
+	cmd := string(r.array[0].data)
+	if shape, ok := resp3Shapes[cmd]; ok {
+		return shape
+	}
+	if _, ok := resp3ScoreCmds[cmd]; ok {
+		for _, arg := range r.array[1:r.arraySize] {
+			if bytes.EqualFold(bulkPayload(arg.data), withScoresBytes) {
+				return shapeScorePairs
+			}
+		}
+	}
+	return shapeNone
+}
+
+// bulkPayload strip the length prefix of bulk data.
+func bulkPayload(data []byte) []byte {
+	if idx := bytes.Index(data, crlfBytes); idx != -1 {
+		return data[idx+2:]
+	}
+	return data
+}
+
+// encode3 encodes the RESP2 reply as RESP3 by the given shape.
+func (r *resp) encode3(w *bufio.Writer, shape replyShape) (err error) {
+	switch r.respType {
+	case respBulk:
+		if len(r.data) == 0 {
+			return w.Write(respNullBytes)
+		}
+		if shape == shapeDouble {
+			_ = w.Write(respDoubleBytes)
+			_ = w.Write(bulkPayload(r.data))
+			return w.Write(crlfBytes)
+		}
+		return r.encodeBulk(w)
+	case respArray:
+		if len(r.data) == 0 {
+			return w.Write(respNullBytes)
+		}
+		return r.encodeArray3(w, shape)
+	}
+	return r.encode(w)
+}
+
+func (r *resp) encodeArray3(w *bufio.Writer, shape replyShape) (err error) {
+	switch {
+	case shape == shapeMap && r.arraySize%2 == 0:
+		_ = w.Write(respMapBytes)
+		_ = w.Write([]byte(strconv.Itoa(r.arraySize / 2)))
+	case shape == shapeSet:
+		_ = w.Write(respSetBytes)
+		_ = w.Write(r.data)
+	case shape == shapeScorePairs && r.arraySize%2 == 0:
+		_ = w.Write(respArrayBytes)
+		_ = w.Write([]byte(strconv.Itoa(r.arraySize / 2)))
+		_ = w.Write(crlfBytes)
+		for i := 0; i < r.arraySize; i += 2 {
+			_ = w.Write(respArrayBytes)
+			_ = w.Write(arrayLenTwo)
+			_ = w.Write(crlfBytes)
+			if err = r.array[i].encode3(w, shapeNone); err != nil {
+				return
+			}
+			if err = r.array[i+1].encode3(w, shapeDouble); err != nil {
+				return
+			}
+		}
+		return
+	default:
+		_ = w.Write(respArrayBytes)
+		_ = w.Write(r.data)
+	}
+	_ = w.Write(crlfBytes)
+	for i := 0; i < r.arraySize; i++ {
+		if err = r.array[i].encode3(w, shapeNone); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseProtover parse the protocol version argument of HELLO.
+func parseProtover(data []byte) (int, bool) {
+	ver, err := conv.Btoi(bulkPayload(data))
+	if err != nil {
+		return 0, false
+	}
+	return int(ver), true
+}
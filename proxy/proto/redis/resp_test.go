@@ -253,3 +253,36 @@ func TestRespEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestRespRESP3RoundTrip(t *testing.T) {
+	ts := []struct {
+		Name     string
+		Bytes    string
+		ExpectTp byte
+	}{
+		{Name: "map", Bytes: "%2\r\n+a\r\n:1\r\n+b\r\n_\r\n", ExpectTp: respMap},
+		{Name: "set", Bytes: "~2\r\n$1\r\na\r\n#t\r\n", ExpectTp: respSet},
+		{Name: "double", Bytes: ",3.14\r\n", ExpectTp: respDouble},
+		{Name: "bool", Bytes: "#f\r\n", ExpectTp: respBool},
+		{Name: "null", Bytes: "_\r\n", ExpectTp: respNull},
+		{Name: "bignum", Bytes: "(3492890328409238509324850943850943825024385\r\n", ExpectTp: respBigNum},
+		{Name: "verbatim", Bytes: "=15\r\ntxt:Some string\r\n", ExpectTp: respVerbatim},
+		{Name: "push", Bytes: ">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n", ExpectTp: respPush},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := libnet.NewConn(mockconn.CreateMockConn([]byte(tt.Bytes), 1), time.Second, time.Second)
+			br := bufio.NewReader(conn, bufio.Get(1024))
+			br.Read()
+			r := &resp{}
+			assert.NoError(t, r.decode(br))
+			assert.Equal(t, tt.ExpectTp, r.respType)
+
+			mc, buf := mockconn.CreateMockDownStremConn()
+			w := bufio.NewWriter(libnet.NewConn(mc, time.Second, time.Second))
+			assert.NoError(t, r.encode(w))
+			assert.NoError(t, w.Flush())
+			assert.Equal(t, tt.Bytes, buf.String())
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"strconv"
+
+	"mycache/proxy/proto"
+)
+
+// defaultDatabases the number of databases of redis by default.
+const defaultDatabases = 16
+
+var (
+	cmdSelectBytes = []byte("6\r\nSELECT")
+
+	selectOutOfRangeBytes = []byte("-ERR DB index is out of range\r\n")
+	selectNotIntBytes     = []byte("-ERR value is not an integer or out of range\r\n")
+	selectInClusterBytes  = []byte("-ERR SELECT is not allowed in cluster mode\r\n")
+)
+
+// SetDatabases set the number of databases that clients can SELECT, it's 16 by default like redis.
+func (pc *proxyConn) SetDatabases(databases int) {
+	pc.databases = databases
+}
+
+// selectDB handle SELECT, the db is kept on the client conn and every request carries it.
+// NOTE: the shared node conns switch db before writing the request when needed, see nodeConn.Write.
+func (pc *proxyConn) selectDB(req *Request) (err error) {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	if req.resp.arraySize != 2 {
+		return pc.bw.Write([]byte("-ERR wrong number of arguments for 'select' command\r\n"))
+	}
+	db, err := strconv.Atoi(string(bulkPayload(req.resp.array[1].data)))
+	if err != nil {
+		return pc.bw.Write(selectNotIntBytes)
+	}
+	if db < 0 || db >= pc.databases {
+		return pc.bw.Write(selectOutOfRangeBytes)
+	}
+	if pc.mode == "cluster" && db != 0 {
+		return pc.bw.Write(selectInClusterBytes)
+	}
+	pc.db = db
+	return pc.bw.Write(justOkBytes)
+}
+
+// markDB mark all requests of message with the selected db.
+func (pc *proxyConn) markDB(m *proto.Message) {
+	for _, r := range m.Requests() {
+		if req, ok := r.(*Request); ok {
+			req.db = pc.db
+		}
+	}
+}
+
+// selectResp build the request: SELECT db.
+func selectResp(db int) *resp {
+	arg := strconv.Itoa(db)
+	r := &resp{respType: respArray, data: []byte("2")}
+	r.array = []*resp{
+		{respType: respBulk, data: cmdSelectBytes},
+		{respType: respBulk, data: []byte(strconv.Itoa(len(arg)) + "\r\n" + arg)},
+	}
+	r.arraySize = 2
+	return r
+}
@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectDB(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("SELECT 2\r\nSELECT x\r\nSELECT 16\r\nSELECT\r\nMGET a b\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetNodeAuth(&Auth{DB: 1})
+	assert.Equal(t, 1, pc.db)
+	for i := 0; i < 4; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 1)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n"+string(selectNotIntBytes)+string(selectOutOfRangeBytes)+
+		"-ERR wrong number of arguments for 'select' command\r\n", mc.Wbuf.String())
+	assert.Equal(t, 2, pc.db)
+
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	for _, req := range msgs[0].Requests() {
+		assert.Equal(t, 2, req.(*Request).db)
+	}
+}
+
+func TestSelectDBInCluster(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("SELECT 1\r\nSELECT 0\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetMode("cluster")
+	for i := 0; i < 2; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		_, err = pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(selectInClusterBytes)+"+OK\r\n", mc.Wbuf.String())
+}
+
+func TestSelectNodeConn(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("+OK\r\n$1\r\nv\r\n$1\r\nw\r\n-ERR DB index is out of range\r\n$-1\r\n"), 1).(*mockconn.MockConn)
+	nc := newNodeConn("baka", "127.0.0.1:12345", libnet.NewConn(mc, time.Second, time.Second)).(*nodeConn)
+
+	write := func(db int) *Request {
+		req := newRequest("GET", "a")
+		req.resp.respType = respArray
+		req.db = db
+		msg := proto.NewMessage()
+		msg.WithRequest(req)
+		assert.NoError(t, nc.Write(msg))
+		assert.NoError(t, nc.Flush())
+		assert.NoError(t, nc.Read(msg))
+		return req
+	}
+	// NOTE: switch db only when the db of request differs
+	assert.Equal(t, "1\r\nv", string(write(2).reply.data))
+	assert.Equal(t, "1\r\nw", string(write(2).reply.data))
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n2\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n", mc.Wbuf.String())
+
+	req := write(99)
+	assert.Equal(t, respError, req.reply.respType)
+	assert.Equal(t, -1, nc.db)
+}
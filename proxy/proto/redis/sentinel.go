@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"net"
+	"strings"
+	"time"
+
+	"mycache/pkg/bufio"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sentinelBufferSize = 512
+)
+
+// errors
+var (
+	ErrSentinelNoMaster = errs.New("sentinel has no such master")
+	ErrSentinelBadReply = errs.New("sentinel reply is bad")
+)
+
+var (
+	switchMasterChannel = "+switch-master"
+	messageBytes        = []byte("7\r\nmessage")
+)
+
+// SentinelConn the conn to sentinel, which resolves the address of master and receives the switches of master.
+type SentinelConn struct {
+	conn *libnet.Conn
+
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+// NewSentinelConn new sentinel conn.
+func NewSentinelConn(conn *libnet.Conn) *SentinelConn {
+	return &SentinelConn{
+		conn: conn,
+		br:   bufio.NewReader(conn, bufio.NewBuffer(sentinelBufferSize)),
+		bw:   bufio.NewWriter(conn),
+	}
+}
+
+// MasterAddr returns the address of master by SENTINEL get-master-addr-by-name.
+func (s *SentinelConn) MasterAddr(name string) (addr string, err error) {
+	if err = writeCommand(s.bw, "SENTINEL", "get-master-addr-by-name", name); err != nil {
+		return
+	}
+	reply := &resp{}
+	if err = readReply(s.br, reply); err != nil {
+		return
+	}
+	if reply.respType != respArray {
+		err = errors.Wrapf(ErrSentinelBadReply, "reply:%s", reply.data)
+		return
+	}
+	// NOTE: the null array is replied for unknown master
+	if reply.arraySize == 0 {
+		err = errors.Wrapf(ErrSentinelNoMaster, "master:%s", name)
+		return
+	}
+	if reply.arraySize != 2 {
+		err = errors.Wrapf(ErrSentinelBadReply, "master:%s", name)
+		return
+	}
+	addr = net.JoinHostPort(string(bulkPayload(reply.array[0].data)), string(bulkPayload(reply.array[1].data)))
+	return
+}
+
+// SubscribeSwitch subscribe +switch-master, the read timeout is disabled to wait for the switches.
+func (s *SentinelConn) SubscribeSwitch() (err error) {
+	if err = writeCommand(s.bw, "SUBSCRIBE", switchMasterChannel); err != nil {
+		return
+	}
+	reply := &resp{}
+	if err = readReply(s.br, reply); err != nil {
+		return
+	}
+	if reply.respType == respError {
+		return errors.Wrapf(ErrSentinelBadReply, "reply:%s", reply.data)
+	}
+	// NOTE: the deadline of the last read is cleared, or the conn times out while waiting
+	s.conn.SetReadTimeout(0)
+	return s.conn.SetReadDeadline(time.Time{})
+}
+
+// ReceiveSwitch wait for the next switch, returns the name and the new address of master.
+func (s *SentinelConn) ReceiveSwitch() (name, addr string, err error) {
+	for {
+		reply := &resp{}
+		if err = readReply(s.br, reply); err != nil {
+			return
+		}
+		// NOTE: the payload is "<name> <old ip> <old port> <new ip> <new port>"
+		if reply.respType != respArray || reply.arraySize != 3 || !bytes.Equal(reply.array[0].data, messageBytes) ||
+			string(bulkPayload(reply.array[1].data)) != switchMasterChannel {
+			continue
+		}
+		fields := strings.Fields(string(bulkPayload(reply.array[2].data)))
+		if len(fields) != 5 {
+			err = errors.Wrapf(ErrSentinelBadReply, "switch-master:%s", bulkPayload(reply.array[2].data))
+			return
+		}
+		return fields[0], net.JoinHostPort(fields[3], fields[4]), nil
+	}
+}
+
+// Close close the socket, the ReceiveSwitch blocked by other goroutine returns error.
+func (s *SentinelConn) Close() error {
+	if s.conn.Conn == nil {
+		return libnet.ErrConnClosed
+	}
+	return s.conn.Conn.Close()
+}
@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentinelConn(t *testing.T) {
+	payload := "mymaster 127.0.0.1 6380 127.0.0.1 6381"
+	data := "*2\r\n$9\r\n127.0.0.1\r\n$4\r\n6380\r\n" + "*-1\r\n" + "-ERR unknown command\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$14\r\n+switch-master\r\n:1\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$12\r\n+sdown-slave\r\n$3\r\nabc\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$38\r\n" + payload + "\r\n"
+	mc := mockconn.CreateMockConn([]byte(data), 1).(*mockconn.MockConn)
+	sc := NewSentinelConn(libnet.NewConn(mc, time.Second, time.Second))
+
+	addr, err := sc.MasterAddr("mymaster")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:6380", addr)
+	_, err = sc.MasterAddr("other")
+	assert.Equal(t, ErrSentinelNoMaster, errors.Cause(err))
+	_, err = sc.MasterAddr("mymaster")
+	assert.Equal(t, ErrSentinelBadReply, errors.Cause(err))
+	assert.Equal(t, "*3\r\n$8\r\nSENTINEL\r\n$23\r\nget-master-addr-by-name\r\n$8\r\nmymaster\r\n", mc.Wbuf.String()[:62])
+
+	mc.Wbuf.Reset()
+	assert.NoError(t, sc.SubscribeSwitch())
+	assert.Equal(t, "*2\r\n$9\r\nSUBSCRIBE\r\n$14\r\n+switch-master\r\n", mc.Wbuf.String())
+	// NOTE: the messages of other channels are skipped
+	name, addr, err := sc.ReceiveSwitch()
+	assert.NoError(t, err)
+	assert.Equal(t, "mymaster", name)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+}
@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"mycache/proxy/proto"
+)
+
+// slowlogDefaultGet SLOWLOG GET不带count时返回的条数
+const slowlogDefaultGet = 10
+
+var (
+	cmdSlowlogBytes = []byte("7\r\nSLOWLOG")
+)
+
+// Slowlogs is the in-memory slowlog of cluster answered by SLOWLOG.
+type Slowlogs interface {
+	Get(n int) []*proto.SlowlogEntry
+	Len() int
+	Reset()
+}
+
+// SetSlowlog set the slowlog of cluster, SLOWLOG is not supported if it is nil.
+func (pc *proxyConn) SetSlowlog(slowlogs Slowlogs) {
+	pc.slowlogs = slowlogs
+}
+
+// slowlogCheck handle SLOWLOG GET [count], SLOWLOG LEN and SLOWLOG RESET by proxy.
+func (pc *proxyConn) slowlogCheck(req *Request) (err error) {
+	if !pc.authorized {
+		return pc.bw.Write(noAuthBytes)
+	}
+	if pc.slowlogs == nil {
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR unknown command `%s`, with args beginning with:\r\n", req.CmdString())))
+	}
+	if req.resp.arraySize < 2 {
+		return pc.bw.Write([]byte("-ERR wrong number of arguments for 'slowlog' command\r\n"))
+	}
+	args := req.resp.array[1:req.resp.arraySize]
+	sub := string(bytes.ToUpper(bulkPayload(args[0].data)))
+	switch {
+	case sub == "GET" && len(args) <= 2:
+		n := slowlogDefaultGet
+		if len(args) == 2 {
+			if n, err = strconv.Atoi(string(bulkPayload(args[1].data))); err != nil || n < -1 {
+				return pc.bw.Write([]byte("-ERR count should be greater than or equal to -1\r\n"))
+			}
+		}
+		return pc.bw.Write(slowlogReply(pc.slowlogs.Get(n)))
+	case sub == "LEN" && len(args) == 1:
+		return pc.bw.Write([]byte(fmt.Sprintf(":%d\r\n", pc.slowlogs.Len())))
+	case sub == "RESET" && len(args) == 1:
+		pc.slowlogs.Reset()
+		return pc.bw.Write(justOkBytes)
+	}
+	return pc.bw.Write([]byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.\r\n", bulkPayload(args[0].data))))
+}
+
+// slowlogReply encode the entries like redis: id, timestamp, microseconds, arguments, client address and client name.
+func slowlogReply(es []*proto.SlowlogEntry) []byte {
+	var buf bytes.Buffer
+	bulk := func(s string) {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(s), s)
+	}
+	fmt.Fprintf(&buf, "*%d\r\n", len(es))
+	for _, e := range es {
+		fmt.Fprintf(&buf, "*6\r\n:%d\r\n:%d\r\n:%d\r\n*%d\r\n", e.ID, e.StartTime.Unix(), int64(e.TotalDur/time.Microsecond), len(e.Cmd))
+		for _, arg := range e.Cmd {
+			bulk(arg)
+		}
+		bulk(e.Client)
+		bulk("")
+	}
+	return buf.Bytes()
+}
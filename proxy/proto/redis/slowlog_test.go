@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _slowlogs struct {
+	es []*proto.SlowlogEntry
+}
+
+func (s *_slowlogs) Get(n int) []*proto.SlowlogEntry {
+	if n < 0 || n > len(s.es) {
+		n = len(s.es)
+	}
+	return s.es[:n]
+}
+func (s *_slowlogs) Len() int { return len(s.es) }
+func (s *_slowlogs) Reset()   { s.es = nil }
+
+func TestSlowlogCmd(t *testing.T) {
+	data := "SLOWLOG GET\r\nSLOWLOG LEN\r\nSLOWLOG GET x\r\nSLOWLOG RESET\r\nSLOWLOG LEN\r\nSLOWLOG FOO\r\n"
+	mc := mockconn.CreateMockConn([]byte(data), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetSlowlog(&_slowlogs{es: []*proto.SlowlogEntry{{ID: 1, StartTime: time.Unix(100, 0), TotalDur: 15 * time.Millisecond, Cmd: []string{"GET", "a"}, Client: "127.0.0.1:1"}}})
+	for i := 0; i < 6; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		special, err := pc.CmdCheck(msgs[0])
+		assert.NoError(t, err)
+		assert.True(t, special)
+	}
+	assert.NoError(t, pc.Flush())
+	replies := []string{
+		"*1\r\n*6\r\n:1\r\n:100\r\n:15000\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n$11\r\n127.0.0.1:1\r\n$0\r\n\r\n",
+		":1\r\n",
+		"-ERR count should be greater than or equal to -1\r\n",
+		"+OK\r\n",
+		":0\r\n",
+		"-ERR unknown subcommand or wrong number of arguments for 'FOO'. Try SLOWLOG HELP.\r\n",
+	}
+	assert.Equal(t, strings.Join(replies, ""), mc.Wbuf.String())
+}
+
+func TestRequestSlowlog(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = "k"
+	}
+	req := newRequest("DEL", args...)
+	req.resp.respType = respArray
+	slog := req.Slowlog()
+	assert.Len(t, slog.Cmd, maxArray)
+	assert.Equal(t, "DEL", slog.Cmd[0])
+	assert.Equal(t, "...collapsed 10...", slog.Cmd[15])
+}
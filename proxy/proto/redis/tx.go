@@ -0,0 +1,206 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"fmt"
+	"time"
+)
+
+var (
+	cmdMultiBytes   = []byte("5\r\nMULTI")
+	cmdExecBytes    = []byte("4\r\nEXEC")
+	cmdDiscardBytes = []byte("7\r\nDISCARD")
+	cmdWatchBytes   = []byte("5\r\nWATCH")
+	cmdUnwatchBytes = []byte("7\r\nUNWATCH")
+
+	queuedBytes             = []byte("+QUEUED\r\n")
+	multiNestedBytes        = []byte("-ERR MULTI calls can not be nested\r\n")
+	execNoMultiBytes        = []byte("-ERR EXEC without MULTI\r\n")
+	discardNoMultiBytes     = []byte("-ERR DISCARD without MULTI\r\n")
+	watchInMultiBytes       = []byte("-ERR WATCH inside MULTI is not allowed\r\n")
+	crossNodeBytes          = []byte("-CROSSSLOT Keys in request don't hash to the same node\r\n")
+	execAbortBytes          = []byte("-EXECABORT Transaction discarded because of previous errors.\r\n")
+	execAbortCrossNodeBytes = []byte("-EXECABORT Transaction discarded because keys don't hash to the same node\r\n")
+
+	// NOTE: *1\r\n$5\r\nMULTI\r\n
+	multiResp = &resp{respType: respArray, data: []byte("1"), array: []*resp{{respType: respBulk, data: cmdMultiBytes}}, arraySize: 1}
+)
+
+// errors
+var (
+	ErrKeyNoNode = errs.New("ERR key no hit node")
+)
+
+// isTxCmd check if the request is MULTI/EXEC/DISCARD/WATCH/UNWATCH.
+func isTxCmd(req *Request) bool {
+	cmd := req.resp.array[0].data
+	return bytes.Equal(cmd, cmdMultiBytes) || bytes.Equal(cmd, cmdExecBytes) || bytes.Equal(cmd, cmdDiscardBytes) ||
+		bytes.Equal(cmd, cmdWatchBytes) || bytes.Equal(cmd, cmdUnwatchBytes)
+}
+
+// txKeys returns all keys of the queued commands.
+func txKeys(cmds []*resp) (keys [][]byte) {
+	for _, cmd := range cmds {
+		if _, ok := multiKeyCmds[string(cmd.array[0].data)]; ok {
+			keys = append(keys, respKeys(cmd)...)
+			continue
+		}
+		if cmd.arraySize > 1 && !bytes.Equal(cmd.array[0].data, cmdPingBytes) {
+			keys = append(keys, bulkPayload(cmd.array[1].data))
+		}
+	}
+	return
+}
+
+// SetTx set the locator of key and the timeouts of the dedicated backend conn for WATCH.
+func (pc *proxyConn) SetTx(locate NodeLocator, dialTimeout, readTimeout, writeTimeout time.Duration) {
+	pc.nodeLocate = locate
+	pc.dto, pc.rto, pc.wto = dialTimeout, readTimeout, writeTimeout
+}
+
+// txCheck handle the commands of transaction, the commands after MULTI are queued until EXEC.
+// NOTE: pc.lock must be held.
+func (pc *proxyConn) txCheck(req *Request) (isSpecialCmd bool, err error) {
+	isSpecialCmd = true
+	if !pc.authorized {
+		err = pc.bw.Write(noAuthBytes)
+		return
+	}
+	cmd := req.resp.array[0].data
+	switch {
+	case bytes.Equal(cmd, cmdMultiBytes):
+		if pc.multi {
+			err = pc.bw.Write(multiNestedBytes)
+			return
+		}
+		pc.multi = true
+		err = pc.bw.Write(justOkBytes)
+		return
+	case bytes.Equal(cmd, cmdExecBytes):
+		if !pc.multi {
+			err = pc.bw.Write(execNoMultiBytes)
+			return
+		}
+		return pc.exec(req)
+	case bytes.Equal(cmd, cmdDiscardBytes):
+		if !pc.multi {
+			err = pc.bw.Write(discardNoMultiBytes)
+			return
+		}
+		pc.resetTx()
+		err = pc.bw.Write(justOkBytes)
+		return
+	case bytes.Equal(cmd, cmdWatchBytes):
+		if pc.multi {
+			err = pc.bw.Write(watchInMultiBytes)
+			return
+		}
+		err = pc.watchKeys(req)
+		return
+	case bytes.Equal(cmd, cmdUnwatchBytes) && !pc.multi:
+		pc.closeWatch()
+		err = pc.bw.Write(justOkBytes)
+		return
+	}
+	// 事务中的命令先缓存，EXEC时一起发送
+	// NOTE: blocking commands never block in transaction
+	if !req.IsSupport() || (req.IsSpecial() && !bytes.Equal(cmd, cmdPingBytes) && !bytes.Equal(cmd, cmdUnwatchBytes) && !isBlocking(req)) {
+		pc.txAborted = true
+		err = pc.bw.Write([]byte(fmt.Sprintf("-ERR command '%s' is not allowed in transaction\r\n", bytes.ToLower(req.Cmd()))))
+		return
+	}
+	queued := &resp{}
+	queued.copy(req.resp)
+	pc.tx = append(pc.tx, queued)
+	err = pc.bw.Write(queuedBytes)
+	return
+}
+
+// exec send the queued commands as a block: MULTI ... EXEC.
+// Without WATCH the EXEC request carrying the block is forwarded like others, see nodeConn.Write,
+// otherwise the block is sent on the dedicated conn of WATCH.
+func (pc *proxyConn) exec(req *Request) (isSpecialCmd bool, err error) {
+	cmds, aborted, watch := pc.tx, pc.txAborted, pc.watch
+	pc.multi, pc.tx, pc.txAborted, pc.watch = false, nil, false, nil
+	if watch != nil {
+		defer watch.Close() // NOTE: EXEC unwatch all keys
+	}
+	isSpecialCmd = true
+	if aborted {
+		err = pc.bw.Write(execAbortBytes)
+		return
+	}
+	if watch == nil {
+		req.tx = cmds
+		isSpecialCmd = false
+		return
+	}
+	for _, key := range txKeys(cmds) {
+		if addr, ok := pc.nodeLocate(key); !ok || addr != watch.addr {
+			err = pc.bw.Write(execAbortCrossNodeBytes)
+			return
+		}
+	}
+	reqs := append(append([]*resp{multiResp}, cmds...), req.resp)
+	reply := &resp{}
+	if derr := watch.do(pc.db, reqs, reply); derr != nil {
+		err = pc.bw.Write([]byte(fmt.Sprintf("-ERR EXEC failed on node %s\r\n", watch.addr)))
+		return
+	}
+	err = pc.encodeReply(reply, shapeNone)
+	return
+}
+
+// watchKeys send WATCH on the dedicated conn, all watched keys must be on the same node.
+func (pc *proxyConn) watchKeys(req *Request) (err error) {
+	if req.resp.arraySize < 2 {
+		return pc.bw.Write([]byte("-ERR wrong number of arguments for 'watch' command\r\n"))
+	}
+	if pc.nodeLocate == nil {
+		pc.writeErr(ErrKeyNoNode)
+		return
+	}
+	var addr string
+	if pc.watch != nil {
+		addr = pc.watch.addr
+	}
+	for _, arg := range req.resp.array[1:req.resp.arraySize] {
+		node, ok := pc.nodeLocate(bulkPayload(arg.data))
+		if !ok {
+			pc.writeErr(ErrKeyNoNode)
+			return
+		}
+		if addr == "" {
+			addr = node
+		} else if node != addr {
+			return pc.bw.Write(crossNodeBytes)
+		}
+	}
+	if pc.watch == nil {
+		var derr error
+		if pc.watch, derr = dialDedicated(addr, pc.dto, pc.rto, pc.wto, pc.auth); derr != nil {
+			pc.writeErr(derr)
+			return
+		}
+	}
+	reply := &resp{}
+	if derr := pc.watch.do(pc.db, []*resp{req.resp}, reply); derr != nil {
+		pc.closeWatch()
+		return pc.bw.Write([]byte(fmt.Sprintf("-ERR WATCH failed on node %s\r\n", addr)))
+	}
+	return pc.encodeReply(reply, shapeNone)
+}
+
+// resetTx quit the transaction and unwatch all keys.
+func (pc *proxyConn) resetTx() {
+	pc.multi, pc.tx, pc.txAborted = false, nil, false
+	pc.closeWatch()
+}
+
+func (pc *proxyConn) closeWatch() {
+	if pc.watch != nil {
+		_ = pc.watch.Close()
+		pc.watch = nil
+	}
+}
@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"mycache/pkg/mockconn"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _txCheck decode and check the commands one by one, the last message is returned.
+func _txCheck(t *testing.T, pc *proxyConn, n int) (m *proto.Message, special bool) {
+	for i := 0; i < n; i++ {
+		msgs, err := pc.Decode(proto.GetMsgs(4))
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 1)
+		m = msgs[0]
+		special, err = pc.CmdCheck(m)
+		assert.NoError(t, err)
+	}
+	return
+}
+
+func TestTxQueueAndExec(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("MULTI\r\nSET a 1\r\nMSET b 2 c 3\r\nHELLO\r\nEXEC\r\nMULTI\r\nSET a 1\r\nEXEC\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+
+	// command not allowed aborts the transaction
+	_, special := _txCheck(t, pc, 5)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n+QUEUED\r\n-ERR command 'hello' is not allowed in transaction\r\n"+string(execAbortBytes), mc.Wbuf.String())
+	assert.False(t, pc.multi)
+
+	mc.Wbuf.Reset()
+	m, special := _txCheck(t, pc, 3)
+	assert.False(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n", mc.Wbuf.String())
+	req := m.Request().(*Request)
+	assert.Len(t, req.tx, 1)
+	assert.Equal(t, "a", string(req.Key()))
+	assert.False(t, pc.multi)
+
+	// cross node transaction fails at EXEC, the conn is kept
+	mc.Wbuf.Reset()
+	m.WithError(proto.ErrCrossNode)
+	assert.NoError(t, pc.Encode(m))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(execAbortCrossNodeBytes), mc.Wbuf.String())
+}
+
+func TestTxKeys(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("MULTI\r\nSET a 1\r\nMSET b 2 c 3\r\nPING x\r\nDEL d e\r\nEXEC\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	m, special := _txCheck(t, pc, 6)
+	assert.False(t, special)
+	req := m.Request().(*Request)
+	var keys []string
+	for _, key := range req.Keys() {
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+}
+
+func TestTxWithoutMulti(t *testing.T) {
+	mc := mockconn.CreateMockConn([]byte("EXEC\r\nDISCARD\r\nUNWATCH\r\nMULTI\r\nMULTI\r\nWATCH a\r\nDISCARD\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	_, special := _txCheck(t, pc, 7)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(execNoMultiBytes)+string(discardNoMultiBytes)+"+OK\r\n+OK\r\n"+string(multiNestedBytes)+string(watchInMultiBytes)+"+OK\r\n", mc.Wbuf.String())
+}
+
+func TestTxNodeConn(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateMockConn([]byte("+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n:1\r\n"), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn)
+	req := newRequest("EXEC")
+	req.tx = []*resp{newRequest("SET", "a", "1").resp, newRequest("INCR", "b").resp}
+	for _, r := range append(req.tx, req.resp) {
+		r.respType = respArray
+	}
+	msg := proto.NewMessage()
+	msg.WithRequest(req)
+	assert.NoError(t, nc.Write(msg))
+	assert.NoError(t, nc.Flush())
+	mc := conn.Conn.(*mockconn.MockConn)
+	assert.Equal(t, "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$4\r\nINCR\r\n$1\r\nb\r\n*1\r\n$4\r\nEXEC\r\n", mc.Wbuf.String())
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, 2, req.reply.arraySize)
+	assert.Equal(t, []byte("1"), req.reply.array[1].data)
+}
+
+func TestTxWatch(t *testing.T) {
+	addr, cmds := _fakePubSubNode(t, "+OK\r\n", "+OK\r\n", "+QUEUED\r\n", "*1\r\n+OK\r\n")
+	mc := mockconn.CreateMockConn([]byte("WATCH a\r\nMULTI\r\nSET a 1\r\nEXEC\r\nWATCH a b\r\n"), 1).(*mockconn.MockConn)
+	pc := NewProxyConn(libnet.NewConn(mc, time.Second, time.Second), "").(*proxyConn)
+	pc.SetTx(func(key []byte) (string, bool) {
+		if string(key) == "b" {
+			return "127.0.0.1:1", true
+		}
+		return addr, true
+	}, time.Second, time.Second, time.Second)
+
+	_, special := _txCheck(t, pc, 4)
+	assert.True(t, special)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n+OK\r\n+QUEUED\r\n*1\r\n+OK\r\n", mc.Wbuf.String())
+	assert.Equal(t, "WATCH a", <-cmds)
+	assert.Equal(t, "MULTI", <-cmds)
+	assert.Equal(t, "SET a 1", <-cmds)
+	assert.Equal(t, "EXEC", <-cmds)
+	assert.Nil(t, pc.watch)
+
+	// watched keys must be on the same node
+	mc.Wbuf.Reset()
+	_txCheck(t, pc, 1)
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, string(crossNodeBytes), mc.Wbuf.String())
+	assert.NoError(t, pc.Close())
+}
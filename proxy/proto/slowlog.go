@@ -0,0 +1,88 @@
+package proto
+
+import (
+	"fmt"
+	"time"
+
+	"mycache/pkg/types"
+)
+
+// maxBody 慢日志里每个参数最多保留的字节数
+const maxBody = 128
+
+// SlowlogEntry is the slow command with the time of every stage, the durations are in nanoseconds.
+type SlowlogEntry struct {
+	ID        int64           `json:"id"`
+	Cluster   string          `json:"cluster"`
+	CacheType types.CacheType `json:"cache_type"`
+	Client    string          `json:"client,omitempty"`
+	Addr      string          `json:"addr,omitempty"`
+	Cmd       []string        `json:"cmd"`
+	StartTime time.Time       `json:"start_time"`
+
+	TotalDur     time.Duration `json:"total_dur"`
+	RemoteDur    time.Duration `json:"remote_dur"`
+	WaitWriteDur time.Duration `json:"wait_write_dur"`
+	PreEndDur    time.Duration `json:"pre_end_dur"`
+	PipeDur      time.Duration `json:"pipe_dur"`
+	InputDur     time.Duration `json:"input_dur"`
+
+	Subs []*SlowlogEntry `json:"subs,omitempty"`
+}
+
+// NewSlowlogEntry new slowlog entry of the cache type.
+func NewSlowlogEntry(ctype types.CacheType) *SlowlogEntry {
+	return &SlowlogEntry{CacheType: ctype}
+}
+
+// CollapseBody collapse the long argument, only the head is kept.
+func CollapseBody(body []byte) []byte {
+	if len(body) <= maxBody {
+		return body
+	}
+	collapsed := make([]byte, 0, maxBody+32)
+	collapsed = append(collapsed, body[:maxBody]...)
+	return append(collapsed, fmt.Sprintf("...(%d more bytes)", len(body)-maxBody)...)
+}
+
+// Slowlog returns the slowlog entry of message, the sub messages of batch are kept in Subs.
+func (m *Message) Slowlog() *SlowlogEntry {
+	req := m.Request()
+	if req == nil {
+		return nil
+	}
+	e := req.Slowlog()
+	if e == nil {
+		return nil
+	}
+	e.StartTime = m.st
+	e.TotalDur = m.TotalDur()
+	e.PipeDur = m.PipeDur()
+	if !m.IsBatch() {
+		m.stageTo(e)
+		return e
+	}
+	// NOTE: only the backend stages are marked on sub messages
+	for _, sub := range m.Subs() {
+		sreq := sub.Request()
+		if sreq == nil {
+			continue
+		}
+		se := sreq.Slowlog()
+		if se == nil {
+			continue
+		}
+		sub.stageTo(se)
+		e.Subs = append(e.Subs, se)
+	}
+	return e
+}
+
+// stageTo set the time of backend stages into entry.
+func (m *Message) stageTo(e *SlowlogEntry) {
+	e.Addr = m.addr
+	e.RemoteDur = m.RemoteDur()
+	e.WaitWriteDur = m.WaitWriteDur()
+	e.PreEndDur = m.PreEndDur()
+	e.InputDur = m.InputDur()
+}
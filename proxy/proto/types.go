@@ -7,12 +7,128 @@ var (
 	ErrQuit = errors.New("close client conn")
 )
 
+// errors replied to client, the client conn is kept.
+var (
+	ErrCrossNode = errors.New("CROSSSLOT Keys in request don't hash to the same node")
+	ErrBadCursor = errors.New("ERR invalid cursor")
+)
+
 //Request 可转发处理的请求约束
 type Request interface {
 	CmdString() string
 	Cmd() []byte
 	Key() []byte
 	Put()
+	Slowlog() *SlowlogEntry
+}
+
+// MultiKeyRequest 多key请求，所有key必须落在同一个node上
+type MultiKeyRequest interface {
+	Keys() [][]byte
+}
+
+// BroadcastRequest 需要发送到所有node的请求
+type BroadcastRequest interface {
+	IsBroadcast() bool
+	// CopyTo copy the request into dst, a new request is created when dst can't be reused.
+	CopyTo(dst Request) Request
+}
+
+// Broadcast fill the message with a copy of request for every node, the sub messages are returned.
+func Broadcast(m *Message, nodes int) []*Message {
+	br := m.Request().(BroadcastRequest)
+	for i := 1; i < nodes; i++ {
+		if req := m.NextReq(); req != nil {
+			br.CopyTo(req)
+		} else {
+			m.WithRequest(br.CopyTo(nil))
+		}
+	}
+	if !m.IsBatch() {
+		return []*Message{m}
+	}
+	return m.Batch()
+}
+
+// IsBroadcast check if the message must be sent to all nodes.
+func IsBroadcast(m *Message) bool {
+	br, ok := m.Request().(BroadcastRequest)
+	return ok && br.IsBroadcast()
+}
+
+// CursorRequest 代理侧游标请求，返回给客户端的游标里编码了node序号和后端游标
+type CursorRequest interface {
+	IsCursor() bool
+	// NodeIndex returns the index of node encoded in the cursor, -1 if the cursor is invalid.
+	NodeIndex() int
+	// WithNodes set the count of nodes, which is used to encode the next cursor.
+	WithNodes(nodes int)
+}
+
+// CursorNode returns the index of node which the cursor request should be sent to.
+func CursorNode(m *Message, nodes int) (idx int, isCursor bool, err error) {
+	cr, ok := m.Request().(CursorRequest)
+	if !ok || !cr.IsCursor() {
+		return
+	}
+	isCursor = true
+	if idx = cr.NodeIndex(); idx < 0 || idx >= nodes {
+		err = ErrBadCursor
+		return
+	}
+	cr.WithNodes(nodes)
+	return
+}
+
+// PublishRequest 发布消息的请求
+type PublishRequest interface {
+	IsPublish() bool
+}
+
+// IsPublish check if the message is a publish message.
+func IsPublish(m *Message) bool {
+	pr, ok := m.Request().(PublishRequest)
+	return ok && pr.IsPublish()
+}
+
+// PubSubForwarder locate the node of pub/sub channel, subscribers use a dedicated conn to the node.
+// PubSubShared is true if one node receives the messages of all channels, so any channel and pattern can be subscribed on the conn.
+type PubSubForwarder interface {
+	PubSubAddr(channel []byte) (addr string, ok bool)
+	PubSubShared() bool
+}
+
+// KeyLocator locate the node of key, WATCH uses a dedicated conn to the node.
+type KeyLocator interface {
+	KeyAddr(key []byte) (addr string, ok bool)
+}
+
+// NodeInfo the state of backend node shown by INFO and admin api.
+type NodeInfo struct {
+	Addr    string `json:"addr"`
+	Alias   string `json:"alias"`   // NOTE: default is addr
+	Weight  int    `json:"weight"`  // redis cluster的node为0
+	Ejected bool   `json:"ejected"` // 被pinger或admin剔除出hash环
+	Pending int    `json:"pending"` // 管道里等待发送的消息数
+
+	Replicas []*NodeInfo `json:"replicas,omitempty"` // 从库，Ejected是ping失败不再分配读请求
+}
+
+// NodeInfoForwarder returns the state of all backend nodes.
+type NodeInfoForwarder interface {
+	NodeInfos() []*NodeInfo
+}
+
+// ReplicaRequest is the request which can be served by the replica of node.
+type ReplicaRequest interface {
+	ReadReplica() bool
+}
+
+// NodeAdminForwarder change the nodes on hash ring by admin, the node is the address or alias.
+type NodeAdminForwarder interface {
+	EjectNode(node string) error
+	ReaddNode(node string) error
+	SetNodeWeight(node string, weight int) error
 }
 
 // ProxyConn decode bytes from client and encode write to conn.
@@ -12,16 +12,21 @@ import (
 	errs "errors"
 	"mycache/pkg/types"
 	"mycache/proxy/proto"
+	"mycache/proxy/proto/memcache"
+	mcbin "mycache/proxy/proto/memcache/binary"
 	"mycache/proxy/proto/redis"
+	rclstr "mycache/proxy/proto/redis/cluster"
 	"net"
+	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"mycache/pkg/log"
 	libnet "mycache/pkg/net"
+	"mycache/pkg/prom"
 
 	"github.com/pkg/errors"
 	"gopkg.in/fsnotify.v1"
@@ -32,20 +37,27 @@ var (
 	ErrProxyMoreMaxConns = errs.New("Proxy accept more than max connextions")
 	ErrProxyReloadIgnore = errs.New("Proxy reload cluster config is ignored")
 	ErrProxyReloadFail   = errs.New("Proxy reload cluster config is failed")
+	ErrProxyClosed       = errs.New("Proxy is closed")
+	ErrProxyConfReloaded = errs.New("Proxy cluster config is reloaded")
 )
 
 //Proxy 定义个Proxy数据存储类型
 type Proxy struct {
-	c          *Config                    //主程配置
-	ccf        string                     //node配置文件名 "proxy-backend-conf.toml"
-	ccs        []*ClusterConfig           //node多个配置项 1
-	forwarders map[string]proto.Forwarder //主程指定类型的转发器集合
-	lock       sync.Mutex                 //严格的独占互斥锁
-	// lock       sync.RWMutex //（读写锁：并读串写，且当前写是独占的）
+	c        atomic.Value        //主程配置 *Config，reload时替换
+	ccf      string              //node配置文件名 "proxy-backend-conf.toml"
+	clusters map[string]*cluster //运行中的集群，reload时增删和替换
+	lock     sync.Mutex          //保护clusters，reload串行执行
+	loader   ConfLoader          //SIGHUP和admin reload加载配置文件，为空只加载ccf
 
 	conns int32 //主程并发的连接计数 1
 
-	closed bool //主程可用状态 false
+	closed int32 //主程可用状态 0，关闭后为1
+
+	hlock    sync.Mutex            //保护handlers
+	handlers map[*Handler]struct{} //正在处理的客户端连接，关闭时等待处理完
+
+	started time.Time //启动时间，INFO的uptime
+	stats   stats     //INFO的统计
 }
 
 //New 依配置新起一个proxy主程
@@ -55,76 +67,111 @@ func New(c *Config) (p *Proxy, err error) {
 		return
 	}
 	p = &Proxy{}
-	p.c = c
+	p.c.Store(c)
+	p.handlers = make(map[*Handler]struct{})
+	p.started = time.Now()
 	return
 }
 
+// conf returns the current config of proxy.
+func (p *Proxy) conf() *Config {
+	return p.c.Load().(*Config)
+}
+
 //Serve 起主程主服务，监听配置端口
 func (p *Proxy) Serve(ccs []*ClusterConfig) {
-	//nodes配置
-	p.ccs = ccs
 	if len(ccs) == 0 {
 		log.Warnf("cache-proxy will never listen on any port due to cluster is not specified")
 	}
-	// p.lock.Lock() 无意义的锁
-	p.forwarders = map[string]proto.Forwarder{}
-	// p.lock.Unlock()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.clusters = map[string]*cluster{}
 	for _, cc := range ccs {
 		log.Infof("start to serve cluster[%s] with configs %v", cc.Name, *cc)
-		p.serve(cc)
+		//为后端配置项创建tcp请求监听器
+		l, err := listen(cc)
+		if err != nil {
+			panic(err)
+		}
+		//为后端配置项创建后端目标转发器
+		p.clusters[cc.Name] = p.serve(l, newBackend(cc, NewForwarder(cc)))
 	}
 }
 
-func (p *Proxy) serve(cc *ClusterConfig) {
-	//为后端配置项创建后端目标转发器
-	forwarder := NewForwarder(cc)
-	//转发器绑定到当前服务实例中
-	p.forwarders[cc.Name] = forwarder
-	//为后端配置项创建tcp请求监听器
-	l, err := libnet.Listen(cc.ListenProto, cc.ListenAddr)
-	if err != nil {
-		panic(err)
-	}
-	log.Infof("mycache proxy cluster[%s] addr(%s) start listening", cc.Name, cc.ListenAddr)
+func (p *Proxy) serve(l net.Listener, b *backend) *cluster {
+	c := newCluster(l, b)
+	log.Infof("mycache proxy cluster[%s] addr(%s) start listening", b.cc.Name, b.cc.ListenAddr)
 	//进入groutine（类似fork出子线程）领域，注意此后的并发读写问题
-	go p.accept(cc, l, forwarder)
+	go p.accept(c)
+	return c
 }
-func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwarder) {
+
+// listen the client conns, with TLS if the certificate is set.
+func listen(cc *ClusterConfig) (l net.Listener, err error) {
+	if cc.TLSCert == "" {
+		l, err = libnet.Listen(cc.ListenProto, cc.ListenAddr)
+	} else {
+		t, terr := libnet.ServerTLS(cc.tlsOptions())
+		if terr != nil {
+			return nil, terr
+		}
+		l, err = libnet.ListenTLS(cc.ListenProto, cc.ListenAddr, t)
+	}
+	if err != nil || cc.ListenProto != "unix" {
+		return
+	}
+	// NOTE: the mode is validated already
+	mode, _ := strconv.ParseUint(cc.ListenMode, 8, 32)
+	if err = libnet.SetUnixPerm(cc.ListenAddr, os.FileMode(mode), cc.ListenOwner); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return
+}
+
+func (p *Proxy) accept(c *cluster) {
 	//阻塞accept方法，接收请求
 	for {
-		//TODO: RACE
-		if p.closed {
+		if p.isClosed() || c.isStopped() {
+			cc := c.current().cc
 			log.Infof("mycache proxy cluster[%s] addr(%s) stop listen", cc.Name, cc.ListenAddr)
 			return
 		}
 		//协程建立连接 进入全双工双向通信领域，注意此conn状态
 		//并发模型：每个连接创建一个线程去处理
-		conn, err := l.Accept()
+		conn, err := c.l.Accept()
 		if err != nil {
 			if conn != nil {
 				//丢弃异常链接
 				_ = conn.Close()
 			}
-			log.Errorf("cluster(%s) addr(%s) accept connection error:%+v", cc.Name, cc.ListenAddr, err)
+			if p.isClosed() || c.isStopped() {
+				// NOTE: the listener is closed by Close or reload
+				continue
+			}
+			log.Errorf("cluster(%s) addr(%s) accept connection error:%+v", c.current().cc.Name, c.current().cc.ListenAddr, err)
 			continue
 		}
+		//reload后新连接用新的配置和转发器
+		b := c.current()
+		cc := b.cc
 		//检查proxy的并发连接数设置，如果不是无限
-		if p.c.Proxy.MaxConnections > 0 {
+		if maxConns := p.conf().Proxy.MaxConnections; maxConns > 0 {
 			//去比较已建立的并发连接数是否大于设定的数
-			if conns := atomic.LoadInt32(&p.conns); conns > p.c.Proxy.MaxConnections {
+			if conns := atomic.LoadInt32(&p.conns); conns > maxConns {
 				// cache type
 				// A case:不处理的连接处理（给错误信息返回即可，不进入正常处理调用）
 				var encoder proto.ProxyConn
 				switch cc.CacheType {
-				// case types.CacheTypeMemcache:
-				// 	//Memcache业务请求端conn的代理连接（Memcache编码协议的代理）
-				// 	encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				// case types.CacheTypeMemcacheBinary:
-				// 	encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+				case types.CacheTypeMemcache:
+					//Memcache业务请求端conn的代理连接（Memcache编码协议的代理）
+					encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+				case types.CacheTypeMemcacheBinary:
+					encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
 				case types.CacheTypeRedis:
 					encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), cc.Password)
-					// case types.CacheTypeRedisCluster:
-					// 	encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil, cc.Password)
+				case types.CacheTypeRedisCluster:
+					encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil, cc.Password)
 				}
 				//interface类型不是nil时，表示cc的类型存在匹配项
 				if encoder != nil {
@@ -134,36 +181,141 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 				}
 				//
 				_ = conn.Close() //关闭这个终端请求的tcp连接
+				atomic.AddInt64(&p.stats.rejected, 1)
 				if log.V(4) {
-					log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, p.c.Proxy.MaxConnections)
+					log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, maxConns)
 				}
 				continue
 			}
 		}
 		atomic.AddInt32(&p.conns, 1) //原子+1
 		//新建个Handler去处理该连接conn上的请求
-		NewHandler(p, cc, conn, forwarder).Handle()
+		h, err := p.newHandler(c, b, conn)
+		if err != nil {
+			h.closeWithError(err)
+			continue
+		}
+		h.Handle()
+	}
+}
+
+// newHandler new the handler of conn with the backend and track it.
+// NOTE: the backend may be retired by reload just after the conn is accepted, the conn is served with the current one then.
+func (p *Proxy) newHandler(c *cluster, b *backend, conn net.Conn) (h *Handler, err error) {
+	for {
+		h = NewHandler(p, b.cc, conn, b.forwarder)
+		if err = p.track(h, b); err != ErrProxyConfReloaded {
+			return
+		}
+		nb := c.current()
+		if nb == b {
+			return
+		}
+		if prom.On {
+			prom.ConnDecr(b.cc.Name) // NOTE: counted by NewHandler
+		}
+		b = nb
+	}
+}
+
+func (p *Proxy) isClosed() bool {
+	return atomic.LoadInt32(&p.closed) == 1
+}
+
+// track the handler until it is closed, fails if proxy is closed or the backend is retired by reload.
+func (p *Proxy) track(h *Handler, b *backend) error {
+	p.hlock.Lock()
+	defer p.hlock.Unlock()
+	if p.isClosed() {
+		return ErrProxyClosed
+	}
+	if b.retired {
+		return ErrProxyConfReloaded
+	}
+	h.b = b
+	*b.refs++
+	p.handlers[h] = struct{}{}
+	return nil
+}
+
+func (p *Proxy) untrack(h *Handler) {
+	p.hlock.Lock()
+	delete(p.handlers, h)
+	idle := h.b != nil && h.b.release()
+	p.hlock.Unlock()
+	if idle {
+		h.b.forwarder.Close()
 	}
 }
 
 // Close close proxy resource.
 // 关闭proxy主程持有的预建连接
+// NOTE: stop accepting first, then the client conns finish the current requests within the drain timeout,
+// the backend conns are closed at last.
 func (p *Proxy) Close() error {
-	if p.closed {
+	p.hlock.Lock()
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		p.hlock.Unlock()
 		return nil
 	}
-	for _, forwarder := range p.forwarders {
-		forwarder.Close()
+	handlers := make([]*Handler, 0, len(p.handlers))
+	for h := range p.handlers {
+		handlers = append(handlers, h)
+	}
+	p.hlock.Unlock()
+	// NOTE: closing the unix listener removes the socket file
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, c := range p.clusters {
+		c.stop()
+	}
+
+	p.drain(handlers, time.Duration(p.conf().Proxy.DrainTimeout)*time.Millisecond)
+	for _, c := range p.clusters {
+		c.current().forwarder.Close()
 	}
-	// TODO :RACE,可能无关紧要
-	p.closed = true
 	return nil
 }
 
+// drain stop reading new requests of the client conns and wait them closed, the left conns are closed after timeout.
+func (p *Proxy) drain(handlers []*Handler, timeout time.Duration) {
+	if len(handlers) == 0 {
+		return
+	}
+	log.Infof("proxy is draining %d client connections in %v", len(handlers), timeout)
+	for _, h := range handlers {
+		h.drain()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i, h := range handlers {
+		select {
+		case <-h.done:
+			continue
+		case <-timer.C:
+		}
+		var left []*Handler
+		for _, h := range handlers[i:] {
+			select {
+			case <-h.done:
+			default:
+				left = append(left, h)
+			}
+		}
+		log.Warnf("proxy drain timeout and close %d client connections", len(left))
+		for _, h := range left {
+			h.forceClose()
+		}
+		return
+	}
+}
+
 // MonitorConfChange reload servers.
 // 监视配置文件的变动
 func (p *Proxy) MonitorConfChange(ccf string) {
+	p.lock.Lock()
 	p.ccf = ccf
+	p.lock.Unlock()
 	// start watcher
 	watch, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -183,7 +335,7 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 	}
 	log.Infof("proxy is watching changes cluster config absolute path as %s", absPath)
 	for {
-		if p.closed {
+		if p.isClosed() {
 			log.Infof("proxy is closed and exit configure file:%s monitor", p.ccf)
 			return
 		}
@@ -191,21 +343,13 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 		case ev := <-watch.Events:
 			if ev.Op&fsnotify.Create == fsnotify.Create || ev.Op&fsnotify.Write == fsnotify.Write || ev.Op&fsnotify.Rename == fsnotify.Rename {
 				time.Sleep(time.Second)
-				newConfs, err := LoadClusterConf(p.ccf)
-				if err != nil {
-					// prom.ErrIncr(p.ccf, p.ccf, "config reload", err.Error())
+				if _, err = p.ReloadConf(false); err != nil {
+					if prom.On {
+						prom.ReloadIncr(p.ccf, prom.ReloadFail)
+					}
 					log.Errorf("failed to load conf file:%s and got error:%v", p.ccf, err)
 					continue
 				}
-				changed := parseChanged(newConfs, p.ccs)
-				for _, conf := range changed {
-					if err = p.updateConfig(conf); err == nil {
-						log.Infof("reload successful cluster:%s config succeed", conf.Name)
-					} else {
-						// prom.ErrIncr(conf.Name, conf.Name, "cluster reload", err.Error())
-						log.Errorf("reload failed cluster:%s config and get error:%v", conf.Name, err)
-					}
-				}
 				log.Infof("watcher file:%s occurs event:%s and reload finish", ev.Name, ev.String())
 				continue
 			}
@@ -218,67 +362,3 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 		}
 	}
 }
-
-func (p *Proxy) updateConfig(conf *ClusterConfig) (err error) {
-	p.lock.Lock()
-	//更新proxy运行时配置文件操作的上独占锁
-	defer p.lock.Unlock()
-	f, ok := p.forwarders[conf.Name]
-	if !ok {
-		err = errors.Wrapf(ErrProxyReloadIgnore, "cluster:%s", conf.Name)
-		return
-	}
-	//原子更新配置文件p.ccs
-	if err = f.Update(conf.Servers); err != nil {
-		err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", conf.Name, err)
-		return
-	}
-	//读取配置文件并比较
-	for _, oldConf := range p.ccs {
-		if oldConf.Name != conf.Name {
-			continue
-		}
-		oldConf.Servers = make([]string, len(conf.Servers), cap(conf.Servers))
-		copy(oldConf.Servers, conf.Servers)
-		return
-	}
-	return
-}
-func parseChanged(newConfs, oldConfs []*ClusterConfig) (changed []*ClusterConfig) {
-
-	changed = make([]*ClusterConfig, 0, len(oldConfs))
-	for _, cf := range newConfs {
-		sort.Strings(cf.Servers)
-	}
-
-	for _, cf := range oldConfs {
-		sort.Strings(cf.Servers)
-	}
-
-	for _, newConf := range newConfs {
-		for _, oldConf := range oldConfs {
-			if newConf.Name != oldConf.Name {
-				continue
-			}
-
-			if !deepEqualOrderedStringSlice(newConf.Servers, oldConf.Servers) {
-				changed = append(changed, newConf)
-			}
-			break
-		}
-	}
-	return
-}
-func deepEqualOrderedStringSlice(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
-	}
-
-	for i := 0; i < len(a); i++ {
-		if a[i] != b[i] {
-			return false
-		}
-	}
-
-	return true
-}
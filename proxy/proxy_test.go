@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mycache/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakeRedis reply $1\r\nv\r\n to every command of RESP array.
+func _fakeRedis(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if !strings.HasPrefix(line, "$") {
+						continue
+					}
+					if _, err = br.ReadString('\n'); err != nil {
+						return
+					}
+					// NOTE: reply after the last argument
+					if br.Buffered() == 0 {
+						conn.Write([]byte("$1\r\nv\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func _freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestProxyCloseDrain(t *testing.T) {
+	backend := _fakeRedis(t)
+	defer backend.Close()
+	c := DefaultConfig()
+	c.Proxy.DrainTimeout = 3000
+	p, err := New(c)
+	assert.NoError(t, err)
+	cc := &ClusterConfig{Name: "drain", CacheType: types.CacheTypeRedis, ListenAddr: _freeAddr(t), Servers: []string{backend.Addr().String() + ":1"},
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000}
+	cc.SetDefault()
+	p.Serve([]*ClusterConfig{cc})
+
+	conn, err := net.Dial("tcp", cc.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\n", reply)
+	_, err = br.ReadString('\n')
+	assert.NoError(t, err)
+
+	// NOTE: the idle conn is closed at once without waiting the drain timeout
+	start := time.Now()
+	assert.NoError(t, p.Close())
+	assert.True(t, time.Since(start) < time.Second)
+	_, err = br.ReadString('\n')
+	assert.Error(t, err)
+	_, err = net.DialTimeout("tcp", cc.ListenAddr, 100*time.Millisecond)
+	assert.Error(t, err)
+	assert.NoError(t, p.Close())
+}
+
+func _redisGet(t *testing.T, addr string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestChangedFields(t *testing.T) {
+	old := &ClusterConfig{Name: "a", ListenAddr: "127.0.0.1:1", Servers: []string{"127.0.0.1:2:1", "127.0.0.1:3:1"}}
+	cc := &ClusterConfig{Name: "a", ListenAddr: "127.0.0.1:1", Servers: []string{"127.0.0.1:3:1", "127.0.0.1:2:1"}}
+	assert.Empty(t, changedFields(old, cc))
+	assert.Equal(t, []string{"127.0.0.1:2:1", "127.0.0.1:3:1"}, old.Servers)
+
+	cc.HashMethod = "crc16"
+	cc.Password = "pwd"
+	cc.Servers = []string{"127.0.0.1:4:1"}
+	assert.Equal(t, []string{"hash_method", "servers", "password"}, changedFields(old, cc))
+}
+
+func TestProxyReload(t *testing.T) {
+	backend := _fakeRedis(t)
+	defer backend.Close()
+	c := DefaultConfig()
+	c.Proxy.DrainTimeout = 1000
+	p, err := New(c)
+	assert.NoError(t, err)
+	newCC := func(name, addr string) *ClusterConfig {
+		cc := &ClusterConfig{Name: name, CacheType: types.CacheTypeRedis, ListenAddr: addr, Servers: []string{backend.Addr().String() + ":1"},
+			DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000}
+		cc.SetDefault()
+		return cc
+	}
+	a := newCC("a", _freeAddr(t))
+	p.Serve([]*ClusterConfig{a})
+	defer p.Close()
+	reply, err := _redisGet(t, a.ListenAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\n", reply)
+
+	// unchanged
+	assert.Empty(t, p.Reload(nil, []*ClusterConfig{newCC("a", a.ListenAddr)}, false))
+
+	// add b and rebuild a with password on the same listener
+	a2 := newCC("a", a.ListenAddr)
+	a2.Password = "pwd"
+	b := newCC("b", _freeAddr(t))
+	reports := p.Reload(nil, []*ClusterConfig{a2, b}, false)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, "cluster=a action=rebuild changed=password result=success", reports[0].String())
+		assert.Equal(t, "cluster=b action=add result=success", reports[1].String())
+	}
+	reply, err = _redisGet(t, a.ListenAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", reply)
+	reply, err = _redisGet(t, b.ListenAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\n", reply)
+
+	// move b to another address and remove a
+	b2 := newCC("b", _freeAddr(t))
+	reports = p.Reload(nil, []*ClusterConfig{b2}, false)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, "cluster=a action=remove result=success", reports[0].String())
+		assert.Equal(t, "cluster=b action=rebuild changed=listen_addr result=success", reports[1].String())
+	}
+	_, err = _redisGet(t, a.ListenAddr)
+	assert.Error(t, err)
+	_, err = _redisGet(t, b.ListenAddr)
+	assert.Error(t, err)
+	reply, err = _redisGet(t, b2.ListenAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\n", reply)
+
+	// servers only
+	b3 := newCC("b", b2.ListenAddr)
+	b3.Servers = append(b3.Servers, "127.0.0.1:1:1")
+	old := p.clusters["b"].current()
+	reports = p.Reload(nil, []*ClusterConfig{b3}, false)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, "cluster=b action=update changed=servers result=success", reports[0].String())
+	}
+	// NOTE: the config of old backend is not modified, the forwarder is kept
+	cur := p.clusters["b"].current()
+	assert.Len(t, old.cc.Servers, 1)
+	assert.Equal(t, b3.Servers, cur.cc.Servers)
+	assert.Equal(t, old.forwarder, cur.forwarder)
+
+	// the forwarder fails to be built for no seed of redis cluster
+	b4 := newCC("b", b2.ListenAddr)
+	b4.CacheType = types.CacheTypeRedisCluster
+	b4.Servers = []string{"127.0.0.1:1"}
+	c4 := newCC("c", _freeAddr(t))
+	c4.CacheType = types.CacheTypeRedisCluster
+	c4.Servers = []string{"127.0.0.1:1"}
+	reports = p.Reload(nil, []*ClusterConfig{b4, c4}, false)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, reloadRebuild, reports[0].Action)
+		assert.Contains(t, reports[0].Error, ErrProxyReloadFail.Error())
+		assert.Equal(t, reloadAdd, reports[1].Action)
+		assert.Contains(t, reports[1].Error, ErrProxyReloadFail.Error())
+	}
+	assert.NotContains(t, p.clusters, "c")
+	_, err = _redisGet(t, c4.ListenAddr)
+	assert.Error(t, err)
+	assert.Equal(t, cur, p.clusters["b"].current())
+}
+
+func TestProxyReloadKeepConns(t *testing.T) {
+	backend := _fakeRedis(t)
+	defer backend.Close()
+	c := DefaultConfig()
+	c.Proxy.DrainTimeout = 1000
+	p, err := New(c)
+	assert.NoError(t, err)
+	cc := &ClusterConfig{Name: "a", CacheType: types.CacheTypeRedis, ListenAddr: _freeAddr(t), Servers: []string{backend.Addr().String() + ":1"},
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000}
+	cc.SetDefault()
+	p.Serve([]*ClusterConfig{cc})
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", cc.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	get := func() {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		_, err := conn.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+		assert.NoError(t, err)
+		reply, err := br.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "$1\r\n", reply)
+		_, err = br.ReadString('\n')
+		assert.NoError(t, err)
+	}
+	get()
+
+	// NOTE: the accepted conn is moved to the new forwarder instead of being drained
+	old := p.clusters["a"].current()
+	cc2 := *cc
+	cc2.ReadTimeout, cc2.PingFailLimit = 2000, 5
+	reports := p.Reload(nil, []*ClusterConfig{&cc2}, false)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, "cluster=a action=rebuild changed=read_timeout,ping_fail_limit result=success", reports[0].String())
+	}
+	cur := p.clusters["a"].current()
+	assert.NotEqual(t, old.forwarder, cur.forwarder)
+	get()
+	p.hlock.Lock()
+	assert.Equal(t, 0, *old.refs)
+	assert.Equal(t, 1, *cur.refs)
+	for h := range p.handlers {
+		assert.Equal(t, cur, h.b)
+		assert.Equal(t, cur.forwarder, h.forwarder)
+	}
+	p.hlock.Unlock()
+	assert.Equal(t, forwarderStateClosed, atomic.LoadInt32(&old.forwarder.(*defaultForwarder).state))
+
+	// NOTE: the conn accepted with the retired backend is served with the current one
+	atomic.AddInt32(&p.conns, 1)
+	h, err := p.newHandler(p.clusters["a"], old, &net.TCPConn{})
+	assert.NoError(t, err)
+	assert.Equal(t, cur, h.b)
+	h.closeWithError(io.EOF)
+}
@@ -0,0 +1,472 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/log"
+	"mycache/pkg/prom"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// reload actions of cluster
+const (
+	reloadAdd     = "add"     // 新集群，起监听和转发器
+	reloadRemove  = "remove"  // 删除的集群，停止监听，处理完的连接关闭后关闭转发器
+	reloadUpdate  = "update"  // 只有servers变化，原地更新hash环
+	reloadRebuild = "rebuild" // 其他配置变化，重建转发器，已有的连接在下个请求前换到新的转发器，监听变化时重新监听
+	reloadIgnore  = "ignore"  // 不能运行时修改的配置，重启生效
+)
+
+// listenFields the fields need to listen again when changed.
+var listenFields = map[string]bool{
+	"listen_proto":  true,
+	"listen_addr":   true,
+	"listen_mode":   true,
+	"listen_owner":  true,
+	"tls_cert":      true,
+	"tls_key":       true,
+	"tls_client_ca": true,
+}
+
+// connFields the fields which the accepted conns are set up with, the conns of old config are drained when changed.
+var connFields = map[string]bool{
+	"cache_type": true,
+	"password":   true,
+	"users":      true,
+	"redis_auth": true,
+	"redis_user": true,
+	"redis_db":   true,
+	"databases":  true,
+}
+
+// backend the config and forwarder which the accepted conns are handled with.
+type backend struct {
+	cc        *ClusterConfig
+	forwarder proto.Forwarder
+	retired   bool         // 被reload替换，不再接收新连接，由Proxy.hlock保护
+	next      atomic.Value // *backend, 原地替换它的backend，已有的连接在下个请求前换过去
+	refs      *int         // 使用forwarder的连接数，同一个forwarder的backend共享，由Proxy.hlock保护
+}
+
+func newBackend(cc *ClusterConfig, forwarder proto.Forwarder) *backend {
+	return &backend{cc: cc, forwarder: forwarder, refs: new(int)}
+}
+
+// latest returns the newest backend which replaces it.
+func (b *backend) latest() *backend {
+	for {
+		nb, _ := b.next.Load().(*backend)
+		if nb == nil {
+			return b
+		}
+		b = nb
+	}
+}
+
+// release the forwarder for a conn, it returns true if the forwarder is replaced and not used by any conn, which should be closed.
+// NOTE: Proxy.hlock must be held.
+func (b *backend) release() bool {
+	*b.refs--
+	return *b.refs == 0 && b.latest().forwarder != b.forwarder
+}
+
+// cluster the running cluster, the backend is swapped when the config is reloaded.
+type cluster struct {
+	l       net.Listener
+	cur     atomic.Value // *backend
+	stopped int32
+}
+
+func newCluster(l net.Listener, b *backend) *cluster {
+	c := &cluster{l: l}
+	c.cur.Store(b)
+	return c
+}
+
+func (c *cluster) current() *backend {
+	return c.cur.Load().(*backend)
+}
+
+// swap the backend of new conns, the old one is returned.
+func (c *cluster) swap(b *backend) (old *backend) {
+	old = c.current()
+	c.cur.Store(b)
+	return
+}
+
+// stop accepting, the accepted conns are not affected.
+func (c *cluster) stop() {
+	if atomic.CompareAndSwapInt32(&c.stopped, 0, 1) {
+		// NOTE: closing the unix listener removes the socket file
+		_ = c.l.Close()
+	}
+}
+
+func (c *cluster) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) == 1
+}
+
+// ConfLoader load the proxy config and cluster configs to reload.
+type ConfLoader func() (*Config, []*ClusterConfig, error)
+
+// SetConfLoader set the loader used by ReloadConf, only the cluster config file is loaded if it is not set.
+func (p *Proxy) SetConfLoader(loader ConfLoader) {
+	p.lock.Lock()
+	p.loader = loader
+	p.lock.Unlock()
+}
+
+// ReloadReport is the change of proxy config or one cluster which is applied by reload, or would be applied by dry run.
+type ReloadReport struct {
+	Cluster string   `json:"cluster,omitempty"` // 为空是proxy的配置
+	Action  string   `json:"action"`
+	Changed []string `json:"changed,omitempty"` // 变化的配置项，toml的key
+	Error   string   `json:"error,omitempty"`
+}
+
+func (r *ReloadReport) String() string {
+	s := "proxy"
+	if r.Cluster != "" {
+		s = "cluster=" + r.Cluster
+	}
+	s += " action=" + r.Action
+	if len(r.Changed) > 0 {
+		s += " changed=" + strings.Join(r.Changed, ",")
+	}
+	if r.Error != "" {
+		return s + fmt.Sprintf(" result=%s error=%s", prom.ReloadFail, r.Error)
+	}
+	return s + " result=" + prom.ReloadSuccess
+}
+
+func (r *ReloadReport) fail(err error) {
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// log the report and count the result.
+func (r *ReloadReport) log() {
+	result := prom.ReloadSuccess
+	if r.Error != "" {
+		result = prom.ReloadFail
+		log.Errorf("reload %s", r)
+	} else {
+		log.Infof("reload %s", r)
+	}
+	if prom.On && r.Cluster != "" {
+		prom.ReloadIncr(r.Cluster, result)
+	}
+}
+
+// ReloadConf load the config files by the loader and reload them, the reports are logged if not dry run.
+func (p *Proxy) ReloadConf(dryRun bool) (reports []*ReloadReport, err error) {
+	p.lock.Lock()
+	loader, ccf := p.loader, p.ccf
+	p.lock.Unlock()
+	var (
+		c   *Config
+		ccs []*ClusterConfig
+	)
+	if loader != nil {
+		c, ccs, err = loader()
+	} else if ccf != "" {
+		ccs, err = LoadClusterConf(ccf)
+	} else {
+		err = errors.Wrap(ErrProxyReloadIgnore, "no config file")
+	}
+	if err != nil {
+		return
+	}
+	reports = p.Reload(c, ccs, dryRun)
+	if !dryRun {
+		for _, r := range reports {
+			r.log()
+		}
+	}
+	return
+}
+
+// Reload apply the proxy config and cluster configs, the proxy config is ignored if c is nil. Nothing is applied if dryRun,
+// the reports show what would be applied.
+// For clusters: the new clusters are started, the removed clusters are stopped and the changed clusters are updated or rebuilt,
+// the unchanged clusters are not reported.
+// NOTE: the removed clusters are stopped first to release the listen addresses.
+func (p *Proxy) Reload(c *Config, ccs []*ClusterConfig, dryRun bool) (reports []*ReloadReport) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.isClosed() {
+		return
+	}
+	if c != nil {
+		reports = append(reports, p.reloadProxy(c, dryRun)...)
+	}
+	news := make(map[string]struct{}, len(ccs))
+	for _, cc := range ccs {
+		news[cc.Name] = struct{}{}
+	}
+	var removed []string
+	for name := range p.clusters {
+		if _, ok := news[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		reports = append(reports, &ReloadReport{Cluster: name, Action: reloadRemove})
+		if dryRun {
+			continue
+		}
+		cl := p.clusters[name]
+		delete(p.clusters, name)
+		cl.stop()
+		go p.retire(cl.current())
+	}
+	for _, cc := range ccs {
+		cl, ok := p.clusters[cc.Name]
+		if !ok {
+			r := &ReloadReport{Cluster: cc.Name, Action: reloadAdd}
+			reports = append(reports, r)
+			if dryRun {
+				continue
+			}
+			f, err := newForwarder(cc)
+			if err != nil {
+				r.fail(err)
+				continue
+			}
+			l, err := listen(cc)
+			if err != nil {
+				f.Close()
+				r.fail(errors.Wrapf(ErrProxyReloadFail, "cluster:%s listen error:%v", cc.Name, err))
+				continue
+			}
+			p.clusters[cc.Name] = p.serve(l, newBackend(cc, f))
+			continue
+		}
+		old := cl.current()
+		changed := changedFields(old.cc, cc)
+		if len(changed) == 0 {
+			continue
+		}
+		r := &ReloadReport{Cluster: cc.Name, Action: reloadRebuild, Changed: changed}
+		if len(changed) == 1 && changed[0] == "servers" {
+			r.Action = reloadUpdate
+		}
+		reports = append(reports, r)
+		if dryRun {
+			continue
+		}
+		if r.Action == reloadUpdate {
+			r.fail(p.updateServers(cl, cc))
+		} else {
+			r.fail(p.rebuild(cl, cc, changed))
+		}
+	}
+	return
+}
+
+// reloadProxy apply the [proxy] section of config, the other changes are ignored and need restart.
+func (p *Proxy) reloadProxy(c *Config, dryRun bool) (reports []*ReloadReport) {
+	old := p.conf()
+	var applied, ignored []string
+	for _, key := range diffFields(reflect.ValueOf(old.Proxy), reflect.ValueOf(c.Proxy)) {
+		// NOTE: pprof, log and metrics are inited only on start
+		if key == "use_metrics" {
+			ignored = append(ignored, key)
+			continue
+		}
+		applied = append(applied, key)
+	}
+	if old.Pprof != c.Pprof {
+		ignored = append(ignored, "pprof")
+	}
+	if !reflect.DeepEqual(old.Config, c.Config) {
+		ignored = append(ignored, "log")
+	}
+	if len(applied) > 0 {
+		reports = append(reports, &ReloadReport{Action: reloadUpdate, Changed: applied})
+		if !dryRun {
+			nc := *c
+			nc.Pprof, nc.Config, nc.Proxy.UseMetrics = old.Pprof, old.Config, old.Proxy.UseMetrics
+			p.c.Store(&nc)
+		}
+	}
+	if len(ignored) > 0 {
+		reports = append(reports, &ReloadReport{Action: reloadIgnore, Changed: ignored, Error: "restart is required"})
+	}
+	return
+}
+
+// newForwarder new the forwarder of reloaded config, the panic of bad servers or unreachable seeds is returned as error.
+// NOTE: NewForwarder panics before any goroutine is started, so nothing is leaked.
+func newForwarder(cc *ClusterConfig) (f proto.Forwarder, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s new forwarder error:%v", cc.Name, r)
+		}
+	}()
+	f = NewForwarder(cc)
+	return
+}
+
+// updateServers update the hash ring of forwarder in place, the conns are served with a copy of config.
+// NOTE: the config of old backend is read by handlers, never modify it.
+func (p *Proxy) updateServers(c *cluster, cc *ClusterConfig) error {
+	old := c.current()
+	if err := old.forwarder.Update(cc.Servers); err != nil {
+		return errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", cc.Name, err)
+	}
+	ncc := *old.cc
+	ncc.Servers = make([]string, len(cc.Servers))
+	copy(ncc.Servers, cc.Servers)
+	b := &backend{cc: &ncc, forwarder: old.forwarder, refs: old.refs}
+	c.swap(b)
+	p.replace(old, b)
+	return nil
+}
+
+// replace move the accepted conns of old backend to the new one, every handler is moved before its next request.
+// The old forwarder is closed once no handler uses it.
+func (p *Proxy) replace(old, b *backend) {
+	p.hlock.Lock()
+	old.retired = true
+	old.next.Store(b)
+	idle := *old.refs == 0 && b.forwarder != old.forwarder
+	p.hlock.Unlock()
+	if idle {
+		old.forwarder.Close()
+	}
+}
+
+// rebind move the handler to the newest backend of its replaced backend, the handler calls it between requests.
+func (p *Proxy) rebind(h *Handler) {
+	p.hlock.Lock()
+	old := h.b
+	b := old.latest()
+	if b == old || b.retired {
+		// NOTE: the newest one is retired too, the handler is drained with it
+		p.hlock.Unlock()
+		return
+	}
+	*b.refs++
+	idle := old.release()
+	h.b = b
+	h.bind(b.cc, b.forwarder)
+	p.hlock.Unlock()
+	if idle {
+		old.forwarder.Close()
+	}
+}
+
+// rebuild serve the conns with a new forwarder, the accepted conns are moved to it unless the listener or the fields of conn
+// are changed, which drains the conns of old config.
+// NOTE: the old listener is closed first if the new one listens the same address, and listened again if the new one fails.
+func (p *Proxy) rebuild(c *cluster, cc *ClusterConfig, changed []string) (err error) {
+	old := c.current()
+	f, err := newForwarder(cc)
+	if err != nil {
+		return
+	}
+	relisten, reconnect := false, false
+	for _, field := range changed {
+		relisten = relisten || listenFields[field]
+		reconnect = reconnect || connFields[field]
+	}
+	if !relisten {
+		b := newBackend(cc, f)
+		c.swap(b)
+		if reconnect {
+			go p.retire(old)
+		} else {
+			p.replace(old, b)
+		}
+		return
+	}
+	oldKey, _ := old.cc.listenKey()
+	newKey, _ := cc.listenKey()
+	if oldKey == newKey {
+		c.stop()
+	}
+	l, err := listen(cc)
+	if err != nil {
+		f.Close()
+		err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s listen error:%v", cc.Name, err)
+		if oldKey != newKey {
+			return
+		}
+		ol, oerr := listen(old.cc)
+		if oerr != nil {
+			delete(p.clusters, cc.Name)
+			go p.retire(old)
+			return errors.Wrapf(err, "listen old addr:%s error:%v", old.cc.ListenAddr, oerr)
+		}
+		p.clusters[cc.Name] = p.serve(ol, old)
+		return
+	}
+	c.stop()
+	p.clusters[cc.Name] = p.serve(l, newBackend(cc, f))
+	go p.retire(old)
+	return
+}
+
+// retire drain the client conns of the old backend and close its forwarder.
+// NOTE: the conns which are not moved to it yet are drained too.
+func (p *Proxy) retire(b *backend) {
+	p.hlock.Lock()
+	b.retired = true
+	var handlers []*Handler
+	for h := range p.handlers {
+		if h.b.latest() == b {
+			handlers = append(handlers, h)
+		}
+	}
+	p.hlock.Unlock()
+	if len(handlers) > 0 {
+		log.Infof("cluster(%s) addr(%s) drain the client connections of old config", b.cc.Name, b.cc.ListenAddr)
+	}
+	p.drain(handlers, time.Duration(p.conf().Proxy.DrainTimeout)*time.Millisecond)
+	b.forwarder.Close()
+}
+
+// changedFields returns the toml keys of changed fields, the order of servers is ignored.
+func changedFields(old, cc *ClusterConfig) []string {
+	oc, nc := *old, *cc
+	oc.Servers, nc.Servers = sortedCopy(old.Servers), sortedCopy(cc.Servers)
+	return diffFields(reflect.ValueOf(oc), reflect.ValueOf(nc))
+}
+
+// diffFields returns the toml keys of different exported fields of two structs, the key is the lower name if no tag.
+func diffFields(ov, nv reflect.Value) (changed []string) {
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		changed = append(changed, key)
+	}
+	return
+}
+
+func sortedCopy(ss []string) []string {
+	cp := make([]string, len(ss))
+	copy(cp, ss)
+	sort.Strings(cp)
+	return cp
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/log"
+	"mycache/pkg/prom"
+	"mycache/proxy/proto"
+)
+
+// balance methods of replicas
+const (
+	replicaRoundRobin   = "round_robin"
+	replicaLeastPending = "least_pending"
+)
+
+// replica the read only node of master.
+type replica struct {
+	addr string
+	ncp  *proto.NodeConnPipe
+	down int32 // ping失败次数达到上限时为1，不再分配读请求
+}
+
+func (r *replica) isDown() bool {
+	return atomic.LoadInt32(&r.down) == 1
+}
+
+// replicaSet the replicas of master, the reads of READONLY clients are balanced on the healthy ones.
+type replicaSet struct {
+	leastPending bool
+	replicas     []*replica
+	next         uint32
+}
+
+// pick a healthy replica, false if all replicas are down.
+func (rs *replicaSet) pick() (r *replica, ok bool) {
+	if rs.leastPending {
+		pending := 0
+		for _, rp := range rs.replicas {
+			if rp.isDown() {
+				continue
+			}
+			if n := rp.ncp.Pending(); r == nil || n < pending {
+				r, pending = rp, n
+			}
+		}
+		return r, r != nil
+	}
+	n := uint32(len(rs.replicas))
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if rp := rs.replicas[(start+i)%n]; !rp.isDown() {
+			return rp, true
+		}
+	}
+	return nil, false
+}
+
+// initReplicas build the replicas of masters, the pipes of old replicas with same address are reused.
+func (c *connections) initReplicas(oldReplicas map[string]*replicaSet) map[string]bool {
+	old := map[string]*proto.NodeConnPipe{}
+	for _, rs := range oldReplicas {
+		for _, r := range rs.replicas {
+			old[r.addr] = r.ncp
+		}
+	}
+	copyed := make(map[string]bool)
+	c.replicas = make(map[string]*replicaSet)
+	for idx, addr := range c.addrs {
+		addrs, ok := c.cc.Replicas[addr]
+		if !ok && c.alias {
+			addrs, ok = c.cc.Replicas[c.ans[idx]]
+		}
+		if !ok || len(addrs) == 0 {
+			continue
+		}
+		rs := &replicaSet{leastPending: c.cc.ReplicaBalance == replicaLeastPending}
+		for _, raddr := range addrs {
+			toAddr := raddr // NOTE: avoid closure
+			r := &replica{addr: toAddr}
+			if ncp, ok := old[toAddr]; ok {
+				r.ncp = ncp
+				copyed[toAddr] = true
+			} else {
+				r.ncp = proto.NewNodeConnPipe(c.cc.NodeConnections, func() proto.NodeConn {
+					return newNodeConn(c.cc, toAddr)
+				})
+			}
+			rs.replicas = append(rs.replicas, r)
+		}
+		c.replicas[addr] = rs
+	}
+	return copyed
+}
+
+// replicaPipe returns the replica for the read of READONLY client, the master is returned if no replica is healthy.
+func (c *connections) replicaPipe(addr string, ncp *proto.NodeConnPipe, req proto.Request) (string, *proto.NodeConnPipe) {
+	if rr, ok := req.(proto.ReplicaRequest); !ok || !rr.ReadReplica() {
+		return addr, ncp
+	}
+	rs, ok := c.replicas[addr]
+	if !ok {
+		return addr, ncp
+	}
+	r, ok := rs.pick()
+	if !ok {
+		return addr, ncp
+	}
+	return r.addr, r.ncp
+}
+
+// replicaInfos returns the state of replicas of master.
+func (c *connections) replicaInfos(addr string) (infos []*proto.NodeInfo) {
+	rs, ok := c.replicas[addr]
+	if !ok {
+		return
+	}
+	for _, r := range rs.replicas {
+		infos = append(infos, &proto.NodeInfo{Addr: r.addr, Alias: r.addr, Ejected: r.isDown(), Pending: r.ncp.Pending()})
+	}
+	return
+}
+
+// startReplicaPinger ping the replicas to take the down ones out of balance.
+func (c *connections) startReplicaPinger() {
+	for _, rs := range c.replicas {
+		for _, r := range rs.replicas {
+			go c.processReplicaPing(r)
+		}
+	}
+}
+
+func (c *connections) processReplicaPing(r *replica) {
+	var failure int
+	ping := newPingConn(c.cc, r.addr)
+	for {
+		select {
+		case <-c.ctx.Done():
+			_ = ping.Close()
+			return
+		default:
+		}
+		if err := ping.Ping(); err == nil {
+			failure = 0
+			if atomic.CompareAndSwapInt32(&r.down, 1, 0) {
+				if prom.On {
+					prom.PingEvent(c.cc.Name, r.addr, prom.PingReadd)
+				}
+				log.Infof("ping replica addr:%s success and readd", r.addr)
+			}
+		} else {
+			_ = ping.Close()
+			failure++
+			if prom.On {
+				prom.PingEvent(c.cc.Name, r.addr, prom.PingFail)
+			}
+			if failure >= c.cc.PingFailLimit && atomic.CompareAndSwapInt32(&r.down, 0, 1) {
+				if prom.On {
+					prom.PingEvent(c.cc.Name, r.addr, prom.PingEject)
+				}
+				log.Errorf("ping replica addr:%s fail times:%d ge to limit:%d then reads go to others", r.addr, failure, c.cc.PingFailLimit)
+			}
+			ping = newPingConn(c.cc, r.addr)
+		}
+		time.Sleep(pingSleepTime(false))
+	}
+}
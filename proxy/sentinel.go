@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mycache/pkg/log"
+	libnet "mycache/pkg/net"
+	"mycache/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+// sentinelPrefix the server declared by sentinel master name: sentinel:<master name>:weight.
+const sentinelPrefix = "sentinel:"
+
+// sentinelRetryTime the interval to try the next sentinel when the conn fails.
+var sentinelRetryTime = time.Second
+
+// sentinelWatcher follow the masters of the servers declared by sentinel master name.
+type sentinelWatcher struct {
+	cc      *ClusterConfig
+	masters map[string]string // master name -> 主库地址，由defaultForwarder.lock保护
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock sync.Mutex
+	conn *redis.SentinelConn // 正在订阅的连接，stop时关闭
+}
+
+func newSentinelWatcher(cc *ClusterConfig) *sentinelWatcher {
+	s := &sentinelWatcher{cc: cc, masters: make(map[string]string)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *sentinelWatcher) dial(addr string) *redis.SentinelConn {
+	dto := time.Duration(s.cc.DialTimeout) * time.Millisecond
+	rto := time.Duration(s.cc.ReadTimeout) * time.Millisecond
+	wto := time.Duration(s.cc.WriteTimeout) * time.Millisecond
+	return redis.NewSentinelConn(libnet.DialWithTimeout(addr, dto, rto, wto))
+}
+
+// masterAddr ask the sentinels in turn for the address of master.
+func (s *sentinelWatcher) masterAddr(name string) (addr string, err error) {
+	for _, saddr := range s.cc.Sentinels {
+		sc := s.dial(saddr)
+		addr, err = sc.MasterAddr(name)
+		_ = sc.Close()
+		if err == nil {
+			return
+		}
+		log.Warnf("cluster(%s) sentinel:%s get master:%s error:%v", s.cc.Name, saddr, name, err)
+	}
+	return
+}
+
+// subscribing set the conn to be closed by stop, false if the watcher is stopped.
+func (s *sentinelWatcher) subscribing(sc *redis.SentinelConn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.conn = sc
+	return true
+}
+
+func (s *sentinelWatcher) stop() {
+	s.lock.Lock()
+	s.cancel()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.lock.Unlock()
+}
+
+// isMaster returns the master name of the address from servers.
+func isMaster(addr string) (name string, ok bool) {
+	if !strings.HasPrefix(addr, sentinelPrefix) {
+		return
+	}
+	return addr[len(sentinelPrefix):], true
+}
+
+// resolveMasters replace the master names of servers by the addresses of masters, the names are kept as the aliases on hash ring
+// if the servers have no alias. The master names are returned by the index of servers, empty for the other servers.
+// NOTE: the master which can't be resolved keeps the name as address, the conns fail until the sentinels know it.
+func (f *defaultForwarder) resolveMasters(addrs, ans []string, alias bool) ([]string, []string, bool, []string) {
+	if f.sentinel == nil {
+		return addrs, ans, alias, nil
+	}
+	resolved := make([]string, len(addrs))
+	names := make([]string, len(addrs))
+	for idx, addr := range addrs {
+		resolved[idx] = addr
+		name, ok := isMaster(addr)
+		if !ok {
+			continue
+		}
+		names[idx] = name
+		if maddr, ok := f.sentinel.masters[name]; ok {
+			resolved[idx] = maddr
+			continue
+		}
+		maddr, err := f.sentinel.masterAddr(name)
+		if err != nil {
+			log.Errorf("cluster(%s) resolve master:%s error:%v", f.cc.Name, name, err)
+			continue
+		}
+		f.sentinel.masters[name] = maddr
+		resolved[idx] = maddr
+	}
+	if !alias {
+		// NOTE: the other servers keep their addresses as names, so the ring is the same as without alias
+		ans, alias = addrs, true
+	}
+	return resolved, ans, alias, names
+}
+
+// watchSentinel follow the switches of masters until the forwarder is closed, the sentinels are tried in turn.
+func (f *defaultForwarder) watchSentinel() {
+	s := f.sentinel
+	for i := 0; ; i++ {
+		addr := f.cc.Sentinels[i%len(f.cc.Sentinels)]
+		err := f.followSentinel(addr)
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+		log.Errorf("cluster(%s) follow sentinel:%s error:%v", f.cc.Name, addr, err)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(sentinelRetryTime):
+		}
+	}
+}
+
+// followSentinel subscribe +switch-master of sentinel and switch the masters.
+func (f *defaultForwarder) followSentinel(addr string) (err error) {
+	sc := f.sentinel.dial(addr)
+	if !f.sentinel.subscribing(sc) {
+		_ = sc.Close()
+		return
+	}
+	defer sc.Close()
+	if err = sc.SubscribeSwitch(); err != nil {
+		return
+	}
+	// NOTE: the switches before subscribing are caught up by asking the masters again
+	if err = f.syncMasters(addr); err != nil {
+		return
+	}
+	for {
+		name, maddr, rerr := sc.ReceiveSwitch()
+		if rerr != nil {
+			return rerr
+		}
+		f.switchMaster(name, maddr)
+	}
+}
+
+// syncMasters ask the sentinel for all masters of servers and switch the changed ones.
+func (f *defaultForwarder) syncMasters(addr string) error {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return errors.WithStack(ErrConnectionNotExist)
+	}
+	sc := f.sentinel.dial(addr)
+	defer sc.Close()
+	for _, name := range conns.masterNames {
+		if name == "" {
+			continue
+		}
+		maddr, err := sc.MasterAddr(name)
+		if err != nil {
+			return err
+		}
+		f.switchMaster(name, maddr)
+	}
+	return nil
+}
+
+// switchMaster swap the pipe of the server declared by master name to the new master, the ring positions are not changed.
+func (f *defaultForwarder) switchMaster(name, addr string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if atomic.LoadInt32(&f.state) == forwarderStateClosed {
+		return
+	}
+	oldConns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return
+	}
+	idx, ok := oldConns.master(name)
+	if !ok {
+		return
+	}
+	f.sentinel.masters[name] = addr
+	if oldConns.addrs[idx] == addr {
+		return
+	}
+	log.Infof("cluster(%s) master:%s is switched from %s to %s", f.cc.Name, name, oldConns.addrs[idx], addr)
+	addrs := make([]string, len(oldConns.addrs))
+	copy(addrs, oldConns.addrs)
+	addrs[idx] = addr
+	ws := make([]int, len(addrs))
+	for i := range ws {
+		ws[i] = oldConns.weight(i)
+	}
+	f.swapConns(oldConns, addrs, oldConns.ans, ws, oldConns.alias, oldConns.masterNames)
+}
+
+// master returns the index of the server declared by master name.
+func (c *connections) master(name string) (idx int, ok bool) {
+	for idx = range c.masterNames {
+		if c.masterNames[idx] == name {
+			return idx, true
+		}
+	}
+	return -1, false
+}
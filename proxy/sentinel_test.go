@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mycache/pkg/types"
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakeSentinel replies get-master-addr-by-name and publishes +switch-master to the subscribers.
+type _fakeSentinel struct {
+	net.Listener
+	lock    sync.Mutex
+	masters map[string]string
+	subs    []net.Conn
+}
+
+func _newFakeSentinel(t *testing.T, masters map[string]string) *_fakeSentinel {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &_fakeSentinel{Listener: l, masters: masters}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *_fakeSentinel) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			_, _ = br.ReadString('\n')
+			arg, _ := br.ReadString('\n')
+			args = append(args, strings.TrimSpace(arg))
+		}
+		s.lock.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SENTINEL":
+			if addr, ok := s.masters[args[2]]; ok {
+				host, port, _ := net.SplitHostPort(addr)
+				fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+			} else {
+				fmt.Fprint(conn, "*-1\r\n")
+			}
+		case "SUBSCRIBE":
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+			s.subs = append(s.subs, conn)
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *_fakeSentinel) subscribers() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.subs)
+}
+
+// switchMaster change the master and publish it, the subscribers are disconnected without publishing if silent.
+func (s *_fakeSentinel) switchMaster(name, addr string, silent bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old := s.masters[name]
+	s.masters[name] = addr
+	oldHost, oldPort, _ := net.SplitHostPort(old)
+	host, port, _ := net.SplitHostPort(addr)
+	payload := strings.Join([]string{name, oldHost, oldPort, host, port}, " ")
+	for _, conn := range s.subs {
+		if silent {
+			_ = conn.Close()
+			continue
+		}
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$%d\r\n%s\r\n", len(payload), payload)
+	}
+	if silent {
+		s.subs = nil
+	}
+}
+
+func TestForwarderSentinel(t *testing.T) {
+	sentinelRetryTime = 10 * time.Millisecond
+	sentinel := _newFakeSentinel(t, map[string]string{"mymaster": "127.0.0.1:6380"})
+	defer sentinel.Close()
+	cc := &ClusterConfig{Name: "sentinel", CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1", "sentinel:mymaster:1"},
+		Sentinels: []string{sentinel.Addr().String()}, DialTimeout: 100, ReadTimeout: 100, WriteTimeout: 100}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+
+	nodes := func() (addrs []string) {
+		for _, info := range f.NodeInfos() {
+			addrs = append(addrs, info.Addr)
+		}
+		return
+	}
+	assert.Equal(t, []string{"127.0.0.1:7000", "127.0.0.1:6380"}, nodes())
+	assert.Equal(t, "sentinel:mymaster", f.NodeInfos()[1].Alias)
+	keys := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		keys[key], _ = f.KeyAddr([]byte(key))
+	}
+	// the ring positions are not changed by the switch
+	assertRing := func(master string) {
+		for key, addr := range keys {
+			if addr == "127.0.0.1:6380" {
+				addr = master
+			}
+			got, _ := f.KeyAddr([]byte(key))
+			assert.Equal(t, addr, got, key)
+		}
+	}
+
+	assert.Eventually(t, func() bool { return sentinel.subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	sentinel.switchMaster("other", "127.0.0.1:6390", false)
+	sentinel.switchMaster("mymaster", "127.0.0.1:6381", false)
+	assert.Eventually(t, func() bool { return nodes()[1] == "127.0.0.1:6381" }, time.Second, 10*time.Millisecond)
+	assertRing("127.0.0.1:6381")
+
+	// the switch missed when disconnected is caught up after subscribing again
+	sentinel.switchMaster("mymaster", "127.0.0.1:6382", true)
+	assert.Eventually(t, func() bool { return nodes()[1] == "127.0.0.1:6382" }, time.Second, 10*time.Millisecond)
+	assertRing("127.0.0.1:6382")
+
+	// reload keeps the current master
+	assert.NoError(t, f.Update(cc.Servers))
+	assert.Equal(t, []string{"127.0.0.1:7000", "127.0.0.1:6382"}, nodes())
+	assertRing("127.0.0.1:6382")
+}
+
+func TestForwarderSentinelAlias(t *testing.T) {
+	sentinelRetryTime = 10 * time.Millisecond
+	sentinel := _newFakeSentinel(t, map[string]string{"mymaster": "127.0.0.1:6380"})
+	defer sentinel.Close()
+	cc := &ClusterConfig{Name: "sentinel", CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1 redis1", "sentinel:mymaster:1 redis3"},
+		Sentinels: []string{sentinel.Addr().String()}, DialTimeout: 100, ReadTimeout: 100, WriteTimeout: 100}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+
+	node := func() *proto.NodeInfo {
+		return f.NodeInfos()[1]
+	}
+	assert.Equal(t, "127.0.0.1:6380", node().Addr)
+	assert.Equal(t, "redis3", node().Alias)
+
+	assert.Eventually(t, func() bool { return sentinel.subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	sentinel.switchMaster("mymaster", "127.0.0.1:6381", false)
+	assert.Eventually(t, func() bool { return node().Addr == "127.0.0.1:6381" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "redis3", node().Alias)
+
+	// the switch missed when disconnected is caught up after subscribing again
+	sentinel.switchMaster("mymaster", "127.0.0.1:6382", true)
+	assert.Eventually(t, func() bool { return node().Addr == "127.0.0.1:6382" }, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, f.Update(cc.Servers))
+	assert.Equal(t, "127.0.0.1:6382", node().Addr)
+	assert.Equal(t, "redis3", node().Alias)
+}
+
+func TestForwarderSentinelKeepEjected(t *testing.T) {
+	sentinelRetryTime = 10 * time.Millisecond
+	sentinel := _newFakeSentinel(t, map[string]string{"mymaster": "127.0.0.1:6380"})
+	defer sentinel.Close()
+	cc := &ClusterConfig{Name: "sentinel", CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:7000:1", "sentinel:mymaster:1"},
+		Sentinels: []string{sentinel.Addr().String()}, DialTimeout: 100, ReadTimeout: 100, WriteTimeout: 100}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+	assert.Eventually(t, func() bool { return sentinel.subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, f.EjectNode("127.0.0.1:7000"))
+	sentinel.switchMaster("mymaster", "127.0.0.1:6381", false)
+	assert.Eventually(t, func() bool { return f.NodeInfos()[1].Addr == "127.0.0.1:6381" }, time.Second, 10*time.Millisecond)
+
+	// the node ejected by admin stays out of ring after the switch
+	assert.True(t, f.NodeInfos()[0].Ejected)
+	for i := 0; i < 100; i++ {
+		addr, _ := f.KeyAddr([]byte(fmt.Sprintf("k%d", i)))
+		assert.Equal(t, "127.0.0.1:6381", addr)
+	}
+	conns := f.conns.Load().(*connections)
+	assert.False(t, conns.pingReadd(&pinger{addr: "127.0.0.1:7000", alias: "127.0.0.1:7000"}))
+
+	assert.NoError(t, f.ReaddNode("127.0.0.1:7000"))
+	assert.False(t, f.NodeInfos()[0].Ejected)
+}
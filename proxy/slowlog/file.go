@@ -0,0 +1,137 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"mycache/pkg/log"
+	"mycache/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dailyRolling = "2006-01-02"
+	// 等待写文件的慢日志数，满了直接丢弃，不阻塞请求
+	outputChanSize = 1024
+)
+
+var (
+	outLock sync.RWMutex
+	out     *fileOutput
+)
+
+// Init write every slowlog as a json line into the file which is rolled daily like file.2006-01-02.
+// NOTE: the slowlog is kept in memory only if file is empty.
+func Init(file string) error {
+	if file == "" {
+		return nil
+	}
+	if _, name := filepath.Split(file); name == "" {
+		return errors.Errorf("invalid slowlog file:%s", file)
+	}
+	o := &fileOutput{basePath: file, ch: make(chan *proto.SlowlogEntry, outputChanSize), done: make(chan struct{})}
+	if err := o.roll(); err != nil {
+		return err
+	}
+	outLock.Lock()
+	old := out
+	out = o
+	outLock.Unlock()
+	if old != nil {
+		old.close()
+	}
+	go o.run()
+	return nil
+}
+
+// Close flush and close the file output.
+func Close() {
+	outLock.Lock()
+	o := out
+	out = nil
+	outLock.Unlock()
+	if o != nil {
+		o.close()
+	}
+}
+
+func output(e *proto.SlowlogEntry) {
+	outLock.RLock()
+	if out != nil {
+		select {
+		case out.ch <- e:
+		default:
+			if log.V(3) {
+				log.Warnf("slowlog of cluster:%s is dropped due to the file output is busy", e.Cluster)
+			}
+		}
+	}
+	outLock.RUnlock()
+}
+
+type fileOutput struct {
+	basePath string
+	filePath string
+	fileFrag string
+	f        *os.File
+
+	ch   chan *proto.SlowlogEntry
+	done chan struct{}
+}
+
+func (o *fileOutput) run() {
+	defer close(o.done)
+	for e := range o.ch {
+		bs, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("fail to marshal slowlog with error:%v", err)
+			continue
+		}
+		if err = o.roll(); err != nil {
+			log.Errorf("fail to roll slowlog file:%s with error:%v", o.basePath, err)
+			continue
+		}
+		if _, err = o.f.Write(append(bs, '\n')); err != nil {
+			log.Errorf("fail to write slowlog file:%s with error:%v", o.filePath, err)
+		}
+	}
+	if o.f != nil {
+		o.f.Close()
+	}
+}
+
+// close wait the entries in chan are written.
+// NOTE: outLock must not be held, the senders hold it.
+func (o *fileOutput) close() {
+	close(o.ch)
+	<-o.done
+}
+
+func (o *fileOutput) roll() error {
+	suffix := time.Now().Format(dailyRolling)
+	if o.f != nil {
+		if suffix == o.fileFrag {
+			return nil
+		}
+		o.f.Close()
+		o.f = nil
+	}
+	o.fileFrag = suffix
+	o.filePath = fmt.Sprintf("%s.%s", o.basePath, o.fileFrag)
+	if dir, _ := filepath.Split(o.basePath); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	f, err := os.OpenFile(o.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	o.f = f
+	return nil
+}
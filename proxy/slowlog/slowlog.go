@@ -0,0 +1,90 @@
+package slowlog
+
+import (
+	"sync"
+
+	"mycache/proxy/proto"
+)
+
+// maxEntries 每个集群内存里保留的慢日志条数
+const maxEntries = 128
+
+// Handler records the slow commands of cluster.
+type Handler interface {
+	Record(e *proto.SlowlogEntry)
+	Get(n int) []*proto.SlowlogEntry
+	Len() int
+	Reset()
+}
+
+// handlers cluster name -> *handler
+var handlers sync.Map
+
+// Get returns the slowlog handler of cluster, the handler is created on the first call.
+func Get(cluster string) Handler {
+	if h, ok := handlers.Load(cluster); ok {
+		return h.(*handler)
+	}
+	h, _ := handlers.LoadOrStore(cluster, &handler{cluster: cluster, entries: make([]*proto.SlowlogEntry, maxEntries)})
+	return h.(*handler)
+}
+
+// handler keeps the latest entries in a ring and writes every entry to the file output.
+type handler struct {
+	cluster string
+
+	lock    sync.Mutex
+	id      int64 // 下一条慢日志的id，RESET后也不会重复
+	entries []*proto.SlowlogEntry
+	next    int // 下一条写入的位置
+	count   int
+}
+
+// Record record the entry with a unique id.
+func (h *handler) Record(e *proto.SlowlogEntry) {
+	if e == nil {
+		return
+	}
+	h.lock.Lock()
+	e.ID = h.id
+	e.Cluster = h.cluster
+	h.id++
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.count < len(h.entries) {
+		h.count++
+	}
+	h.lock.Unlock()
+	output(e)
+}
+
+// Get returns the latest n entries, the newest is the first, all entries are returned if n is negative.
+func (h *handler) Get(n int) []*proto.SlowlogEntry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if n < 0 || n > h.count {
+		n = h.count
+	}
+	es := make([]*proto.SlowlogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		es = append(es, h.entries[(h.next-i+len(h.entries))%len(h.entries)])
+	}
+	return es
+}
+
+// Len returns the number of entries in memory.
+func (h *handler) Len() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+// Reset drop all entries in memory.
+func (h *handler) Reset() {
+	h.lock.Lock()
+	for i := range h.entries {
+		h.entries[i] = nil
+	}
+	h.next, h.count = 0, 0
+	h.lock.Unlock()
+}
@@ -0,0 +1,60 @@
+package slowlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mycache/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerRing(t *testing.T) {
+	h := Get("ring")
+	assert.True(t, h == Get("ring"))
+	for i := 0; i < maxEntries+2; i++ {
+		h.Record(&proto.SlowlogEntry{Cmd: []string{"GET", fmt.Sprint(i)}})
+	}
+	assert.Equal(t, maxEntries, h.Len())
+	es := h.Get(2)
+	assert.Len(t, es, 2)
+	assert.Equal(t, int64(maxEntries+1), es[0].ID)
+	assert.Equal(t, int64(maxEntries), es[1].ID)
+	assert.Equal(t, "ring", es[0].Cluster)
+	assert.Len(t, h.Get(-1), maxEntries)
+
+	h.Reset()
+	assert.Equal(t, 0, h.Len())
+	assert.Len(t, h.Get(10), 0)
+	h.Record(&proto.SlowlogEntry{})
+	assert.Equal(t, int64(maxEntries+2), h.Get(1)[0].ID)
+}
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slowlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "slow.log")
+	assert.NoError(t, Init(base))
+	Get("file").Record(&proto.SlowlogEntry{Cmd: []string{"SET", "a", "b"}, TotalDur: time.Second})
+	Close()
+
+	f, err := os.Open(base + "." + time.Now().Format(dailyRolling))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	assert.True(t, sc.Scan())
+	e := &proto.SlowlogEntry{}
+	assert.NoError(t, json.Unmarshal(sc.Bytes(), e))
+	assert.Equal(t, "file", e.Cluster)
+	assert.Equal(t, []string{"SET", "a", "b"}, e.Cmd)
+	assert.Equal(t, time.Second, e.TotalDur)
+}